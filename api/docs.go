@@ -74,12 +74,73 @@ const docTemplate = `{
                             "type": "string"
                         }
                     },
+                    "400": {
+                        "description": "Недопустимый URL: разрешены только http и https ссылки с указанием хоста",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
                     "409": {
                         "description": "Запрашиваемый URL уже существует",
                         "schema": {
                             "type": "string"
                         }
                     },
+                    "413": {
+                        "description": "Распакованное тело запроса превышает MAX_DECOMPRESSED_SIZE",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "415": {
+                        "description": "Неподдерживаемый Content-Encoding, поддерживаются gzip, deflate и br",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "422": {
+                        "description": "URL запрещен политикой, в ответе указан идентификатор правила",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Внутренняя ошибка сервера"
+                    }
+                }
+            }
+        },
+        "/api/internal/stats": {
+            "get": {
+                "consumes": [
+                    "text/plain"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Internal"
+                ],
+                "summary": "Запрос количества сокращенных ссылок и пользователей сервиса",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "IP адрес клиента из доверенной подсети",
+                        "name": "X-Real-IP",
+                        "in": "header",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Статистика сервиса",
+                        "schema": {
+                            "$ref": "#/definitions/webhandlers.internalStats"
+                        }
+                    },
+                    "403": {
+                        "description": "Клиент не входит в доверенную подсеть"
+                    },
                     "500": {
                         "description": "Внутренняя ошибка сервера"
                     }
@@ -123,14 +184,35 @@ const docTemplate = `{
                         }
                     },
                     "400": {
-                        "description": "Неверный запрос"
+                        "description": "Неверный запрос, недопустимый URL или псевдоним",
+                        "schema": {
+                            "type": "string"
+                        }
                     },
                     "409": {
-                        "description": "Запрашиваемый URL уже существует",
+                        "description": "Запрашиваемый URL уже существует или псевдоним занят",
                         "schema": {
                             "$ref": "#/definitions/webhandlers.sURL"
                         }
                     },
+                    "413": {
+                        "description": "Распакованное тело запроса превышает MAX_DECOMPRESSED_SIZE",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "415": {
+                        "description": "Неподдерживаемый Content-Encoding, поддерживаются gzip, deflate и br",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "422": {
+                        "description": "URL запрещен политикой, в ответе указан идентификатор правила",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
                     "500": {
                         "description": "Внутренняя ошибка сервера"
                     }
@@ -139,11 +221,14 @@ const docTemplate = `{
         },
         "/api/shorten/batch": {
             "post": {
+                "description": "Список читается и обрабатывается потоково частями по 1000 элементов, каждая часть записывается атомарно.\nКод ответа определяется первой частью списка. Каждому элементу списка соответствует один результат.\nЕсли обработка прервана ошибкой после записи предыдущих частей, последней записью ответа передается\nобъект без correlation_id со статусом invalid и причиной в поле error.\nС заголовком X-Full-Duplex: true результаты частей отправляются клиенту во время чтения списка,\nклиент должен читать ответ одновременно с отправкой запроса. Без заголовка ответ отправляется после чтения всего списка,\nрезультаты до отправки накапливаются во временном файле.\nСсылки, запрещенные политикой, возвращаются со статусом invalid и идентификатором правила в поле error.",
                 "consumes": [
-                    "application/json"
+                    "application/json",
+                    "application/x-ndjson"
                 ],
                 "produces": [
-                    "application/json"
+                    "application/json",
+                    "application/x-ndjson"
                 ],
                 "tags": [
                     "APICreate"
@@ -157,25 +242,58 @@ const docTemplate = `{
                         "in": "header"
                     },
                     {
-                        "description": "Список сокращаемых URLs",
+                        "type": "boolean",
+                        "description": "Клиент читает ответ во время отправки списка",
+                        "name": "X-Full-Duplex",
+                        "in": "header"
+                    },
+                    {
+                        "description": "Список сокращаемых URLs: JSON массив или по одному объекту в строке",
                         "name": "Input",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/webhandlers.input"
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/webhandlers.input"
+                            }
                         }
                     }
                 ],
                 "responses": {
+                    "200": {
+                        "description": "Список обработан, новых ссылок нет",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/webhandlers.output"
+                            }
+                        }
+                    },
                     "201": {
-                        "description": "Список успешно обработан",
+                        "description": "Список обработан, хотя бы одна ссылка сокращена",
                         "schema": {
-                            "$ref": "#/definitions/webhandlers.output"
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/webhandlers.output"
+                            }
                         }
                     },
                     "400": {
                         "description": "Неверный запрос"
                     },
+                    "413": {
+                        "description": "Распакованное тело запроса превышает MAX_DECOMPRESSED_SIZE",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "415": {
+                        "description": "Неподдерживаемый Content-Encoding, поддерживаются gzip, deflate и br",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
                     "500": {
                         "description": "Внутренняя ошибка сервера"
                     }
@@ -242,7 +360,10 @@ const docTemplate = `{
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "type": "string"
+                            "type": "array",
+                            "items": {
+                                "type": "string"
+                            }
                         }
                     },
                     {
@@ -257,14 +378,146 @@ const docTemplate = `{
                     "202": {
                         "description": "Запрос принят в обработку"
                     },
+                    "400": {
+                        "description": "Неверный JSON или идентификатор в теле запроса",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "413": {
+                        "description": "Распакованное тело запроса превышает MAX_DECOMPRESSED_SIZE",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "415": {
+                        "description": "Неподдерживаемый Content-Encoding, поддерживаются gzip, deflate и br",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
                     "500": {
                         "description": "Внутренняя ошибка сервера"
                     }
                 }
             }
+        },
+        "/api/user/urls/{tag}/stats": {
+            "get": {
+                "consumes": [
+                    "text/plain"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "ListAll"
+                ],
+                "summary": "Запрос статистики переходов по сокращенной ссылке пользователя",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Идентификационный cookie Client_ID",
+                        "name": "Client_ID",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Идентификатор короткой ссылки",
+                        "name": "tag",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Статистика переходов",
+                        "schema": {
+                            "$ref": "#/definitions/models.Stats"
+                        }
+                    },
+                    "400": {
+                        "description": "Неверный идентификатор короткой ссылки"
+                    },
+                    "404": {
+                        "description": "Короткая ссылка не принадлежит пользователю"
+                    },
+                    "500": {
+                        "description": "Внутренняя ошибка сервера",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
         }
     },
     "definitions": {
+        "models.DailyClicks": {
+            "type": "object",
+            "properties": {
+                "clicks": {
+                    "description": "Clicks - clicks count",
+                    "type": "integer"
+                },
+                "date": {
+                    "description": "Date - day in YYYY-MM-DD format",
+                    "type": "string"
+                }
+            }
+        },
+        "models.Stats": {
+            "type": "object",
+            "properties": {
+                "clicks": {
+                    "description": "Clicks - total clicks count",
+                    "type": "integer"
+                },
+                "daily": {
+                    "description": "Daily - clicks count by day in UTC",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.DailyClicks"
+                    }
+                },
+                "tag": {
+                    "description": "Tag - short url tag",
+                    "type": "string"
+                },
+                "top_countries": {
+                    "description": "Countries - most frequent client countries",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.TopItem"
+                    }
+                },
+                "top_referrers": {
+                    "description": "Referrers - most frequent referrers",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.TopItem"
+                    }
+                },
+                "unique_visitors": {
+                    "description": "Unique - count of unique client ip hashes",
+                    "type": "integer"
+                }
+            }
+        },
+        "models.TopItem": {
+            "type": "object",
+            "properties": {
+                "clicks": {
+                    "description": "Clicks - clicks count",
+                    "type": "integer"
+                },
+                "name": {
+                    "description": "Name - referrer or country code",
+                    "type": "string"
+                }
+            }
+        },
         "webhandlers.answer": {
             "type": "object",
             "properties": {
@@ -282,14 +535,43 @@ const docTemplate = `{
                 "correlation_id": {
                     "type": "string"
                 },
+                "custom_alias": {
+                    "type": "string"
+                },
+                "expires_at": {
+                    "type": "string"
+                },
                 "original_url": {
                     "type": "string"
+                },
+                "ttl_seconds": {
+                    "type": "integer"
+                }
+            }
+        },
+        "webhandlers.internalStats": {
+            "type": "object",
+            "properties": {
+                "urls": {
+                    "type": "integer"
+                },
+                "users": {
+                    "type": "integer"
                 }
             }
         },
         "webhandlers.lURL": {
             "type": "object",
             "properties": {
+                "custom_alias": {
+                    "type": "string"
+                },
+                "expires_at": {
+                    "type": "string"
+                },
+                "ttl_seconds": {
+                    "type": "integer"
+                },
                 "url": {
                     "type": "string"
                 }
@@ -301,8 +583,19 @@ const docTemplate = `{
                 "correlation_id": {
                     "type": "string"
                 },
+                "error": {
+                    "type": "string"
+                },
                 "short_url": {
                     "type": "string"
+                },
+                "status": {
+                    "type": "string",
+                    "enum": [
+                        "created",
+                        "exists",
+                        "invalid"
+                    ]
                 }
             }
         },
@@ -0,0 +1,4 @@
+//Package shortener - gRPC API сервиса сокращения ссылок
+package shortener
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative shortener.proto
@@ -1,127 +1,543 @@
-package config
-
-import (
-	"flag"
-	"fmt"
-	"log"
-
-	"github.com/caarlos0/env"
-
-	"github.com/t1mon-ggg/go_shortner/app/storage"
-)
-
-//Config configuration struct
-type Config struct {
-	BaseURL         string `env:"BASE_URL"`          //BaseURL - default url base.
-	ServerAddress   string `env:"SERVER_ADDRESS"`    //ServerAddress - adress where http server will start
-	FileStoragePath string `env:"FILE_STORAGE_PATH"` //FileStoragePath - path file storage
-	Database        string `env:"DATABASE_DSN"`      //Database - databse dsn connection string
-}
-
-//NewConfig - создание новой минимальной конфигурации, чтение переменных окружения и флагов коммандной строки
-func New() *Config {
-	s := Config{
-		BaseURL:         "http://127.0.0.1:8080",
-		ServerAddress:   "127.0.0.1:8080",
-		FileStoragePath: "",
-		Database:        "",
-	}
-	err := s.readEnv()
-	if err != nil {
-		log.Fatal(err)
-	}
-	s.readCli()
-	resultconfig := fmt.Sprintf("Result config:\nBASE_URL=%s\nSERVER_ADDRESS=%s\nFILE_STORAGE_PATH=%s\nDATABASE_DSN=%s\n", s.BaseURL, s.ServerAddress, s.FileStoragePath, s.Database)
-	log.Println(resultconfig)
-	return &s
-}
-
-//ReadEnv - чтение переменных окружения
-func (cfg *Config) readEnv() error {
-	var c Config
-	err := env.Parse(&c)
-	if err != nil {
-		return err
-	}
-	if c.BaseURL != "" {
-		cfg.BaseURL = c.BaseURL
-	}
-	if c.ServerAddress != "" {
-		cfg.ServerAddress = c.ServerAddress
-	}
-	if c.FileStoragePath != "" {
-		cfg.FileStoragePath = c.FileStoragePath
-	}
-	if c.Database != "" {
-		cfg.Database = c.Database
-	}
-	parsed := fmt.Sprintf("Evironment parsed:\nBASE_URL=%s\nSERVER_ADDRESS=%s\nFILE_STORAGE_PATH=%s\nDATABASE_DSN=%s\n", c.BaseURL, c.ServerAddress, c.FileStoragePath, c.Database)
-	log.Println(parsed)
-	return nil
-}
-
-//flags - map for flag iterate
-var flags = map[string]string{
-	"b": "BASE_URL",
-	"a": "SERVER_ADDRESS",
-	"f": "FILE_STORAGE_PATH",
-	"d": "DATABASE_DSN",
-}
-
-//command line flags
-var (
-	baseURL  = flag.String("b", "", flags["b"])
-	srvAddr  = flag.String("a", "", flags["a"])
-	filePath = flag.String("f", "", flags["f"])
-	dbPath   = flag.String("d", "", flags["d"])
-)
-
-//ReadCli - чтение флагов командной строки
-func (cfg *Config) readCli() {
-	flag.Parse()
-	for flag, info := range flags {
-		if isFlagPassed(flag) {
-			switch info {
-			case "BASE_URL":
-				cfg.BaseURL = *baseURL
-			case "SERVER_ADDRESS":
-				cfg.ServerAddress = *srvAddr
-			case "FILE_STORAGE_PATH":
-				cfg.FileStoragePath = *filePath
-			case "DATABASE_DSN":
-				cfg.Database = *dbPath
-			}
-		}
-	}
-	parsed := fmt.Sprintf("Flags parsed:\nBASE_URL=%s\nSERVER_ADDRESS=%s\nFILE_STORAGE_PATH=%s\nDATABASE_DSN=%s\n", *baseURL, *srvAddr, *filePath, *dbPath)
-	log.Println(parsed)
-
-}
-
-//isFlagPassed - проверка применение флага
-func isFlagPassed(name string) bool {
-	found := false
-	flag.Visit(func(f *flag.Flag) {
-		if f.Name == name {
-			found = true
-		}
-	})
-	return found
-}
-
-//NewStorage - создание хранилища
-func (cfg *Config) NewStorage() (storage.Storage, error) {
-	if cfg.Database != "" {
-		s, err := storage.NewPostgreSQL(cfg.Database)
-		if err != nil {
-			return nil, err
-		}
-		return s, nil
-	}
-	if cfg.FileStoragePath != "" {
-		stor := storage.NewFile(cfg.FileStoragePath)
-		return stor, nil
-	}
-	s := storage.NewRAM()
-	return s, nil
-}
+package config
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caarlos0/env"
+	"gopkg.in/yaml.v3"
+
+	"github.com/t1mon-ggg/go_shortner/app/helpers"
+	"github.com/t1mon-ggg/go_shortner/app/policy"
+	"github.com/t1mon-ggg/go_shortner/app/storage"
+)
+
+//default urls
+const (
+	defaultBaseURL      = "http://127.0.0.1:8080"  //defaultBaseURL - url base for http server
+	defaultHTTPSBaseURL = "https://127.0.0.1:8080" //defaultHTTPSBaseURL - url base for https server
+)
+
+//short url tag length limits
+const (
+	MinTagLength = 6  //MinTagLength - minimal length of short url tag
+	MaxTagLength = 24 //MaxTagLength - maximal length of short url tag
+)
+
+//defaultMaxDecompressedSize - ограничение размера распакованного тела запроса по умолчанию
+const defaultMaxDecompressedSize = 32 << 20
+
+//MinClickSecret - минимальная длина ключа хеширования адресов клиентов
+const MinClickSecret = 16
+
+//defaultPolicyReload - период проверки изменений файла политики по умолчанию
+const defaultPolicyReload = 5 * time.Second
+
+//Duration - продолжительность, записываемая в конфигурации строкой вида "5s"
+type Duration time.Duration
+
+//UnmarshalText - разбор продолжительности из строки
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+//Set - разбор продолжительности из флага командной строки
+func (d *Duration) Set(value string) error {
+	return d.UnmarshalText([]byte(value))
+}
+
+//MarshalText - запись продолжительности строкой
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+//String - продолжительность строкой вида "5s"
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+//Config configuration struct
+type Config struct {
+	BaseURL             string   `env:"BASE_URL" json:"base_url" yaml:"base_url"`                                           //BaseURL - default url base.
+	ServerAddress       string   `env:"SERVER_ADDRESS" json:"server_address" yaml:"server_address"`                         //ServerAddress - adress where http server will start
+	FileStoragePath     string   `env:"FILE_STORAGE_PATH" json:"file_storage_path" yaml:"file_storage_path"`                //FileStoragePath - path file storage
+	KVStoragePath       string   `env:"KV_STORAGE_PATH" json:"kv_storage_path" yaml:"kv_storage_path"`                      //KVStoragePath - path to embedded key-value storage
+	SQLitePath          string   `env:"SQLITE_PATH" json:"sqlite_path" yaml:"sqlite_path"`                                  //SQLitePath - path to SQLite database file
+	Database            string   `env:"DATABASE_DSN" json:"database_dsn" yaml:"database_dsn"`                               //Database - databse dsn connection string
+	TrustedSubnet       string   `env:"TRUSTED_SUBNET" json:"trusted_subnet" yaml:"trusted_subnet"`                         //TrustedSubnet - CIDR of clients allowed to read internal stats
+	EnableHTTPS         bool     `env:"ENABLE_HTTPS" json:"enable_https" yaml:"enable_https"`                               //EnableHTTPS - serve https instead of http
+	TLSCertFile         string   `env:"TLS_CERT_FILE" json:"tls_cert_file" yaml:"tls_cert_file"`                            //TLSCertFile - path to PEM certificate, self-signed certificate is generated if empty
+	TLSKeyFile          string   `env:"TLS_KEY_FILE" json:"tls_key_file" yaml:"tls_key_file"`                               //TLSKeyFile - path to PEM private key of certificate
+	GRPCAddress         string   `env:"GRPC_ADDRESS" json:"grpc_address" yaml:"grpc_address"`                               //GRPCAddress - adress where gRPC server will start, gRPC server is disabled if empty
+	DeleteWorkers       int      `env:"DELETE_WORKERS" json:"delete_workers" yaml:"delete_workers"`                         //DeleteWorkers - number of short url delete workers
+	TagLength           int      `env:"TAG_LENGTH" json:"tag_length" yaml:"tag_length"`                                     //TagLength - length of generated short url tag
+	DBWriteTimeout      Duration `env:"DB_WRITE_TIMEOUT" json:"db_write_timeout" yaml:"db_write_timeout"`                   //DBWriteTimeout - timeout of user data write queries
+	DBQueryTimeout      Duration `env:"DB_QUERY_TIMEOUT" json:"db_query_timeout" yaml:"db_query_timeout"`                   //DBQueryTimeout - timeout of read, delete and count queries
+	DBLongTimeout       Duration `env:"DB_LONG_QUERY_TIMEOUT" json:"db_long_query_timeout" yaml:"db_long_query_timeout"`    //DBLongTimeout - timeout of ping, expiration, batch write and click statistics queries
+	DBMigrateTimeout    Duration `env:"DB_MIGRATE_TIMEOUT" json:"db_migrate_timeout" yaml:"db_migrate_timeout"`             //DBMigrateTimeout - timeout of schema migration on startup and by migration commands
+	MaxDecompressedSize int64    `env:"MAX_DECOMPRESSED_SIZE" json:"max_decompressed_size" yaml:"max_decompressed_size"`    //MaxDecompressedSize - limit of decompressed request body in bytes
+	PolicyFile          string   `env:"POLICY_FILE" json:"policy_file" yaml:"policy_file"`                                  //PolicyFile - path to JSON or YAML url policy file, all urls are allowed if empty
+	PolicyReload        Duration `env:"POLICY_RELOAD_INTERVAL" json:"policy_reload_interval" yaml:"policy_reload_interval"` //PolicyReload - period of policy file change check
+	ClickSecret         string   `env:"CLICK_HASH_SECRET" json:"click_hash_secret" yaml:"click_hash_secret"`                //ClickSecret - key of client address hash in click statistics, random key is used if empty
+	ConfigFile          string   `env:"CONFIG" json:"-" yaml:"-"`                                                           //ConfigFile - path to JSON or YAML configuration file
+}
+
+//defaults - конфигурация по умолчанию
+func defaults() Config {
+	return Config{
+		BaseURL:             defaultBaseURL,
+		ServerAddress:       "127.0.0.1:8080",
+		FileStoragePath:     "",
+		KVStoragePath:       "",
+		SQLitePath:          "",
+		Database:            "",
+		TrustedSubnet:       "",
+		EnableHTTPS:         false,
+		TLSCertFile:         "",
+		TLSKeyFile:          "",
+		GRPCAddress:         "",
+		DeleteWorkers:       10,
+		TagLength:           8,
+		DBWriteTimeout:      Duration(storage.DefaultTimeouts.Write),
+		DBQueryTimeout:      Duration(storage.DefaultTimeouts.Query),
+		DBLongTimeout:       Duration(storage.DefaultTimeouts.Long),
+		DBMigrateTimeout:    Duration(storage.DefaultTimeouts.Migrate),
+		MaxDecompressedSize: defaultMaxDecompressedSize,
+		PolicyFile:          "",
+		PolicyReload:        Duration(defaultPolicyReload),
+		ClickSecret:         "",
+		ConfigFile:          "",
+	}
+}
+
+//commandLine - флаги конфигурации в flag.CommandLine, регистрируются при первом вызове New
+var (
+	commandLine     *cliFlags
+	commandLineOnce sync.Once
+)
+
+//NewConfig - создание новой минимальной конфигурации, чтение файла конфигурации, переменных окружения и флагов коммандной строки.
+//Приоритет источников: флаги > переменные окружения > файл конфигурации > значения по умолчанию
+func New() *Config {
+	commandLineOnce.Do(func() {
+		commandLine = newFlags(flag.CommandLine)
+	})
+	flag.Parse()
+	cfg, err := commandLine.load()
+	if err != nil {
+		log.Fatal(err)
+	}
+	return cfg
+}
+
+//Parse - регистрация флагов конфигурации в наборе set, разбор аргументов args и чтение конфигурации
+//с тем же приоритетом источников, что и New
+func Parse(set *flag.FlagSet, args []string) (*Config, error) {
+	f := newFlags(set)
+	err := set.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+	return f.load()
+}
+
+//load - чтение и проверка конфигурации с разобранными флагами
+func (f *cliFlags) load() (*Config, error) {
+	s := defaults()
+	err := s.readFile(f.configPath())
+	if err != nil {
+		return nil, err
+	}
+	err = s.readEnv()
+	if err != nil {
+		return nil, err
+	}
+	s.readCli(f)
+	if s.EnableHTTPS && s.BaseURL == defaultBaseURL {
+		s.BaseURL = defaultHTTPSBaseURL
+	}
+	log.Println("Effective configuration:", s.dump())
+	err = s.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+//Validate - проверка итоговой конфигурации
+func (cfg *Config) Validate() error {
+	problems := make([]string, 0)
+	if u, err := url.Parse(cfg.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		problems = append(problems, fmt.Sprintf("BASE_URL must be an absolute http or https url, got %q", cfg.BaseURL))
+	}
+	if _, _, err := net.SplitHostPort(cfg.ServerAddress); err != nil {
+		problems = append(problems, fmt.Sprintf("SERVER_ADDRESS must be host:port, got %q", cfg.ServerAddress))
+	}
+	if cfg.GRPCAddress != "" {
+		if _, _, err := net.SplitHostPort(cfg.GRPCAddress); err != nil {
+			problems = append(problems, fmt.Sprintf("GRPC_ADDRESS must be host:port, got %q", cfg.GRPCAddress))
+		}
+	}
+	if cfg.TrustedSubnet != "" {
+		if _, _, err := net.ParseCIDR(cfg.TrustedSubnet); err != nil {
+			problems = append(problems, fmt.Sprintf("TRUSTED_SUBNET must be CIDR, got %q", cfg.TrustedSubnet))
+		}
+	}
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		problems = append(problems, "TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if cfg.DeleteWorkers < 1 {
+		problems = append(problems, fmt.Sprintf("DELETE_WORKERS must be positive, got %d", cfg.DeleteWorkers))
+	}
+	if cfg.TagLength < MinTagLength || cfg.TagLength > MaxTagLength {
+		problems = append(problems, fmt.Sprintf("TAG_LENGTH must be between %d and %d, got %d", MinTagLength, MaxTagLength, cfg.TagLength))
+	}
+	timeouts := map[string]Duration{
+		"DB_WRITE_TIMEOUT":      cfg.DBWriteTimeout,
+		"DB_QUERY_TIMEOUT":      cfg.DBQueryTimeout,
+		"DB_LONG_QUERY_TIMEOUT": cfg.DBLongTimeout,
+		"DB_MIGRATE_TIMEOUT":    cfg.DBMigrateTimeout,
+	}
+	for _, name := range []string{"DB_WRITE_TIMEOUT", "DB_QUERY_TIMEOUT", "DB_LONG_QUERY_TIMEOUT", "DB_MIGRATE_TIMEOUT"} {
+		if timeouts[name] <= 0 {
+			problems = append(problems, fmt.Sprintf("%s must be positive, got %s", name, timeouts[name]))
+		}
+	}
+	if cfg.MaxDecompressedSize < 1 {
+		problems = append(problems, fmt.Sprintf("MAX_DECOMPRESSED_SIZE must be positive, got %d", cfg.MaxDecompressedSize))
+	}
+	if cfg.PolicyReload <= 0 {
+		problems = append(problems, fmt.Sprintf("POLICY_RELOAD_INTERVAL must be positive, got %s", cfg.PolicyReload))
+	}
+	if cfg.ClickSecret != "" && len(cfg.ClickSecret) < MinClickSecret {
+		problems = append(problems, fmt.Sprintf("CLICK_HASH_SECRET must be at least %d bytes long", MinClickSecret))
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+//dsnPassword - пароль в строке подключения вида "key=value"
+var dsnPassword = regexp.MustCompile(`(password=)\S+`)
+
+//dump - итоговая конфигурация в формате JSON с ключами по именам переменных окружения, пароль базы данных и ключ хеширования скрыты
+func (cfg Config) dump() string {
+	result := make(map[string]interface{})
+	v := reflect.ValueOf(cfg)
+	for i := 0; i < v.NumField(); i++ {
+		name := v.Type().Field(i).Tag.Get("env")
+		if name == "" {
+			continue
+		}
+		result[name] = v.Field(i).Interface()
+	}
+	if u, err := url.Parse(cfg.Database); err == nil && u.User != nil {
+		result["DATABASE_DSN"] = u.Redacted()
+	} else {
+		result["DATABASE_DSN"] = dsnPassword.ReplaceAllString(cfg.Database, "${1}xxxxx")
+	}
+	if cfg.ClickSecret != "" {
+		result["CLICK_HASH_SECRET"] = "xxxxx"
+	}
+	data, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err.Error()
+	}
+	return string(data)
+}
+
+//requiredEnv - переменные, без значения которых сервис не запускается, пустое значение считается незаданным
+var requiredEnv = map[string]bool{"BASE_URL": true, "SERVER_ADDRESS": true}
+
+//ReadEnv - чтение переменных окружения, заданная переменная переопределяет значение даже нулевым значением.
+//Пустая переменная переопределяет только необязательные строковые значения, для остальных она считается незаданной
+func (cfg *Config) readEnv() error {
+	var c Config
+	err := env.ParseWithFuncs(&c, env.CustomParsers{reflect.TypeOf(Duration(0)): parseDuration})
+	if err != nil {
+		return err
+	}
+	src := reflect.ValueOf(c)
+	dst := reflect.ValueOf(cfg).Elem()
+	for i := 0; i < src.NumField(); i++ {
+		name := src.Type().Field(i).Tag.Get("env")
+		if name == "" {
+			continue
+		}
+		value, ok := os.LookupEnv(name)
+		if !ok || (value == "" && (requiredEnv[name] || src.Field(i).Kind() != reflect.String)) {
+			continue
+		}
+		dst.Field(i).Set(src.Field(i))
+	}
+	return nil
+}
+
+//parseDuration - разбор продолжительности из переменной окружения
+func parseDuration(value string) (interface{}, error) {
+	var d Duration
+	err := d.UnmarshalText([]byte(value))
+	return d, err
+}
+
+//flags - map for flag iterate
+var flags = map[string]string{
+	"b":                     "BASE_URL",
+	"a":                     "SERVER_ADDRESS",
+	"f":                     "FILE_STORAGE_PATH",
+	"k":                     "KV_STORAGE_PATH",
+	"l":                     "SQLITE_PATH",
+	"d":                     "DATABASE_DSN",
+	"t":                     "TRUSTED_SUBNET",
+	"s":                     "ENABLE_HTTPS",
+	"tls-cert":              "TLS_CERT_FILE",
+	"tls-key":               "TLS_KEY_FILE",
+	"c":                     "CONFIG",
+	"g":                     "GRPC_ADDRESS",
+	"w":                     "DELETE_WORKERS",
+	"tag-length":            "TAG_LENGTH",
+	"db-write-timeout":      "DB_WRITE_TIMEOUT",
+	"db-query-timeout":      "DB_QUERY_TIMEOUT",
+	"db-long-query-timeout": "DB_LONG_QUERY_TIMEOUT",
+	"db-migrate-timeout":    "DB_MIGRATE_TIMEOUT",
+	"max-decompressed-size": "MAX_DECOMPRESSED_SIZE",
+	"policy":                "POLICY_FILE",
+	"policy-reload":         "POLICY_RELOAD_INTERVAL",
+	"click-secret":          "CLICK_HASH_SECRET",
+}
+
+//cliFlags - флаги командной строки конфигурации, зарегистрированные в наборе флагов
+type cliFlags struct {
+	set    *flag.FlagSet //набор флагов
+	values Config        //значения флагов
+}
+
+//newFlags - регистрация флагов конфигурации в наборе флагов set
+func newFlags(set *flag.FlagSet) *cliFlags {
+	f := &cliFlags{set: set}
+	v := &f.values
+	set.StringVar(&v.BaseURL, "b", "", flags["b"])
+	set.StringVar(&v.ServerAddress, "a", "", flags["a"])
+	set.StringVar(&v.FileStoragePath, "f", "", flags["f"])
+	set.StringVar(&v.KVStoragePath, "k", "", flags["k"])
+	set.StringVar(&v.SQLitePath, "l", "", flags["l"])
+	set.StringVar(&v.Database, "d", "", flags["d"])
+	set.StringVar(&v.TrustedSubnet, "t", "", flags["t"])
+	set.BoolVar(&v.EnableHTTPS, "s", false, flags["s"])
+	set.StringVar(&v.TLSCertFile, "tls-cert", "", flags["tls-cert"])
+	set.StringVar(&v.TLSKeyFile, "tls-key", "", flags["tls-key"])
+	set.StringVar(&v.ConfigFile, "c", "", flags["c"])
+	set.StringVar(&v.GRPCAddress, "g", "", flags["g"])
+	set.IntVar(&v.DeleteWorkers, "w", 0, flags["w"])
+	set.IntVar(&v.TagLength, "tag-length", 0, flags["tag-length"])
+	set.Var(&v.DBWriteTimeout, "db-write-timeout", flags["db-write-timeout"])
+	set.Var(&v.DBQueryTimeout, "db-query-timeout", flags["db-query-timeout"])
+	set.Var(&v.DBLongTimeout, "db-long-query-timeout", flags["db-long-query-timeout"])
+	set.Var(&v.DBMigrateTimeout, "db-migrate-timeout", flags["db-migrate-timeout"])
+	set.Int64Var(&v.MaxDecompressedSize, "max-decompressed-size", 0, flags["max-decompressed-size"])
+	set.StringVar(&v.PolicyFile, "policy", "", flags["policy"])
+	set.Var(&v.PolicyReload, "policy-reload", flags["policy-reload"])
+	set.StringVar(&v.ClickSecret, "click-secret", "", flags["click-secret"])
+	return f
+}
+
+//ReadCli - чтение флагов командной строки, флаги должны быть разобраны заранее
+func (cfg *Config) readCli(f *cliFlags) {
+	src := reflect.ValueOf(f.values)
+	dst := reflect.ValueOf(cfg).Elem()
+	f.set.Visit(func(passed *flag.Flag) {
+		name, ok := flags[passed.Name]
+		if !ok {
+			return
+		}
+		for i := 0; i < src.NumField(); i++ {
+			if src.Type().Field(i).Tag.Get("env") == name {
+				dst.Field(i).Set(src.Field(i))
+			}
+		}
+	})
+}
+
+//configPath - путь к файлу конфигурации из флага -c или переменной окружения CONFIG
+func (f *cliFlags) configPath() string {
+	if f.isPassed("c") {
+		return f.values.ConfigFile
+	}
+	return os.Getenv("CONFIG")
+}
+
+//readFile - чтение файла конфигурации в формате JSON или YAML, отсутствующие в файле поля не изменяются
+func (cfg *Config) readFile(name string) error {
+	if name == "" {
+		return nil
+	}
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(cfg)
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err = decoder.Decode(cfg)
+		if errors.Is(err, io.EOF) {
+			err = nil
+		}
+	default:
+		return fmt.Errorf("unsupported configuration file format: %s", name)
+	}
+	if err != nil {
+		return fmt.Errorf("configuration file %s: %w", name, err)
+	}
+	cfg.ConfigFile = name
+	log.Println("Configuration file parsed:", name)
+	return nil
+}
+
+//isPassed - проверка применение флага
+func (f *cliFlags) isPassed(name string) bool {
+	found := false
+	f.set.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			found = true
+		}
+	})
+	return found
+}
+
+//NewStorage - создание хранилища
+func (cfg *Config) NewStorage() (storage.Storage, error) {
+	if cfg.Database != "" {
+		s, err := storage.NewPostgreSQL(cfg.Database, cfg.Timeouts())
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+	if cfg.SQLitePath != "" {
+		s, err := storage.NewSQLite(cfg.SQLitePath, cfg.Timeouts())
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+	if cfg.KVStoragePath != "" {
+		s, err := storage.NewBolt(cfg.KVStoragePath)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+	if cfg.FileStoragePath != "" {
+		stor := storage.NewFile(cfg.FileStoragePath)
+		return stor, nil
+	}
+	s := storage.NewRAM()
+	return s, nil
+}
+
+//NewPolicy - загрузка политики ссылок, при незаданном файле политики возвращается nil и все ссылки разрешены
+func (cfg *Config) NewPolicy() (*policy.Engine, error) {
+	if cfg.PolicyFile == "" {
+		return nil, nil
+	}
+	return policy.Load(cfg.PolicyFile, time.Duration(cfg.PolicyReload))
+}
+
+//Timeouts - ограничения времени запросов к базе данных, для незаданных значений используются значения по умолчанию
+func (cfg *Config) Timeouts() storage.Timeouts {
+	timeouts := storage.DefaultTimeouts
+	if cfg.DBWriteTimeout > 0 {
+		timeouts.Write = time.Duration(cfg.DBWriteTimeout)
+	}
+	if cfg.DBQueryTimeout > 0 {
+		timeouts.Query = time.Duration(cfg.DBQueryTimeout)
+	}
+	if cfg.DBLongTimeout > 0 {
+		timeouts.Long = time.Duration(cfg.DBLongTimeout)
+	}
+	if cfg.DBMigrateTimeout > 0 {
+		timeouts.Migrate = time.Duration(cfg.DBMigrateTimeout)
+	}
+	return timeouts
+}
+
+//NewMigrator - подключение к SQL хранилищу для управления миграциями схемы
+func (cfg *Config) NewMigrator() (storage.Migrator, error) {
+	if cfg.Database != "" {
+		s, err := storage.OpenPostgreSQL(cfg.Database)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+	if cfg.SQLitePath != "" {
+		s, err := storage.OpenSQLite(cfg.SQLitePath)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+	return nil, errors.New("migrations are supported only by DATABASE_DSN and SQLITE_PATH storages")
+}
+
+//TLSConfig - настройки TLS с сертификатом из файлов или с самоподписанным сертификатом, созданным в памяти
+func (cfg *Config) TLSConfig() (*tls.Config, error) {
+	var cert tls.Certificate
+	var err error
+	switch {
+	case cfg.TLSCertFile != "" && cfg.TLSKeyFile != "":
+		cert, err = tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+	case cfg.TLSCertFile != "" || cfg.TLSKeyFile != "":
+		return nil, errors.New("both TLS_CERT_FILE and TLS_KEY_FILE must be set")
+	default:
+		log.Println("TLS certificate is not set, self-signed certificate will be used")
+		var certPEM, keyPEM []byte
+		certPEM, keyPEM, err = helpers.SelfSigned(cfg.tlsHosts(), 365*24*time.Hour)
+		if err != nil {
+			return nil, err
+		}
+		cert, err = tls.X509KeyPair(certPEM, keyPEM)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil
+}
+
+//tlsHosts - имена и адреса для самоподписанного сертификата
+func (cfg *Config) tlsHosts() []string {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	if host, _, err := net.SplitHostPort(cfg.ServerAddress); err == nil {
+		hosts = append(hosts, host)
+	}
+	if u, err := url.Parse(cfg.BaseURL); err == nil {
+		hosts = append(hosts, u.Hostname())
+	}
+	return hosts
+}
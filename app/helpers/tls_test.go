@@ -0,0 +1,25 @@
+package helpers
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_SelfSigned(t *testing.T) {
+	certPEM, keyPEM, err := SelfSigned([]string{"localhost", "127.0.0.1", ""}, time.Hour)
+	require.NoError(t, err)
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	require.NoError(t, err)
+	require.Equal(t, []string{"localhost"}, cert.DNSNames)
+	require.Len(t, cert.IPAddresses, 1)
+	require.True(t, cert.IPAddresses[0].Equal(net.ParseIP("127.0.0.1")))
+	require.NoError(t, cert.VerifyHostname("localhost"))
+	require.True(t, cert.NotAfter.After(time.Now()))
+}
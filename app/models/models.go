@@ -1,27 +1,62 @@
-package models
-
-//ClientData - struct for user data implementation
-type ClientData struct {
-	Cookie string      `json:"cookie"` //Cookie - user cookie
-	Key    string      `json:"key"`    //Key - cookie sign key
-	Short  []ShortData `json:"values"` // Short - short urls
-}
-
-//ShortData - struct for short url user storage implementation
-type ShortData struct {
-	Short   string `json:"short"`   //Short - short url
-	Long    string `json:"long"`    //Long - original url
-	Deleted bool   `json:"deleted"` //Deleted - current short url status
-}
-
-//DelWorker - struct for delete worker input
-type DelWorker struct {
-	Cookie string   //Cookie - user identification
-	Tags   []string //Tags - list of url tags
-}
-
-//DelTask - struct atomic for delete worker
-type DelTask struct {
-	Cookie string //Cookie - user identification
-	Tag    string //Short url tag
-}
+package models
+
+import "time"
+
+//ClientData - struct for user data implementation
+type ClientData struct {
+	Cookie string      `json:"cookie"` //Cookie - user cookie
+	Key    string      `json:"key"`    //Key - cookie sign key
+	Short  []ShortData `json:"values"` // Short - short urls
+}
+
+//ShortData - struct for short url user storage implementation
+type ShortData struct {
+	Short   string    `json:"short"`                //Short - short url
+	Long    string    `json:"long"`                 //Long - original url
+	Deleted bool      `json:"deleted"`              //Deleted - current short url status
+	Expires time.Time `json:"expires_at,omitempty"` //Expires - short url expiration time, zero for permanent url
+}
+
+//DelWorker - struct for delete worker input
+type DelWorker struct {
+	Cookie string   `json:"cookie"` //Cookie - user identification
+	Tags   []string `json:"tags"`   //Tags - list of url tags
+}
+
+//Click - struct for redirect click event
+type Click struct {
+	Tag       string    `json:"tag"`        //Tag - short url tag
+	Time      time.Time `json:"time"`       //Time - click time
+	Referrer  string    `json:"referrer"`   //Referrer - http referrer of click
+	UserAgent string    `json:"user_agent"` //UserAgent - client user agent
+	IPHash    string    `json:"ip_hash"`    //IPHash - HMAC-SHA256 of client ip keyed by server secret
+	Country   string    `json:"country"`    //Country - client country code from proxy header
+}
+
+//Stats - struct for short url click statistics
+type Stats struct {
+	Tag       string        `json:"tag"`             //Tag - short url tag
+	Clicks    int           `json:"clicks"`          //Clicks - total clicks count
+	Unique    int           `json:"unique_visitors"` //Unique - count of unique client ip hashes
+	Daily     []DailyClicks `json:"daily"`           //Daily - clicks count by day in UTC
+	Referrers []TopItem     `json:"top_referrers"`   //Referrers - most frequent referrers
+	Countries []TopItem     `json:"top_countries"`   //Countries - most frequent client countries
+}
+
+//DailyClicks - struct for clicks count of one day
+type DailyClicks struct {
+	Date   string `json:"date"`   //Date - day in YYYY-MM-DD format
+	Clicks int    `json:"clicks"` //Clicks - clicks count
+}
+
+//TopItem - struct for clicks count of one referrer or country
+type TopItem struct {
+	Name   string `json:"name"`   //Name - referrer or country code
+	Clicks int    `json:"clicks"` //Clicks - clicks count
+}
+
+//DelTask - struct atomic for delete worker
+type DelTask struct {
+	Cookie string //Cookie - user identification
+	Tag    string //Short url tag
+}
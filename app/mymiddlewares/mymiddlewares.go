@@ -1,41 +1,192 @@
-package mymiddlewares
-
-import (
-	"bytes"
-	"compress/gzip"
-	"io"
-	"log"
-	"net/http"
-	"strings"
-	"time"
-)
-
-//DecompressRequest - middleware для декомпрессии входящих запросов и отслеживания времени исполнения запроса
-func DecompressRequestAndTimeTracer(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		tStart := time.Now()
-		//Check for compression in request
-		if (strings.Contains(r.Header.Get("Content-Encoding"), "gzip")) || (strings.Contains(r.Header.Get("Content-Encoding"), "br")) || (strings.Contains(r.Header.Get("Content-Encoding"), "deflate")) {
-			defer r.Body.Close()
-			//creating new zipped reader
-			gz, err := gzip.NewReader(r.Body)
-			if err != nil {
-				http.Error(w, "Internal server error", http.StatusInternalServerError)
-				return
-			}
-			defer gz.Close()
-			//read unzipped body
-			body, err := io.ReadAll(gz)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			//update request headers
-			r.ContentLength = int64(len(body))
-			r.Body = io.NopCloser(bytes.NewBuffer(body))
-		}
-		next.ServeHTTP(w, r)
-		tEnd := time.Since(tStart)
-		log.Printf("Duration for a request %s\r\n", tEnd)
-	})
-}
+package mymiddlewares
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+//errors of decompressed request body
+var (
+	ErrBodyTooLarge  = errors.New("decompressed request body is too large") //ErrBodyTooLarge - decompressed body exceeds configured limit
+	ErrMalformedBody = errors.New("malformed compressed request body")      //ErrMalformedBody - body does not match its Content-Encoding
+)
+
+//decoders - поддерживаемые кодировки тела запроса
+var decoders = map[string]func(io.Reader) (io.Reader, error){
+	"gzip":    newGzip,
+	"x-gzip":  newGzip,
+	"deflate": newDeflate,
+	"br":      newBrotli,
+}
+
+//acceptEncoding - список поддерживаемых кодировок для ответа 415
+const acceptEncoding = "gzip, deflate, br"
+
+//DecompressRequestAndTimeTracer - middleware для декомпрессии входящих запросов и отслеживания времени исполнения запроса
+//  maxSize int64 - максимальный размер распакованного тела запроса в байтах
+func DecompressRequestAndTimeTracer(maxSize int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tStart := time.Now()
+			encodings, err := contentEncodings(r.Header.Values("Content-Encoding"))
+			if err != nil {
+				w.Header().Set("Accept-Encoding", acceptEncoding)
+				http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+				return
+			}
+			if len(encodings) > 0 {
+				defer r.Body.Close()
+				//кодировки перечислены в порядке применения, снимаются в обратном порядке
+				var body io.Reader = r.Body
+				for i := len(encodings) - 1; i >= 0; i-- {
+					body, err = decoders[encodings[i]](body)
+					if err != nil {
+						log.Println("Request decompression failed:", err)
+						http.Error(w, fmt.Sprintf("%v: %v", ErrMalformedBody, err), http.StatusBadRequest)
+						return
+					}
+				}
+				//body is unzipped while handler reads it, its length is unknown
+				r.ContentLength = -1
+				r.Header.Del("Content-Length")
+				r.Header.Del("Content-Encoding")
+				r.Body = &decodedBody{r: body, closer: r.Body, left: maxSize}
+			}
+			next.ServeHTTP(w, r)
+			tEnd := time.Since(tStart)
+			log.Printf("Duration for a request %s\r\n", tEnd)
+		})
+	}
+}
+
+//contentEncodings - кодировки тела запроса в порядке применения, identity пропускается
+func contentEncodings(values []string) ([]string, error) {
+	result := make([]string, 0)
+	for _, value := range values {
+		for _, encoding := range strings.Split(value, ",") {
+			encoding = strings.ToLower(strings.TrimSpace(encoding))
+			if encoding == "" || encoding == "identity" {
+				continue
+			}
+			if _, ok := decoders[encoding]; !ok {
+				return nil, fmt.Errorf("unsupported Content-Encoding %q, supported: %s", encoding, acceptEncoding)
+			}
+			result = append(result, encoding)
+		}
+	}
+	return result, nil
+}
+
+//newGzip - распаковка gzip, заголовок читается сразу
+func newGzip(r io.Reader) (io.Reader, error) {
+	return gzip.NewReader(r)
+}
+
+//newDeflate - распаковка deflate: по стандарту zlib поток, часть клиентов отправляет поток deflate без обертки zlib
+func newDeflate(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+	//zlib header: compression method 8 and checksum of first two bytes
+	if header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
+
+//newBrotli - распаковка brotli
+func newBrotli(r io.Reader) (io.Reader, error) {
+	return brotli.NewReader(r), nil
+}
+
+//decodedBody - распакованное тело запроса с ограничением размера
+type decodedBody struct {
+	r      io.Reader
+	closer io.Closer //исходное тело запроса
+	left   int64     //сколько байт еще можно прочитать
+}
+
+//Read - чтение распакованных данных, ошибки распаковки оборачиваются в ErrMalformedBody
+func (b *decodedBody) Read(p []byte) (int, error) {
+	if b.left <= 0 {
+		//одного байта достаточно, чтобы понять, есть ли данные сверх лимита
+		var one [1]byte
+		n, err := b.read(one[:])
+		if n > 0 {
+			return 0, ErrBodyTooLarge
+		}
+		return 0, err
+	}
+	if int64(len(p)) > b.left {
+		p = p[:b.left]
+	}
+	n, err := b.read(p)
+	b.left -= int64(n)
+	return n, err
+}
+
+//read - чтение из цепочки распаковки
+func (b *decodedBody) read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	if err != nil && err != io.EOF {
+		err = fmt.Errorf("%w: %v", ErrMalformedBody, err)
+	}
+	return n, err
+}
+
+//Close - закрытие исходного тела запроса
+func (b *decodedBody) Close() error {
+	return b.closer.Close()
+}
+
+//fullDuplexKey - ключ контекста с признаком одновременного чтения запроса и записи ответа
+type fullDuplexKey struct{}
+
+//FullDuplexHeader - заголовок запроса, которым клиент подтверждает, что читает ответ во время отправки тела запроса
+const FullDuplexHeader = "X-Full-Duplex"
+
+//FullDuplex - middleware разрешающий читать тело HTTP/1.x запроса после начала отправки ответа для запросов к paths
+//с заголовком X-Full-Duplex: true. Клиент без такого заголовка может не читать ответ до окончания отправки запроса,
+//поэтому для него режим не включается. Должен быть первым, пока ResponseWriter не обернут другими middleware
+func FullDuplex(paths ...string) func(http.Handler) http.Handler {
+	allowed := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		allowed[path] = true
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			optIn, _ := strconv.ParseBool(r.Header.Get(FullDuplexHeader))
+			if !optIn || !allowed[r.URL.Path] {
+				next.ServeHTTP(w, r)
+				return
+			}
+			enabled := r.ProtoMajor >= 2
+			//EnableFullDuplex is available since go 1.21
+			if d, ok := w.(interface{ EnableFullDuplex() error }); ok && !enabled {
+				enabled = d.EnableFullDuplex() == nil
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), fullDuplexKey{}, enabled)))
+		})
+	}
+}
+
+//IsFullDuplex - тело запроса можно читать после начала отправки ответа
+func IsFullDuplex(ctx context.Context) bool {
+	enabled, _ := ctx.Value(fullDuplexKey{}).(bool)
+	return enabled
+}
@@ -0,0 +1,128 @@
+package mymiddlewares
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/stretchr/testify/require"
+)
+
+//compress - сжатие данных кодировкой encoding
+func compress(t *testing.T, encoding string, data []byte) []byte {
+	var b bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case "gzip":
+		w = gzip.NewWriter(&b)
+	case "deflate":
+		w = zlib.NewWriter(&b)
+	case "raw-deflate":
+		fw, err := flate.NewWriter(&b, flate.DefaultCompression)
+		require.NoError(t, err)
+		w = fw
+	case "br":
+		w = brotli.NewWriter(&b)
+	}
+	_, err := w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return b.Bytes()
+}
+
+//echo - обработчик, возвращающий прочитанное тело запроса
+func echo(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	switch {
+	case errors.Is(err, ErrBodyTooLarge):
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+	case errors.Is(err, ErrMalformedBody):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	default:
+		w.Write(body)
+	}
+}
+
+func TestDecompressRequestAndTimeTracer(t *testing.T) {
+	plain := []byte(strings.Repeat("http://example.org/", 10))
+	handler := DecompressRequestAndTimeTracer(int64(len(plain)))(http.HandlerFunc(echo))
+	tests := []struct {
+		name     string
+		encoding string
+		body     []byte
+		code     int
+	}{
+		{name: "Plain", body: plain, code: http.StatusOK},
+		{name: "Identity", encoding: "identity", body: plain, code: http.StatusOK},
+		{name: "Gzip", encoding: "gzip", body: compress(t, "gzip", plain), code: http.StatusOK},
+		{name: "Deflate", encoding: "deflate", body: compress(t, "deflate", plain), code: http.StatusOK},
+		{name: "Raw deflate", encoding: "deflate", body: compress(t, "raw-deflate", plain), code: http.StatusOK},
+		{name: "Brotli", encoding: "br", body: compress(t, "br", plain), code: http.StatusOK},
+		{name: "Stacked", encoding: "deflate, GZIP,br", body: compress(t, "br", compress(t, "gzip", compress(t, "deflate", plain))), code: http.StatusOK},
+		{name: "Unsupported", encoding: "gzip, zstd", body: plain, code: http.StatusUnsupportedMediaType},
+		{name: "Malformed gzip header", encoding: "gzip", body: plain, code: http.StatusBadRequest},
+		{name: "Malformed brotli", encoding: "br", body: plain, code: http.StatusBadRequest},
+		{name: "Truncated gzip", encoding: "gzip", body: compress(t, "gzip", plain)[:20], code: http.StatusBadRequest},
+		{name: "Too large", encoding: "gzip", body: compress(t, "gzip", append(plain, 'x')), code: http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(tt.body))
+			if tt.encoding != "" {
+				r.Header.Set("Content-Encoding", tt.encoding)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			require.Equal(t, tt.code, w.Code, w.Body.String())
+			switch tt.code {
+			case http.StatusOK:
+				require.Equal(t, plain, w.Body.Bytes())
+			case http.StatusUnsupportedMediaType:
+				require.Equal(t, acceptEncoding, w.Header().Get("Accept-Encoding"))
+				require.Contains(t, w.Body.String(), `"zstd"`)
+			}
+		})
+	}
+}
+
+func TestFullDuplex(t *testing.T) {
+	handler := FullDuplex("/batch")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if IsFullDuplex(r.Context()) {
+			w.Write([]byte("duplex"))
+		}
+	}))
+	tests := []struct {
+		name   string
+		path   string
+		header string
+		want   string
+	}{
+		{name: "Opt in", path: "/batch", header: "true", want: "duplex"},
+		{name: "No header", path: "/batch"},
+		{name: "Opt out", path: "/batch", header: "false"},
+		{name: "Other path", path: "/api/shorten", header: "true"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader("[]"))
+			//HTTP/2 request body is always readable after the answer is started
+			r.ProtoMajor = 2
+			if tt.header != "" {
+				r.Header.Set(FullDuplexHeader, tt.header)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			require.Equal(t, tt.want, w.Body.String())
+		})
+	}
+}
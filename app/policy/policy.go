@@ -0,0 +1,405 @@
+package policy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/idna"
+	"gopkg.in/yaml.v3"
+)
+
+//rule actions
+const (
+	ActionAllow = "allow" //ActionAllow - ссылка разрешена
+	ActionBlock = "block" //ActionBlock - ссылка запрещена
+)
+
+//DefaultRule - идентификатор правила, срабатывающего при отсутствии подходящих правил
+const DefaultRule = "default"
+
+//Rule - правило политики из файла. Условия hosts и cidrs объединяются по ИЛИ, условие path - по И с ними
+type Rule struct {
+	ID     string   `json:"id" yaml:"id"`         //ID - идентификатор правила, возвращается клиенту при блокировке
+	Action string   `json:"action" yaml:"action"` //Action - allow или block
+	Hosts  []string `json:"hosts" yaml:"hosts"`   //Hosts - точные имена хостов или домены вида "*.example.com" (только поддомены)
+	CIDRs  []string `json:"cidrs" yaml:"cidrs"`   //CIDRs - диапазоны адресов для ссылок с IP адресом вместо имени хоста
+	Path   string   `json:"path" yaml:"path"`     //Path - регулярное выражение для пути ссылки
+}
+
+//File - содержимое файла политики
+type File struct {
+	Default string `json:"default" yaml:"default"` //Default - действие при отсутствии подходящих правил, allow если не задано
+	Rules   []Rule `json:"rules" yaml:"rules"`     //Rules - правила, проверяются по порядку до первого совпадения
+}
+
+//rule - подготовленное к проверке правило
+type rule struct {
+	id      string
+	block   bool
+	hosts   map[string]bool
+	domains []string //суффиксы вида ".example.com"
+	nets    []*net.IPNet
+	path    *regexp.Regexp
+}
+
+//ruleSet - подготовленная политика
+type ruleSet struct {
+	rules []rule
+	block bool //действие по умолчанию
+}
+
+//stamp - признаки изменения файла политики
+type stamp struct {
+	modTime time.Time
+	size    int64
+}
+
+//Engine - проверка ссылок по правилам из файла с перечитыванием файла при изменении
+type Engine struct {
+	name     string
+	interval time.Duration
+	mu       *sync.RWMutex
+	set      *ruleSet
+	stamp    stamp
+	stop     chan struct{} //сигнал остановки отслеживания файла
+	done     chan struct{} //сигнал завершения отслеживания файла
+	once     *sync.Once    //защита от повторной остановки
+}
+
+//Load - загрузка политики из файла JSON или YAML и запуск проверки изменений файла с периодом interval
+func Load(name string, interval time.Duration) (*Engine, error) {
+	e := Engine{}
+	e.name = name
+	e.interval = interval
+	e.mu = &sync.RWMutex{}
+	e.once = &sync.Once{}
+	e.stop = make(chan struct{})
+	e.done = make(chan struct{})
+	st, err := fileStamp(name)
+	if err != nil {
+		return nil, err
+	}
+	set, err := readFile(name)
+	if err != nil {
+		return nil, err
+	}
+	e.set = set
+	e.stamp = st
+	log.Printf("Policy file loaded: %s, %d rules", name, len(set.rules))
+	go e.watch()
+	return &e, nil
+}
+
+//Check - проверка нормализованной ссылки, возвращает идентификатор запрещающего правила и признак блокировки
+//для пустой политики все ссылки разрешены
+func (e *Engine) Check(rawURL string) (string, bool) {
+	if e == nil {
+		return "", false
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return DefaultRule, true
+	}
+	e.mu.RLock()
+	set := e.set
+	e.mu.RUnlock()
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	ip, ok, err := ParseIPv4(host)
+	if err != nil {
+		return DefaultRule, true
+	}
+	if ok {
+		host = ip.String()
+	}
+	for _, r := range set.rules {
+		if r.match(host, u.Path) {
+			return r.id, r.block
+		}
+	}
+	if set.block {
+		return DefaultRule, true
+	}
+	return "", false
+}
+
+//Close - остановка отслеживания изменений файла политики
+func (e *Engine) Close() {
+	if e == nil {
+		return
+	}
+	e.once.Do(func() {
+		close(e.stop)
+	})
+	<-e.done
+}
+
+//watch - периодическая проверка изменений файла политики
+func (e *Engine) watch() {
+	defer close(e.done)
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.stop:
+			return
+		case <-ticker.C:
+			e.reload()
+		}
+	}
+}
+
+//reload - перечитывание измененного файла политики, при ошибке сохраняются прежние правила
+func (e *Engine) reload() {
+	st, err := fileStamp(e.name)
+	if err != nil {
+		log.Println("Policy file is unavailable, previous rules are kept:", err)
+		return
+	}
+	if st == e.stamp {
+		return
+	}
+	e.stamp = st
+	set, err := readFile(e.name)
+	if err != nil {
+		log.Println("Policy file reload failed, previous rules are kept:", err)
+		return
+	}
+	e.mu.Lock()
+	e.set = set
+	e.mu.Unlock()
+	log.Printf("Policy file reloaded: %s, %d rules", e.name, len(set.rules))
+}
+
+//fileStamp - время изменения и размер файла политики
+func fileStamp(name string) (stamp, error) {
+	info, err := os.Stat(name)
+	if err != nil {
+		return stamp{}, err
+	}
+	return stamp{modTime: info.ModTime(), size: info.Size()}, nil
+}
+
+//readFile - чтение и проверка файла политики в формате JSON или YAML
+func readFile(name string) (*ruleSet, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	//пустой файл - признак незавершенной записи, а не политика без правил
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, fmt.Errorf("policy file %s is empty", name)
+	}
+	var f File
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&f)
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err = decoder.Decode(&f)
+	default:
+		return nil, fmt.Errorf("unsupported policy file format: %s", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("policy file %s: %w", name, err)
+	}
+	set, err := f.compile()
+	if err != nil {
+		return nil, fmt.Errorf("policy file %s: %w", name, err)
+	}
+	return set, nil
+}
+
+//compile - проверка правил и подготовка их к сопоставлению
+func (f File) compile() (*ruleSet, error) {
+	set := ruleSet{rules: make([]rule, 0, len(f.Rules))}
+	switch f.Default {
+	case "", ActionAllow:
+	case ActionBlock:
+		set.block = true
+	default:
+		return nil, fmt.Errorf("default action must be %s or %s, got %q", ActionAllow, ActionBlock, f.Default)
+	}
+	ids := make(map[string]bool)
+	for i, r := range f.Rules {
+		compiled, err := r.compile()
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i+1, err)
+		}
+		if ids[compiled.id] {
+			return nil, fmt.Errorf("rule %d: duplicate id %q", i+1, compiled.id)
+		}
+		ids[compiled.id] = true
+		set.rules = append(set.rules, compiled)
+	}
+	return &set, nil
+}
+
+//compile - проверка правила и подготовка его к сопоставлению
+func (r Rule) compile() (rule, error) {
+	result := rule{id: r.ID, hosts: make(map[string]bool)}
+	switch {
+	case r.ID == "":
+		return rule{}, errors.New("id is required")
+	case r.ID == DefaultRule:
+		return rule{}, fmt.Errorf("id %q is reserved", DefaultRule)
+	case len(r.Hosts) == 0 && len(r.CIDRs) == 0 && r.Path == "":
+		return rule{}, fmt.Errorf("rule %q has no conditions", r.ID)
+	}
+	switch r.Action {
+	case ActionAllow:
+	case ActionBlock:
+		result.block = true
+	default:
+		return rule{}, fmt.Errorf("rule %q action must be %s or %s, got %q", r.ID, ActionAllow, ActionBlock, r.Action)
+	}
+	for _, host := range r.Hosts {
+		wildcard := strings.HasPrefix(host, "*.")
+		name, err := normalizeHost(strings.TrimPrefix(host, "*."))
+		if err != nil {
+			return rule{}, fmt.Errorf("rule %q host %q: %w", r.ID, host, err)
+		}
+		if wildcard {
+			result.domains = append(result.domains, "."+name)
+		} else {
+			result.hosts[name] = true
+		}
+	}
+	for _, cidr := range r.CIDRs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return rule{}, fmt.Errorf("rule %q: %w", r.ID, err)
+		}
+		result.nets = append(result.nets, n)
+	}
+	if r.Path != "" {
+		re, err := regexp.Compile(r.Path)
+		if err != nil {
+			return rule{}, fmt.Errorf("rule %q path: %w", r.ID, err)
+		}
+		result.path = re
+	}
+	return result, nil
+}
+
+//normalizeHost - имя хоста правила в нижнем регистре, международные домены в punycode
+func normalizeHost(host string) (string, error) {
+	host = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+	if host == "" || strings.Contains(host, "*") {
+		return "", errors.New("host must be a name or a wildcard domain like *.example.com")
+	}
+	if net.ParseIP(host) != nil {
+		return host, nil
+	}
+	return idna.Lookup.ToASCII(host)
+}
+
+//match - совпадение хоста и пути ссылки с правилом
+func (r rule) match(host, path string) bool {
+	if r.path != nil && !r.path.MatchString(path) {
+		return false
+	}
+	if len(r.hosts) == 0 && len(r.domains) == 0 && len(r.nets) == 0 {
+		return true
+	}
+	if r.hosts[host] {
+		return true
+	}
+	for _, domain := range r.domains {
+		if strings.HasSuffix(host, domain) {
+			return true
+		}
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		for _, n := range r.nets {
+			if n.Contains(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//ParseIPv4 - разбор хоста по правилам WHATWG URL: адрес IPv4 может быть записан десятичными, восьмеричными (0177)
+//или шестнадцатеричными (0x7f) числами и сокращенно (127.1, 2130706433). Возвращает false, если хост не оканчивается
+//числом и является доменным именем, и ошибку, если хост оканчивается числом, но не является адресом IPv4
+func ParseIPv4(host string) (net.IP, bool, error) {
+	if strings.Contains(host, ":") {
+		//IPv6 address
+		return nil, false, nil
+	}
+	parts := strings.Split(host, ".")
+	if len(parts) > 1 && parts[len(parts)-1] == "" {
+		parts = parts[:len(parts)-1]
+	}
+	last := parts[len(parts)-1]
+	if _, err := ipv4Number(last); err != nil && (last == "" || strings.Trim(last, "0123456789") != "") {
+		return nil, false, nil
+	}
+	if len(parts) > 4 {
+		return nil, false, fmt.Errorf("host %q has more than 4 parts of IPv4 address", host)
+	}
+	numbers := make([]uint64, 0, len(parts))
+	for _, part := range parts {
+		n, err := ipv4Number(part)
+		if err != nil {
+			return nil, false, fmt.Errorf("host %q: %w", host, err)
+		}
+		numbers = append(numbers, n)
+	}
+	var addr uint64
+	for i, n := range numbers[:len(numbers)-1] {
+		if n > 255 {
+			return nil, false, fmt.Errorf("host %q: IPv4 address part %q is out of range", host, parts[i])
+		}
+		addr = addr<<8 | n
+	}
+	//last number fills all remaining bytes of address
+	bits := 8 * (5 - len(numbers))
+	if numbers[len(numbers)-1] >= 1<<bits {
+		return nil, false, fmt.Errorf("host %q: IPv4 address part %q is out of range", host, last)
+	}
+	addr = addr<<bits | numbers[len(numbers)-1]
+	return net.IPv4(byte(addr>>24), byte(addr>>16), byte(addr>>8), byte(addr)).To4(), true, nil
+}
+
+//ipv4Number - разбор части адреса IPv4: 0x - шестнадцатеричное число, ведущий 0 - восьмеричное, иначе десятичное
+func ipv4Number(part string) (uint64, error) {
+	base := 10
+	switch {
+	case part == "":
+		return 0, errors.New("empty IPv4 address part")
+	case strings.HasPrefix(part, "0x") || strings.HasPrefix(part, "0X"):
+		part = part[2:]
+		base = 16
+		if part == "" {
+			return 0, nil
+		}
+	case len(part) > 1 && part[0] == '0':
+		part = part[1:]
+		base = 8
+	}
+	n, err := strconv.ParseUint(part, base, 64)
+	if errors.Is(err, strconv.ErrRange) {
+		//number does not fit into address anyway
+		return 1 << 40, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("IPv4 address part %q is not a number", part)
+	}
+	return n, nil
+}
@@ -0,0 +1,191 @@
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+//testPolicy - политика с правилами всех видов
+const testPolicy = `
+default: allow
+rules:
+  - id: trusted-login
+    action: allow
+    hosts: [login.evil.example]
+  - id: evil-domains
+    action: block
+    hosts: ["*.evil.example", bad.example, "пример.рф"]
+  - id: private-ranges
+    action: block
+    cidrs: [10.0.0.0/8, "fd00::/8"]
+  - id: admin-pages
+    action: block
+    hosts: [shop.example]
+    path: ^/admin(/|$)
+  - id: php-files
+    action: block
+    path: \.php$
+`
+
+func writePolicy(t *testing.T, name, data string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(data), 0600))
+	return path
+}
+
+func TestEngine_Check(t *testing.T) {
+	e, err := Load(writePolicy(t, "policy.yaml", testPolicy), time.Hour)
+	require.NoError(t, err)
+	defer e.Close()
+	tests := []struct {
+		url     string
+		rule    string
+		blocked bool
+	}{
+		{url: "http://example.org/page"},
+		{url: "http://login.evil.example/", rule: "trusted-login"},
+		{url: "http://www.evil.example/", rule: "evil-domains", blocked: true},
+		{url: "http://a.b.evil.example/", rule: "evil-domains", blocked: true},
+		{url: "http://evil.example/"},
+		{url: "http://notevil.example/"},
+		{url: "http://bad.example/path", rule: "evil-domains", blocked: true},
+		{url: "http://sub.bad.example/"},
+		{url: "http://xn--e1afmkfd.xn--p1ai/", rule: "evil-domains", blocked: true},
+		{url: "http://10.1.2.3:8080/", rule: "private-ranges", blocked: true},
+		{url: "http://[fd00::1]/", rule: "private-ranges", blocked: true},
+		{url: "http://11.1.2.3/"},
+		{url: "http://167772161/", rule: "private-ranges", blocked: true},
+		{url: "http://10.1/", rule: "private-ranges", blocked: true},
+		{url: "http://0xa.1/", rule: "private-ranges", blocked: true},
+		{url: "http://012.0.0.1./", rule: "private-ranges", blocked: true},
+		{url: "http://[::ffff:10.0.0.1]/", rule: "private-ranges", blocked: true},
+		{url: "http://10.0.0.0.1/", rule: DefaultRule, blocked: true},
+		{url: "http://10.1.example/"},
+		{url: "https://shop.example/admin/users", rule: "admin-pages", blocked: true},
+		{url: "https://shop.example/administrator"},
+		{url: "https://other.example/admin"},
+		{url: "https://other.example/index.php", rule: "php-files", blocked: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			rule, blocked := e.Check(tt.url)
+			require.Equal(t, tt.blocked, blocked)
+			require.Equal(t, tt.rule, rule)
+		})
+	}
+}
+
+func TestParseIPv4(t *testing.T) {
+	tests := []struct {
+		host string
+		want string
+		ok   bool
+		err  bool
+	}{
+		{host: "127.0.0.1", want: "127.0.0.1", ok: true},
+		{host: "2130706433", want: "127.0.0.1", ok: true},
+		{host: "127.1", want: "127.0.0.1", ok: true},
+		{host: "127.0.1", want: "127.0.0.1", ok: true},
+		{host: "0x7f.1", want: "127.0.0.1", ok: true},
+		{host: "0X7F000001", want: "127.0.0.1", ok: true},
+		{host: "0177.0.0.01", want: "127.0.0.1", ok: true},
+		{host: "0x.0.0.0", want: "0.0.0.0", ok: true},
+		{host: "192.168.0.1.", want: "192.168.0.1", ok: true},
+		{host: "4294967295", want: "255.255.255.255", ok: true},
+		{host: "example.org"},
+		{host: "1.2.3.example"},
+		{host: "0x7g"},
+		{host: "4294967296", err: true},
+		{host: "256.0.0.1", err: true},
+		{host: "1.2.3.4.5", err: true},
+		{host: "1..2", err: true},
+		{host: "09", err: true},
+		{host: "99999999999999999999999", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			ip, ok, err := ParseIPv4(tt.host)
+			if tt.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.ok, ok)
+			if ok {
+				require.Equal(t, tt.want, ip.String())
+			}
+		})
+	}
+}
+
+func TestEngine_Default(t *testing.T) {
+	var e *Engine
+	_, blocked := e.Check("http://example.org")
+	require.False(t, blocked)
+	e.Close()
+
+	e, err := Load(writePolicy(t, "policy.json", `{"default": "block", "rules": [{"id": "allowed", "action": "allow", "hosts": ["example.org"]}]}`), time.Hour)
+	require.NoError(t, err)
+	defer e.Close()
+	_, blocked = e.Check("http://example.org")
+	require.False(t, blocked)
+	rule, blocked := e.Check("http://example.com")
+	require.True(t, blocked)
+	require.Equal(t, DefaultRule, rule)
+}
+
+func TestLoad_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		data string
+	}{
+		{name: "Unknown format", file: "policy.txt", data: "default: allow"},
+		{name: "Empty file", file: "policy.yaml", data: "\n"},
+		{name: "Unknown field", file: "policy.json", data: `{"default": "allow", "rule": []}`},
+		{name: "Wrong default", file: "policy.yaml", data: "default: deny"},
+		{name: "No id", file: "policy.yaml", data: "rules:\n  - action: block\n    hosts: [a.example]\n"},
+		{name: "Reserved id", file: "policy.yaml", data: "rules:\n  - id: default\n    action: block\n    hosts: [a.example]\n"},
+		{name: "Duplicate id", file: "policy.yaml", data: "rules:\n  - id: a\n    action: block\n    hosts: [a.example]\n  - id: a\n    action: block\n    hosts: [b.example]\n"},
+		{name: "No conditions", file: "policy.yaml", data: "rules:\n  - id: a\n    action: block\n"},
+		{name: "Wrong action", file: "policy.yaml", data: "rules:\n  - id: a\n    action: deny\n    hosts: [a.example]\n"},
+		{name: "Wrong wildcard", file: "policy.yaml", data: "rules:\n  - id: a\n    action: block\n    hosts: [\"a.*.example\"]\n"},
+		{name: "Wrong CIDR", file: "policy.yaml", data: "rules:\n  - id: a\n    action: block\n    cidrs: [10.0.0.1]\n"},
+		{name: "Wrong path", file: "policy.yaml", data: "rules:\n  - id: a\n    action: block\n    path: \"(\"\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(writePolicy(t, tt.file, tt.data), time.Hour)
+			require.Error(t, err)
+		})
+	}
+	_, err := Load(filepath.Join(t.TempDir(), "missing.yaml"), time.Hour)
+	require.Error(t, err)
+}
+
+func TestEngine_Reload(t *testing.T) {
+	path := writePolicy(t, "policy.yaml", "rules:\n  - id: first\n    action: block\n    hosts: [a.example]\n")
+	e, err := Load(path, 10*time.Millisecond)
+	require.NoError(t, err)
+	defer e.Close()
+	rule, blocked := e.Check("http://a.example")
+	require.True(t, blocked)
+	require.Equal(t, "first", rule)
+
+	require.NoError(t, os.WriteFile(path, []byte("rules:\n  - id: second\n    action: block\n    hosts: [b.example, a.example]\n"), 0600))
+	require.Eventually(t, func() bool {
+		rule, _ := e.Check("http://a.example")
+		return rule == "second"
+	}, 5*time.Second, 10*time.Millisecond)
+
+	//broken file keeps previous rules
+	require.NoError(t, os.WriteFile(path, []byte("rules: [broken"), 0600))
+	time.Sleep(50 * time.Millisecond)
+	rule, blocked = e.Check("http://b.example")
+	require.True(t, blocked)
+	require.Equal(t, "second", rule)
+}
@@ -0,0 +1,396 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/t1mon-ggg/go_shortner/app/config"
+	"github.com/t1mon-ggg/go_shortner/app/helpers"
+	"github.com/t1mon-ggg/go_shortner/app/models"
+	"github.com/t1mon-ggg/go_shortner/app/policy"
+	"github.com/t1mon-ggg/go_shortner/app/storage"
+)
+
+//service errors
+var (
+	ErrInvalid    = errors.New("invalid request")       //ErrInvalid - request is malformed
+	ErrAliasTaken = errors.New("custom alias is taken") //ErrAliasTaken - custom alias belongs to another url
+	ErrNotFound   = errors.New("not found")             //ErrNotFound - short url does not exist or does not belong to user
+	ErrGone       = errors.New("short url is gone")     //ErrGone - short url is deleted or expired
+	ErrBlocked    = errors.New("url is blocked")        //ErrBlocked - url is forbidden by policy
+	ErrClosed     = errors.New("service is closed")     //ErrClosed - delete queue is closed on shutdown
+)
+
+//tagAttempts - количество попыток подобрать свободный случайный тег
+const tagAttempts = 3
+
+//MaxTTL - наибольшее время жизни ссылки в секундах, 100 лет
+const MaxTTL = 100 * 365 * 24 * 60 * 60
+
+//StatsTop - количество самых частых источников переходов и стран в статистике
+const StatsTop = 10
+
+//TagPattern - allowed alphabet and length of short url tag
+var TagPattern = regexp.MustCompile(fmt.Sprintf(`^[A-Za-z0-9_-]{%d,%d}$`, config.MinTagLength, config.MaxTagLength))
+
+//reservedWords - tags equal to service path names are reserved, short url would collide with the route
+var reservedWords = []string{"api", "ping", "swagger"}
+
+//Service - логика сокращения ссылок, не зависящая от транспорта
+type Service struct {
+	storage   storage.Storage
+	baseURL   string
+	tagLength int
+	delBuf    chan<- models.DelWorker
+	delMu     *sync.RWMutex //защита очереди удаления от записи во время закрытия
+	delStop   chan struct{} //сигнал закрытия очереди удаления для ожидающих отправки
+	delOnce   *sync.Once    //защита от повторного закрытия очереди удаления
+	delClosed bool          //очередь удаления закрыта
+	clicks    *storage.ClickRecorder
+	clickKey  []byte //ключ хеширования адресов клиентов
+	policy    *policy.Engine
+}
+
+//Request - сокращаемая ссылка
+type Request struct {
+	URL       string     //URL - оригинальная ссылка
+	Alias     string     //Alias - пользовательский тег
+	ExpiresAt *time.Time //ExpiresAt - срок действия ссылки
+	TTL       int64      //TTL - время жизни ссылки в секундах, исключает ExpiresAt
+}
+
+//Result - сокращенная ссылка
+type Result struct {
+	Tag      string //Tag - тег короткой ссылки
+	ShortURL string //ShortURL - короткая ссылка
+	Existed  bool   //Existed - ссылка уже была сокращена пользователем ранее
+}
+
+//BatchRequest - элемент списка сокращаемых ссылок
+type BatchRequest struct {
+	Correlation string //Correlation - идентификатор элемента списка
+	Request
+}
+
+//batch item statuses
+const (
+	StatusCreated = "created" //StatusCreated - ссылка сокращена
+	StatusExists  = "exists"  //StatusExists - ссылка уже была сокращена пользователем ранее
+	StatusInvalid = "invalid" //StatusInvalid - элемент списка отклонен
+)
+
+//BatchResult - результат сокращения элемента списка
+type BatchResult struct {
+	Correlation string //Correlation - идентификатор элемента списка
+	Result
+	Err error //Err - причина отказа, элемент не записан
+}
+
+//Status - состояние элемента списка после сокращения
+func (r BatchResult) Status() string {
+	switch {
+	case r.Err != nil:
+		return StatusInvalid
+	case r.Existed:
+		return StatusExists
+	}
+	return StatusCreated
+}
+
+//URL - сокращенная ссылка пользователя
+type URL struct {
+	ShortURL    string //ShortURL - короткая ссылка
+	OriginalURL string //OriginalURL - оригинальная ссылка
+}
+
+//Visit - сведения о клиенте, перешедшем по короткой ссылке
+type Visit struct {
+	Addr      string //Addr - адрес клиента, сохраняется только в виде хеша
+	Referrer  string //Referrer - источник перехода
+	UserAgent string //UserAgent - клиент пользователя
+	Country   string //Country - код страны клиента
+}
+
+//New - создание сервиса поверх хранилища, очереди удаления и учета переходов приложения,
+//политика ссылок может отсутствовать. Без заданного ключа хеширования адресов клиентов используется случайный ключ,
+//уникальные посетители тогда различаются только в пределах одного запуска
+func New(s storage.Storage, cfg *config.Config, delBuf chan<- models.DelWorker, clicks *storage.ClickRecorder, p *policy.Engine) *Service {
+	key := cfg.ClickSecret
+	if key == "" {
+		log.Println("CLICK_HASH_SECRET is not set, random key is used for client address hashing")
+		key = helpers.RandStringRunes(64)
+	}
+	return &Service{
+		storage:   s,
+		baseURL:   cfg.BaseURL,
+		tagLength: cfg.TagLength,
+		delBuf:    delBuf,
+		delMu:     &sync.RWMutex{},
+		delStop:   make(chan struct{}),
+		delOnce:   &sync.Once{},
+		clicks:    clicks,
+		clickKey:  []byte(key),
+		policy:    p,
+	}
+}
+
+//ValidAlias - проверка пользовательского тега короткой ссылки
+func ValidAlias(alias string) bool {
+	if !TagPattern.MatchString(alias) {
+		return false
+	}
+	for _, word := range reservedWords {
+		if strings.EqualFold(alias, word) {
+			return false
+		}
+	}
+	return true
+}
+
+//expiry - срок действия ссылки из запроса, нулевое время - бессрочная ссылка
+func expiry(expiresAt *time.Time, ttl int64) (time.Time, error) {
+	switch {
+	case expiresAt != nil && ttl != 0:
+		return time.Time{}, fmt.Errorf("%w: expires_at and ttl_seconds are mutually exclusive", ErrInvalid)
+	case ttl < 0:
+		return time.Time{}, fmt.Errorf("%w: ttl_seconds must be positive", ErrInvalid)
+	case ttl > MaxTTL:
+		return time.Time{}, fmt.Errorf("%w: ttl_seconds must not exceed %d", ErrInvalid, MaxTTL)
+	case ttl > 0:
+		return time.Now().Add(time.Duration(ttl) * time.Second).UTC(), nil
+	case expiresAt != nil:
+		if !expiresAt.After(time.Now()) {
+			return time.Time{}, fmt.Errorf("%w: expires_at is in the past", ErrInvalid)
+		}
+		return expiresAt.UTC(), nil
+	}
+	return time.Time{}, nil
+}
+
+//validate - проверка запроса на сокращение, нормализация ссылки и проверка ее по политике,
+//возвращает срок действия ссылки
+func (s *Service) validate(req *Request) (time.Time, error) {
+	var err error
+	req.URL, err = NormalizeURL(req.URL)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if id, blocked := s.policy.Check(req.URL); blocked {
+		return time.Time{}, fmt.Errorf("%w by policy rule %q", ErrBlocked, id)
+	}
+	if req.Alias != "" && !ValidAlias(req.Alias) {
+		return time.Time{}, fmt.Errorf("%w: wrong custom alias: %s", ErrInvalid, req.Alias)
+	}
+	return expiry(req.ExpiresAt, req.TTL)
+}
+
+//ShortURL - короткая ссылка по тегу
+func (s *Service) ShortURL(tag string) string {
+	return fmt.Sprintf("%s/%s", s.baseURL, tag)
+}
+
+//Shorten - сокращение ссылки пользователя, для ранее сокращенной ссылки возвращается существующий тег
+func (s *Service) Shorten(ctx context.Context, cookie string, req Request) (Result, error) {
+	expires, err := s.validate(&req)
+	if err != nil {
+		return Result{}, err
+	}
+	return s.write(ctx, cookie, req, expires)
+}
+
+//ShortenBatch - сокращение списка ссылок пользователя, допустимые элементы записываются атомарно,
+//отклоненные элементы возвращаются с причиной отказа
+func (s *Service) ShortenBatch(ctx context.Context, cookie string, reqs []BatchRequest) ([]BatchResult, error) {
+	result := make([]BatchResult, len(reqs))
+	items := make([]models.ShortData, 0, len(reqs))
+	index := make([]int, 0, len(reqs))
+	for i := range reqs {
+		result[i].Correlation = reqs[i].Correlation
+		req := reqs[i].Request
+		expires, err := s.validate(&req)
+		if err != nil {
+			result[i].Err = err
+			continue
+		}
+		item := models.ShortData{Short: req.Alias, Long: req.URL, Expires: expires}
+		if item.Short == "" {
+			item.Short = helpers.RandStringRunes(s.tagLength)
+		}
+		items = append(items, item)
+		index = append(index, i)
+	}
+	for attempt := 1; len(items) > 0; {
+		written, err := s.storage.WriteBatch(ctx, models.ClientData{Cookie: cookie, Short: items})
+		if err == nil {
+			for j, w := range written {
+				result[index[j]].Result = Result{Tag: w.Tag, ShortURL: s.ShortURL(w.Tag), Existed: w.Existed}
+			}
+			break
+		}
+		var batchErr *storage.BatchError
+		if !errors.As(err, &batchErr) {
+			return nil, err
+		}
+		j := batchErr.Index
+		alias := reqs[index[j]].Alias
+		switch {
+		case alias != "" && errors.Is(err, storage.ErrTagTaken):
+			result[index[j]].Err = fmt.Errorf("%w: %s", ErrAliasTaken, alias)
+			items = append(items[:j], items[j+1:]...)
+			index = append(index[:j], index[j+1:]...)
+		case attempt < tagAttempts:
+			attempt++
+			if alias == "" {
+				items[j].Short = helpers.RandStringRunes(s.tagLength)
+			}
+		default:
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+//write - запись ссылки с подбором свободного случайного тега
+func (s *Service) write(ctx context.Context, cookie string, req Request, expires time.Time) (Result, error) {
+	for attempt := 1; ; attempt++ {
+		tag := req.Alias
+		if tag == "" {
+			tag = helpers.RandStringRunes(s.tagLength)
+		}
+		entry := models.ClientData{Cookie: cookie, Short: []models.ShortData{{Short: tag, Long: req.URL, Expires: expires}}}
+		err := s.storage.Write(ctx, entry)
+		switch {
+		case err == nil:
+			return Result{Tag: tag, ShortURL: s.ShortURL(tag)}, nil
+		case errors.Is(err, storage.ErrTagTaken):
+			if req.Alias != "" {
+				return Result{}, fmt.Errorf("%w: %s", ErrAliasTaken, req.Alias)
+			}
+			if attempt < tagAttempts {
+				continue
+			}
+			return Result{}, err
+		case errors.Is(err, storage.ErrConflict):
+			tag, err := s.storage.TagByURL(ctx, req.URL, cookie)
+			if err != nil {
+				return Result{}, err
+			}
+			return Result{Tag: tag, ShortURL: s.ShortURL(tag), Existed: true}, nil
+		}
+		return Result{}, err
+	}
+}
+
+//Resolve - оригинальная ссылка по тегу с учетом перехода
+func (s *Service) Resolve(ctx context.Context, tag string, visit Visit) (string, error) {
+	if !TagPattern.MatchString(tag) {
+		return "", fmt.Errorf("%w: wrong tag: %s", ErrInvalid, tag)
+	}
+	data, err := s.storage.ReadByTag(ctx, tag)
+	if errors.Is(err, storage.ErrNotFound) {
+		return "", ErrNotFound
+	}
+	if errors.Is(err, storage.ErrDeleted) {
+		return "", fmt.Errorf("%w: deleted", ErrGone)
+	}
+	if err != nil {
+		return "", err
+	}
+	if !data.Expires.IsZero() && !time.Now().Before(data.Expires) {
+		return "", fmt.Errorf("%w: expired", ErrGone)
+	}
+	s.clicks.Record(s.click(tag, visit))
+	return data.Long, nil
+}
+
+//click - переход по короткой ссылке, адрес клиента сохраняется только в виде HMAC с ключом сервера
+func (s *Service) click(tag string, visit Visit) models.Click {
+	ip, _, err := net.SplitHostPort(visit.Addr)
+	if err != nil {
+		ip = visit.Addr
+	}
+	h := hmac.New(sha256.New, s.clickKey)
+	h.Write([]byte(ip))
+	return models.Click{
+		Tag:       tag,
+		Time:      time.Now().UTC(),
+		Referrer:  visit.Referrer,
+		UserAgent: visit.UserAgent,
+		IPHash:    hex.EncodeToString(h.Sum(nil)),
+		Country:   visit.Country,
+	}
+}
+
+//List - все сокращенные ссылки пользователя
+func (s *Service) List(ctx context.Context, cookie string) ([]URL, error) {
+	data, err := s.storage.ReadByCookie(ctx, cookie)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return nil, err
+	}
+	result := make([]URL, 0, len(data.Short))
+	for _, content := range data.Short {
+		result = append(result, URL{ShortURL: s.ShortURL(content.Short), OriginalURL: content.Long})
+	}
+	return result, nil
+}
+
+//Stats - статистика переходов по короткой ссылке пользователя
+func (s *Service) Stats(ctx context.Context, cookie, tag string) (models.Stats, error) {
+	if !TagPattern.MatchString(tag) {
+		return models.Stats{}, fmt.Errorf("%w: wrong tag: %s", ErrInvalid, tag)
+	}
+	data, err := s.storage.ReadByCookie(ctx, cookie)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return models.Stats{}, err
+	}
+	for _, content := range data.Short {
+		if content.Short == tag {
+			return s.storage.Stats(ctx, tag, StatsTop)
+		}
+	}
+	return models.Stats{}, ErrNotFound
+}
+
+//Delete - постановка коротких ссылок пользователя в очередь на удаление, список с неверным тегом отклоняется целиком
+func (s *Service) Delete(ctx context.Context, cookie string, tags []string) error {
+	for _, tag := range tags {
+		if !TagPattern.MatchString(tag) {
+			return fmt.Errorf("%w: wrong tag: %s", ErrInvalid, tag)
+		}
+	}
+	s.delMu.RLock()
+	defer s.delMu.RUnlock()
+	if s.delClosed {
+		return ErrClosed
+	}
+	select {
+	case s.delBuf <- models.DelWorker{Cookie: cookie, Tags: tags}:
+		return nil
+	case <-s.delStop:
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+//Close - закрытие очереди удаления, ожидающие постановки в очередь запросы получают ErrClosed
+func (s *Service) Close() {
+	s.delOnce.Do(func() {
+		//ожидающие отправки освобождают блокировку до закрытия очереди
+		close(s.delStop)
+		s.delMu.Lock()
+		s.delClosed = true
+		close(s.delBuf)
+		s.delMu.Unlock()
+	})
+}
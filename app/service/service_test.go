@@ -0,0 +1,379 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/t1mon-ggg/go_shortner/app/config"
+	"github.com/t1mon-ggg/go_shortner/app/models"
+	"github.com/t1mon-ggg/go_shortner/app/policy"
+	"github.com/t1mon-ggg/go_shortner/app/storage"
+)
+
+//takenStorage - хранилище, отвечающее занятым тегом на первые записи
+type takenStorage struct {
+	storage.Storage
+	taken int
+}
+
+func (s *takenStorage) Write(ctx context.Context, m models.ClientData) error {
+	if s.taken > 0 {
+		s.taken--
+		return storage.ErrTagTaken
+	}
+	return s.Storage.Write(ctx, m)
+}
+
+func (s *takenStorage) WriteBatch(ctx context.Context, m models.ClientData) ([]storage.WriteResult, error) {
+	if s.taken > 0 {
+		s.taken--
+		return nil, &storage.BatchError{Index: len(m.Short) - 1, Err: storage.ErrTagTaken}
+	}
+	return s.Storage.WriteBatch(ctx, m)
+}
+
+//clickStorage - хранилище, запоминающее записанные переходы
+type clickStorage struct {
+	storage.Storage
+	clicks []models.Click
+}
+
+func (s *clickStorage) WriteClicks(ctx context.Context, clicks []models.Click) error {
+	s.clicks = append(s.clicks, clicks...)
+	return s.Storage.WriteClicks(ctx, clicks)
+}
+
+func newTestService(t *testing.T, s storage.Storage) (*Service, chan models.DelWorker) {
+	cfg := &config.Config{BaseURL: "http://127.0.0.1:8080", TagLength: 8}
+	delBuf := make(chan models.DelWorker, 10)
+	clicks := storage.NewClickRecorder(s, storage.DefaultTimeouts.Long)
+	t.Cleanup(clicks.Close)
+	return New(s, cfg, delBuf, clicks, nil), delBuf
+}
+
+func Test_Service_Shorten(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestService(t, storage.NewRAM())
+	first, err := s.Shorten(ctx, "cookie1", Request{URL: "http://example1.org"})
+	require.NoError(t, err)
+	require.Len(t, first.Tag, 8)
+	require.Equal(t, "http://127.0.0.1:8080/"+first.Tag, first.ShortURL)
+	require.False(t, first.Existed)
+	future := time.Now().Add(time.Hour)
+	past := time.Now().Add(-time.Hour)
+	tests := []struct {
+		name   string
+		cookie string
+		req    Request
+		want   Result
+		err    error
+	}{
+		{
+			name:   "Already shortened",
+			cookie: "cookie1",
+			req:    Request{URL: "http://example1.org"},
+			want:   Result{Tag: first.Tag, ShortURL: first.ShortURL, Existed: true},
+		},
+		{
+			name:   "Normalized url",
+			cookie: "cookie1",
+			req:    Request{URL: " HTTP://Example1.ORG:80"},
+			want:   Result{Tag: first.Tag, ShortURL: first.ShortURL, Existed: true},
+		},
+		{
+			name:   "Unsupported scheme",
+			cookie: "cookie1",
+			req:    Request{URL: "ftp://example1.org"},
+			err:    ErrInvalid,
+		},
+		{
+			name:   "Same url of other user",
+			cookie: "cookie2",
+			req:    Request{URL: "http://example1.org", Alias: "other_user"},
+			want:   Result{Tag: "other_user", ShortURL: "http://127.0.0.1:8080/other_user"},
+		},
+		{
+			name:   "Alias taken",
+			cookie: "cookie1",
+			req:    Request{URL: "http://example2.org", Alias: "other_user"},
+			err:    ErrAliasTaken,
+		},
+		{
+			name:   "Reserved alias",
+			cookie: "cookie1",
+			req:    Request{URL: "http://example2.org", Alias: "Swagger"},
+			err:    ErrInvalid,
+		},
+		{
+			name:   "Short alias",
+			cookie: "cookie1",
+			req:    Request{URL: "http://example2.org", Alias: "abc"},
+			err:    ErrInvalid,
+		},
+		{
+			name:   "Empty url",
+			cookie: "cookie1",
+			req:    Request{},
+			err:    ErrInvalid,
+		},
+		{
+			name:   "TTL and expiration",
+			cookie: "cookie1",
+			req:    Request{URL: "http://example2.org", TTL: 60, ExpiresAt: &future},
+			err:    ErrInvalid,
+		},
+		{
+			name:   "Negative TTL",
+			cookie: "cookie1",
+			req:    Request{URL: "http://example2.org", TTL: -1},
+			err:    ErrInvalid,
+		},
+		{
+			name:   "Huge TTL",
+			cookie: "cookie1",
+			req:    Request{URL: "http://example2.org", TTL: math.MaxInt64},
+			err:    ErrInvalid,
+		},
+		{
+			name:   "Expiration in the past",
+			cookie: "cookie1",
+			req:    Request{URL: "http://example2.org", ExpiresAt: &past},
+			err:    ErrInvalid,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := s.Shorten(ctx, tt.cookie, tt.req)
+			if tt.err != nil {
+				require.ErrorIs(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, result)
+		})
+	}
+}
+
+func Test_Service_RandomTagTaken(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestService(t, &takenStorage{Storage: storage.NewRAM(), taken: tagAttempts - 1})
+	_, err := s.Shorten(ctx, "cookie1", Request{URL: "http://example1.org"})
+	require.NoError(t, err)
+	s, _ = newTestService(t, &takenStorage{Storage: storage.NewRAM(), taken: tagAttempts})
+	_, err = s.Shorten(ctx, "cookie1", Request{URL: "http://example1.org"})
+	require.ErrorIs(t, err, storage.ErrTagTaken)
+
+	s, _ = newTestService(t, &takenStorage{Storage: storage.NewRAM(), taken: tagAttempts - 1})
+	_, err = s.ShortenBatch(ctx, "cookie1", []BatchRequest{{Correlation: "1", Request: Request{URL: "http://example1.org"}}})
+	require.NoError(t, err)
+	s, _ = newTestService(t, &takenStorage{Storage: storage.NewRAM(), taken: tagAttempts})
+	_, err = s.ShortenBatch(ctx, "cookie1", []BatchRequest{{Correlation: "1", Request: Request{URL: "http://example1.org"}}})
+	require.ErrorIs(t, err, storage.ErrTagTaken)
+}
+
+func Test_Service_ShortenBatch(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestService(t, storage.NewRAM())
+	results, err := s.ShortenBatch(ctx, "cookie1", []BatchRequest{
+		{Correlation: "1", Request: Request{URL: "http://example1.org"}},
+		{Correlation: "2", Request: Request{URL: "http://example2.org", Alias: "swagger"}},
+		{Correlation: "3", Request: Request{URL: "http://example2.org", Alias: "batch_alias"}},
+		{Correlation: "4", Request: Request{URL: "http://example1.org"}},
+		{Correlation: "5", Request: Request{}},
+	})
+	require.NoError(t, err)
+	require.Len(t, results, 5)
+	for i, want := range []string{StatusCreated, StatusInvalid, StatusCreated, StatusExists, StatusInvalid} {
+		require.Equal(t, fmt.Sprint(i+1), results[i].Correlation)
+		require.Equal(t, want, results[i].Status())
+	}
+	require.ErrorIs(t, results[1].Err, ErrInvalid)
+	require.Empty(t, results[1].ShortURL)
+	require.Equal(t, "http://127.0.0.1:8080/batch_alias", results[2].ShortURL)
+	require.Equal(t, results[0].Tag, results[3].Tag)
+	require.ErrorIs(t, results[4].Err, ErrInvalid)
+
+	urls, err := s.List(ctx, "cookie1")
+	require.NoError(t, err)
+	require.Equal(t, []URL{
+		{ShortURL: results[0].ShortURL, OriginalURL: "http://example1.org"},
+		{ShortURL: "http://127.0.0.1:8080/batch_alias", OriginalURL: "http://example2.org"},
+	}, urls)
+
+	results, err = s.ShortenBatch(ctx, "cookie2", []BatchRequest{
+		{Correlation: "1", Request: Request{URL: "http://example3.org", Alias: "batch_alias"}},
+		{Correlation: "2", Request: Request{URL: "http://example4.org", Alias: "other_alias"}},
+		{Correlation: "3", Request: Request{URL: "http://example5.org", Alias: "other_alias"}},
+	})
+	require.NoError(t, err)
+	require.ErrorIs(t, results[0].Err, ErrAliasTaken)
+	require.Equal(t, StatusCreated, results[1].Status())
+	require.ErrorIs(t, results[2].Err, ErrAliasTaken)
+	urls, err = s.List(ctx, "cookie2")
+	require.NoError(t, err)
+	require.Equal(t, []URL{{ShortURL: "http://127.0.0.1:8080/other_alias", OriginalURL: "http://example4.org"}}, urls)
+}
+
+func Test_Service_Policy(t *testing.T) {
+	ctx := context.Background()
+	name := filepath.Join(t.TempDir(), "policy.yaml")
+	require.NoError(t, os.WriteFile(name, []byte("rules:\n  - id: evil\n    action: block\n    hosts: [\"*.evil.example\"]\n  - id: loopback\n    action: block\n    cidrs: [127.0.0.0/8]\n"), 0600))
+	p, err := policy.Load(name, time.Hour)
+	require.NoError(t, err)
+	defer p.Close()
+	s, _ := newTestService(t, storage.NewRAM())
+	s.policy = p
+	_, err = s.Shorten(ctx, "cookie1", Request{URL: "http://WWW.Evil.Example:80/page"})
+	require.ErrorIs(t, err, ErrBlocked)
+	require.EqualError(t, err, `url is blocked by policy rule "evil"`)
+	_, err = s.Shorten(ctx, "cookie1", Request{URL: "http://evil.example/page"})
+	require.NoError(t, err)
+	for _, url := range []string{"http://2130706433/", "http://127.1/", "http://0x7f.1/"} {
+		_, err = s.Shorten(ctx, "cookie1", Request{URL: url})
+		require.EqualError(t, err, `url is blocked by policy rule "loopback"`)
+	}
+
+	results, err := s.ShortenBatch(ctx, "cookie1", []BatchRequest{
+		{Correlation: "1", Request: Request{URL: "http://a.evil.example"}},
+		{Correlation: "2", Request: Request{URL: "http://example1.org"}},
+	})
+	require.NoError(t, err)
+	require.ErrorIs(t, results[0].Err, ErrBlocked)
+	require.Equal(t, StatusInvalid, results[0].Status())
+	require.Equal(t, StatusCreated, results[1].Status())
+}
+
+func Test_Service_Resolve(t *testing.T) {
+	ctx := context.Background()
+	ram := storage.NewRAM()
+	require.NoError(t, ram.Write(ctx, models.ClientData{Cookie: "cookie1", Short: []models.ShortData{
+		{Short: "resolve_ok", Long: "http://example1.org"},
+		{Short: "deleted_tag", Long: "http://example2.org", Deleted: true},
+		{Short: "expired_tag", Long: "http://example3.org", Expires: time.Now().Add(-time.Minute)},
+	}}))
+	s, _ := newTestService(t, ram)
+	tests := []struct {
+		tag  string
+		want string
+		err  error
+	}{
+		{tag: "resolve_ok", want: "http://example1.org"},
+		{tag: "deleted_tag", err: ErrGone},
+		{tag: "expired_tag", err: ErrGone},
+		{tag: "not_exist", err: ErrNotFound},
+		{tag: "a/b", err: ErrInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tag, func(t *testing.T) {
+			long, err := s.Resolve(ctx, tt.tag, Visit{Addr: "10.0.0.1:5000", Country: "NL"})
+			if tt.err != nil {
+				require.ErrorIs(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, long)
+		})
+	}
+	s.clicks.Close()
+	stats, err := ram.Stats(ctx, "resolve_ok", StatsTop)
+	require.NoError(t, err)
+	require.Equal(t, 1, stats.Clicks)
+	require.Equal(t, []models.TopItem{{Name: "NL", Clicks: 1}}, stats.Countries)
+	stats, err = ram.Stats(ctx, "expired_tag", StatsTop)
+	require.NoError(t, err)
+	require.Equal(t, 0, stats.Clicks)
+}
+
+func Test_Service_ClickHash(t *testing.T) {
+	ctx := context.Background()
+	sink := &clickStorage{Storage: storage.NewRAM()}
+	require.NoError(t, sink.Write(ctx, models.ClientData{Cookie: "cookie1", Short: []models.ShortData{{Short: "hash_tag", Long: "http://example1.org"}}}))
+	hashes := make([]string, 0)
+	for _, secret := range []string{"first_click_secret", "first_click_secret", "other_click_secret"} {
+		cfg := &config.Config{BaseURL: "http://127.0.0.1:8080", TagLength: 8, ClickSecret: secret}
+		clicks := storage.NewClickRecorder(sink, storage.DefaultTimeouts.Long)
+		s := New(sink, cfg, nil, clicks, nil)
+		_, err := s.Resolve(ctx, "hash_tag", Visit{Addr: "10.0.0.1:5000"})
+		require.NoError(t, err)
+		clicks.Close()
+		require.Len(t, sink.clicks, len(hashes)+1)
+		hashes = append(hashes, sink.clicks[len(hashes)].IPHash)
+	}
+	h := hmac.New(sha256.New, []byte("first_click_secret"))
+	h.Write([]byte("10.0.0.1"))
+	require.Equal(t, hex.EncodeToString(h.Sum(nil)), hashes[0])
+	require.Equal(t, hashes[0], hashes[1])
+	require.NotEqual(t, hashes[0], hashes[2])
+	plain := sha256.Sum256([]byte("10.0.0.1"))
+	require.NotEqual(t, hex.EncodeToString(plain[:]), hashes[2])
+}
+
+func Test_Service_Stats(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestService(t, storage.NewRAM())
+	_, err := s.Shorten(ctx, "cookie1", Request{URL: "http://example1.org", Alias: "stats_tag"})
+	require.NoError(t, err)
+	stats, err := s.Stats(ctx, "cookie1", "stats_tag")
+	require.NoError(t, err)
+	require.Equal(t, "stats_tag", stats.Tag)
+	_, err = s.Stats(ctx, "cookie2", "stats_tag")
+	require.ErrorIs(t, err, ErrNotFound)
+	_, err = s.Stats(ctx, "cookie1", "a/b")
+	require.ErrorIs(t, err, ErrInvalid)
+}
+
+func Test_Service_Delete(t *testing.T) {
+	s, delBuf := newTestService(t, storage.NewRAM())
+	require.NoError(t, s.Delete(context.Background(), "cookie1", []string{"del_tag1", "del_tag2"}))
+	require.Equal(t, models.DelWorker{Cookie: "cookie1", Tags: []string{"del_tag1", "del_tag2"}}, <-delBuf)
+	require.ErrorIs(t, s.Delete(context.Background(), "cookie1", []string{"del_tag1", "a/b"}), ErrInvalid)
+	require.Empty(t, delBuf)
+	for i := 0; i < cap(delBuf); i++ {
+		require.NoError(t, s.Delete(context.Background(), "cookie1", []string{fmt.Sprintf("del_tag%d", i)}))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.ErrorIs(t, s.Delete(ctx, "cookie1", []string{"del_tag"}), context.Canceled)
+	//queue is full, waiting request is released by Close
+	errCh := make(chan error)
+	go func() {
+		errCh <- s.Delete(context.Background(), "cookie1", []string{"del_tag"})
+	}()
+	s.Close()
+	require.ErrorIs(t, <-errCh, ErrClosed)
+	require.ErrorIs(t, s.Delete(context.Background(), "cookie1", []string{"del_tag"}), ErrClosed)
+	s.Close()
+}
+
+func Test_ValidAlias(t *testing.T) {
+	tests := []struct {
+		alias string
+		want  bool
+	}{
+		{alias: "my_alias", want: true},
+		{alias: "my_api_link", want: true},
+		{alias: "api_alias", want: true},
+		{alias: "pingpong", want: true},
+		{alias: "apiary", want: true},
+		{alias: "swaggerish", want: true},
+		{alias: "swagger"},
+		{alias: "SWAGGER"},
+		{alias: "api"},
+		{alias: "my/alias"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.alias, func(t *testing.T) {
+			require.Equal(t, tt.want, ValidAlias(tt.alias))
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/idna"
+
+	"github.com/t1mon-ggg/go_shortner/app/policy"
+)
+
+//MaxURLLength - максимальная длина сокращаемой ссылки
+const MaxURLLength = 8192
+
+//defaultPorts - разрешенные схемы ссылок и их порты по умолчанию
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+//hostProfile - профиль IDNA для хостов ссылок: как idna.Lookup, но без правил STD3 и проверки дефисов,
+//которые отвергают реальные имена вида my_host.example.com и r3---sn-4g5e6nsz.googlevideo.com
+var hostProfile = idna.New(idna.MapForLookup(), idna.BidiRule(), idna.StrictDomainName(false), idna.CheckHyphens(false))
+
+//NormalizeURL - проверка и приведение ссылки к каноническому виду: схема и хост в нижнем регистре,
+//международные домены в punycode без корневой точки, адреса IPv4 в десятичной записи с точками, порт по умолчанию удален
+func NormalizeURL(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	switch {
+	case raw == "":
+		return "", fmt.Errorf("%w: empty url", ErrInvalid)
+	case len(raw) > MaxURLLength:
+		return "", fmt.Errorf("%w: url is longer than %d bytes", ErrInvalid, MaxURLLength)
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("%w: malformed url: %v", ErrInvalid, err)
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	defaultPort, ok := defaultPorts[u.Scheme]
+	if !ok {
+		return "", fmt.Errorf("%w: unsupported url scheme %q, only http and https are allowed", ErrInvalid, u.Scheme)
+	}
+	host := u.Hostname()
+	if host == "" {
+		return "", fmt.Errorf("%w: url host is required", ErrInvalid)
+	}
+	switch ip := net.ParseIP(host); {
+	case ip == nil:
+		host, err = hostProfile.ToASCII(host)
+		if err != nil {
+			return "", fmt.Errorf("%w: invalid url host: %v", ErrInvalid, err)
+		}
+		//корневая точка не меняет адрес, example.org. и example.org - одна ссылка
+		host = strings.TrimSuffix(host, ".")
+		if host == "" {
+			return "", fmt.Errorf("%w: url host is required", ErrInvalid)
+		}
+		ip4, ok, err := policy.ParseIPv4(host)
+		if err != nil {
+			return "", fmt.Errorf("%w: invalid url host: %v", ErrInvalid, err)
+		}
+		if ok {
+			host = ip4.String()
+		}
+	case !strings.Contains(host, ":"):
+		host = ip.String()
+	case ip.To4() != nil:
+		//IPv4-mapped IPv6 address keeps its IPv6 form, ip.String() would print it as IPv4
+		host = "[::ffff:" + ip.To4().String() + "]"
+	default:
+		host = "[" + ip.String() + "]"
+	}
+	port := u.Port()
+	if port != "" {
+		n, err := strconv.Atoi(port)
+		if err != nil || n < 1 || n > 65535 {
+			return "", fmt.Errorf("%w: invalid url port %q", ErrInvalid, port)
+		}
+		port = strconv.Itoa(n)
+	}
+	if port == "" || port == defaultPort {
+		u.Host = host
+	} else {
+		u.Host = host + ":" + port
+	}
+	return u.String(), nil
+}
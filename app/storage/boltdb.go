@@ -0,0 +1,500 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"log"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/t1mon-ggg/go_shortner/app/helpers"
+	"github.com/t1mon-ggg/go_shortner/app/models"
+)
+
+//bolt buckets
+var (
+	usersBucket  = []byte("users")  //cookie -> cookie sign key
+	tagsBucket   = []byte("tags")   //tag -> boltRecord
+	urlsBucket   = []byte("urls")   //cookie + long url -> tag, only for not deleted urls
+	ownersBucket = []byte("owners") //cookie + sequence -> tag, keeps user urls in write order
+	clicksBucket = []byte("clicks") //tag + sequence -> models.Click
+)
+
+//boltStorage - struct for embedded key-value storage implementation
+type boltStorage struct {
+	name string   //имя файла базы данных
+	db   *bolt.DB //дескриптор для работы с базой
+}
+
+//boltRecord - short url record stored in tags bucket
+type boltRecord struct {
+	Cookie  string    `json:"cookie"`               //Cookie - owner of short url
+	Long    string    `json:"long"`                 //Long - original url
+	Deleted bool      `json:"deleted"`              //Deleted - current short url status
+	Expires time.Time `json:"expires_at,omitempty"` //Expires - short url expiration time
+}
+
+//NewBolt - создание встроенного key-value хранилища в файле
+func NewBolt(name string) (*boltStorage, error) {
+	s := boltStorage{name: name}
+	db, err := bolt.Open(name, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	s.db = db
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{usersBucket, tagsBucket, urlsBucket, ownersBucket, clicksBucket} {
+			_, err := tx.CreateBucketIfNotExists(bucket)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		s.db.Close()
+		return nil, err
+	}
+	log.Println("Bolt storage opened:", name)
+	return &s, nil
+}
+
+//urlKey - key for long url index
+func urlKey(cookie, long string) []byte {
+	return []byte(cookie + "\x00" + long)
+}
+
+//ownerPrefix - key prefix for user urls
+func ownerPrefix(cookie string) []byte {
+	return []byte(cookie + "\x00")
+}
+
+//ownerKey - key for user url with sequence number
+func ownerKey(cookie string, seq uint64) []byte {
+	key := ownerPrefix(cookie)
+	num := make([]byte, 8)
+	binary.BigEndian.PutUint64(num, seq)
+	return append(key, num...)
+}
+
+//Write - запись в хранилище в одной транзакции
+func (s *boltStorage) Write(ctx context.Context, data models.ClientData) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		users := tx.Bucket(usersBucket)
+		tags := tx.Bucket(tagsBucket)
+		urls := tx.Bucket(urlsBucket)
+		owners := tx.Bucket(ownersBucket)
+		if users.Get([]byte(data.Cookie)) == nil {
+			err := users.Put([]byte(data.Cookie), []byte(data.Key))
+			if err != nil {
+				return err
+			}
+		}
+		for _, value := range data.Short {
+			if !value.Deleted {
+				tag, err := liveTag(tx, data.Cookie, value.Long)
+				if err != nil {
+					return err
+				}
+				if tag != nil {
+					return ErrConflict
+				}
+			}
+			if tags.Get([]byte(value.Short)) != nil {
+				return ErrTagTaken
+			}
+			if !value.Deleted {
+				err := release(tx, data.Cookie, value.Long)
+				if err != nil {
+					return err
+				}
+			}
+			record, err := json.Marshal(boltRecord{Cookie: data.Cookie, Long: value.Long, Deleted: value.Deleted, Expires: value.Expires})
+			if err != nil {
+				return err
+			}
+			err = tags.Put([]byte(value.Short), record)
+			if err != nil {
+				return err
+			}
+			if !value.Deleted {
+				err = urls.Put(urlKey(data.Cookie, value.Long), []byte(value.Short))
+				if err != nil {
+					return err
+				}
+			}
+			seq, err := owners.NextSequence()
+			if err != nil {
+				return err
+			}
+			err = owners.Put(ownerKey(data.Cookie, seq), []byte(value.Short))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+//WriteBatch - запись ссылок пользователя в одной транзакции, ранее сокращенные URL не записываются
+func (s *boltStorage) WriteBatch(ctx context.Context, data models.ClientData) ([]WriteResult, error) {
+	result := make([]WriteResult, len(data.Short))
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		users := tx.Bucket(usersBucket)
+		tags := tx.Bucket(tagsBucket)
+		urls := tx.Bucket(urlsBucket)
+		owners := tx.Bucket(ownersBucket)
+		if users.Get([]byte(data.Cookie)) == nil {
+			err := users.Put([]byte(data.Cookie), []byte(data.Key))
+			if err != nil {
+				return err
+			}
+		}
+		for i, value := range data.Short {
+			if !value.Deleted {
+				tag, err := liveTag(tx, data.Cookie, value.Long)
+				if err != nil {
+					return err
+				}
+				if tag != nil {
+					result[i] = WriteResult{Tag: string(tag), Existed: true}
+					continue
+				}
+			}
+			if tags.Get([]byte(value.Short)) != nil {
+				return &BatchError{Index: i, Err: ErrTagTaken}
+			}
+			if !value.Deleted {
+				err := release(tx, data.Cookie, value.Long)
+				if err != nil {
+					return err
+				}
+			}
+			record, err := json.Marshal(boltRecord{Cookie: data.Cookie, Long: value.Long, Deleted: value.Deleted, Expires: value.Expires})
+			if err != nil {
+				return err
+			}
+			err = tags.Put([]byte(value.Short), record)
+			if err != nil {
+				return err
+			}
+			if !value.Deleted {
+				err = urls.Put(urlKey(data.Cookie, value.Long), []byte(value.Short))
+				if err != nil {
+					return err
+				}
+			}
+			seq, err := owners.NextSequence()
+			if err != nil {
+				return err
+			}
+			err = owners.Put(ownerKey(data.Cookie, seq), []byte(value.Short))
+			if err != nil {
+				return err
+			}
+			result[i] = WriteResult{Tag: value.Short}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+//ReadByCookie - чтение из хранилища по cookie
+func (s *boltStorage) ReadByCookie(ctx context.Context, cookie string) (models.ClientData, error) {
+	a := models.ClientData{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		key := tx.Bucket(usersBucket).Get([]byte(cookie))
+		if key == nil {
+			return ErrNotFound
+		}
+		var err error
+		a, err = readUser(tx, cookie, key)
+		return err
+	})
+	if err != nil {
+		return models.ClientData{}, err
+	}
+	return a, nil
+}
+
+//readUser - сборка данных пользователя в транзакции
+func readUser(tx *bolt.Tx, cookie string, key []byte) (models.ClientData, error) {
+	a := models.ClientData{Cookie: cookie, Key: string(key), Short: make([]models.ShortData, 0)}
+	tags := tx.Bucket(tagsBucket)
+	prefix := ownerPrefix(cookie)
+	c := tx.Bucket(ownersBucket).Cursor()
+	for k, tag := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, tag = c.Next() {
+		record := boltRecord{}
+		err := json.Unmarshal(tags.Get(tag), &record)
+		if err != nil {
+			return models.ClientData{}, err
+		}
+		a.Short = append(a.Short, models.ShortData{Short: string(tag), Long: record.Long, Deleted: record.Deleted, Expires: record.Expires})
+	}
+	return a, nil
+}
+
+//Export - потоковое чтение данных всех пользователей
+func (s *boltStorage) Export(ctx context.Context, fn func(models.ClientData) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(usersBucket).ForEach(func(cookie, key []byte) error {
+			a, err := readUser(tx, string(cookie), key)
+			if err != nil {
+				return err
+			}
+			return fn(a)
+		})
+	})
+}
+
+//ReadByTag - чтение из хранилища по тегу
+func (s *boltStorage) ReadByTag(ctx context.Context, tag string) (models.ShortData, error) {
+	record := boltRecord{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(tagsBucket).Get([]byte(tag))
+		if value == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(value, &record)
+	})
+	if err != nil {
+		return models.ShortData{}, err
+	}
+	m := models.ShortData{Short: tag, Long: record.Long, Deleted: record.Deleted, Expires: record.Expires}
+	if m.Deleted {
+		return m, ErrDeleted
+	}
+	return m, nil
+}
+
+//Expired - поиск не удаленных ссылок с истекшим сроком действия
+func (s *boltStorage) Expired(ctx context.Context, now time.Time) ([]models.DelWorker, error) {
+	byCookie := make(map[string]*models.DelWorker)
+	result := make([]models.DelWorker, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(tagsBucket).ForEach(func(tag, value []byte) error {
+			record := boltRecord{}
+			err := json.Unmarshal(value, &record)
+			if err != nil {
+				return err
+			}
+			if record.Deleted || record.Expires.IsZero() || record.Expires.After(now) {
+				return nil
+			}
+			task, ok := byCookie[record.Cookie]
+			if !ok {
+				task = &models.DelWorker{Cookie: record.Cookie, Tags: make([]string, 0)}
+				byCookie[record.Cookie] = task
+			}
+			task.Tags = append(task.Tags, string(tag))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, task := range byCookie {
+		result = append(result, *task)
+	}
+	return result, nil
+}
+
+//TagByURL - поиск тега по исходному url пользователя
+func (s *boltStorage) TagByURL(ctx context.Context, url, cookie string) (string, error) {
+	var tag string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		value, err := liveTag(tx, cookie, url)
+		if err != nil {
+			return err
+		}
+		if value == nil {
+			return ErrNotFound
+		}
+		tag = string(value)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return tag, nil
+}
+
+//WriteClicks - сохранение переходов по коротким ссылкам
+func (s *boltStorage) WriteClicks(ctx context.Context, clicks []models.Click) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(clicksBucket)
+		for _, click := range clicks {
+			value, err := json.Marshal(click)
+			if err != nil {
+				return err
+			}
+			seq, err := bucket.NextSequence()
+			if err != nil {
+				return err
+			}
+			err = bucket.Put(ownerKey(click.Tag, seq), value)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+//Stats - статистика переходов по тегу
+func (s *boltStorage) Stats(ctx context.Context, tag string, top int) (models.Stats, error) {
+	clicks := make([]models.Click, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		prefix := ownerPrefix(tag)
+		c := tx.Bucket(clicksBucket).Cursor()
+		for k, value := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, value = c.Next() {
+			click := models.Click{}
+			err := json.Unmarshal(value, &click)
+			if err != nil {
+				return err
+			}
+			clicks = append(clicks, click)
+		}
+		return nil
+	})
+	if err != nil {
+		return models.Stats{}, err
+	}
+	return clickStats(tag, clicks, top), nil
+}
+
+//CountURLs - количество не удаленных коротких ссылок
+func (s *boltStorage) CountURLs(ctx context.Context) (int, error) {
+	return s.count(urlsBucket)
+}
+
+//CountUsers - количество пользователей
+func (s *boltStorage) CountUsers(ctx context.Context) (int, error) {
+	return s.count(usersBucket)
+}
+
+//count - количество ключей в корзине
+func (s *boltStorage) count(bucket []byte) (int, error) {
+	var count int
+	err := s.db.View(func(tx *bolt.Tx) error {
+		count = tx.Bucket(bucket).Stats().KeyN
+		return nil
+	})
+	return count, err
+}
+
+//Close - закрытие файла базы данных
+func (s *boltStorage) Close() error {
+	return s.db.Close()
+}
+
+//Ping - проверка доступности хранилища
+func (s *boltStorage) Ping(ctx context.Context) error {
+	log.Println("Check connection to bolt storage")
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(tagsBucket) == nil {
+			return bolt.ErrBucketNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println("Bolt storage check failed")
+		return err
+	}
+	log.Println("Connection to bolt storage confirmed")
+	return nil
+}
+
+//Cleaner - delete task worker creator, returns after inputCh is closed and all tasks are done
+func (s *boltStorage) Cleaner(inputCh <-chan models.DelWorker, workers int) {
+	helpers.Workers(helpers.FanOut(inputCh, workers), s.newWorker)
+}
+
+//deleteTag - mark tag as deleted
+func (s *boltStorage) deleteTag(task models.DelWorker) {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		tags := tx.Bucket(tagsBucket)
+		for _, tag := range task.Tags {
+			value := tags.Get([]byte(tag))
+			if value == nil {
+				continue
+			}
+			record := boltRecord{}
+			err := json.Unmarshal(value, &record)
+			if err != nil {
+				return err
+			}
+			if record.Cookie != task.Cookie || record.Deleted {
+				continue
+			}
+			err = markDeleted(tx, []byte(tag), record)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println("Error while deleting tags:", err)
+	}
+}
+
+//markDeleted - пометка ссылки как удаленной и освобождение url для повторного сокращения
+func markDeleted(tx *bolt.Tx, tag []byte, record boltRecord) error {
+	record.Deleted = true
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	err = tx.Bucket(tagsBucket).Put(tag, value)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(urlsBucket).Delete(urlKey(record.Cookie, record.Long))
+}
+
+//liveTag - тег не удаленной ссылки пользователя на url с не истекшим сроком действия, nil если такой ссылки нет
+func liveTag(tx *bolt.Tx, cookie, long string) ([]byte, error) {
+	tag := tx.Bucket(urlsBucket).Get(urlKey(cookie, long))
+	if tag == nil {
+		return nil, nil
+	}
+	record := boltRecord{}
+	err := json.Unmarshal(tx.Bucket(tagsBucket).Get(tag), &record)
+	if err != nil {
+		return nil, err
+	}
+	if !record.Expires.IsZero() && !record.Expires.After(time.Now()) {
+		return nil, nil
+	}
+	return tag, nil
+}
+
+//release - пометка ссылки пользователя на url как удаленной перед повторным сокращением url.
+//Вызывается после проверки liveTag, поэтому освобождается только ссылка с истекшим сроком действия
+func release(tx *bolt.Tx, cookie, long string) error {
+	tag := tx.Bucket(urlsBucket).Get(urlKey(cookie, long))
+	if tag == nil {
+		return nil
+	}
+	tag = append([]byte(nil), tag...)
+	record := boltRecord{}
+	err := json.Unmarshal(tx.Bucket(tagsBucket).Get(tag), &record)
+	if err != nil {
+		return err
+	}
+	return markDeleted(tx, tag, record)
+}
+
+//newWorker - delete task worker
+func (s *boltStorage) newWorker(input <-chan models.DelWorker) {
+	for task := range input {
+		s.deleteTag(task)
+	}
+}
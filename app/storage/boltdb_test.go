@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/t1mon-ggg/go_shortner/app/models"
+)
+
+func newTestBolt(t *testing.T) *boltStorage {
+	s, err := NewBolt(filepath.Join(t.TempDir(), "shortener.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	data := []models.ClientData{
+		{
+			Cookie: "cookie1",
+			Key:    "secret_key1",
+			Short: []models.ShortData{
+				{
+					Short: "abcdABC1",
+					Long:  "http://example1.org",
+				},
+			},
+		},
+		{
+			Cookie: "cookie2",
+			Key:    "secret_key2",
+			Short: []models.ShortData{
+				{
+					Short: "abcdABC2",
+					Long:  "http://example2.org",
+				},
+			},
+		},
+		{
+			Cookie: "cookie3",
+			Key:    "secret_key3",
+			Short: []models.ShortData{
+				{
+					Short: "abcdABC3",
+					Long:  "http://example3.org",
+				},
+			},
+		},
+	}
+	for _, value := range data {
+		err := s.Write(context.Background(), value)
+		require.NoError(t, err)
+	}
+	return s
+}
+
+func Test_Bolt_Ping(t *testing.T) {
+	s := newTestBolt(t)
+	err := s.Ping(context.Background())
+	require.NoError(t, err)
+}
+
+func Test_Bolt_Write(t *testing.T) {
+	s := newTestBolt(t)
+	ctx := context.Background()
+	err := s.Write(ctx, models.ClientData{Cookie: "cookie2", Short: []models.ShortData{{Short: "abcdABC4", Long: "http://example4.org"}}})
+	require.NoError(t, err)
+	err = s.Write(ctx, models.ClientData{Cookie: "cookie2", Short: []models.ShortData{{Short: "abcdABC5", Long: "http://example4.org"}}})
+	require.ErrorIs(t, err, ErrConflict)
+	require.NotErrorIs(t, err, ErrTagTaken)
+	err = s.Write(ctx, models.ClientData{Cookie: "cookie1", Short: []models.ShortData{{Short: "abcdABC4", Long: "http://example9.org"}}})
+	require.ErrorIs(t, err, ErrTagTaken)
+	exp := models.ClientData{
+		Cookie: "cookie2",
+		Key:    "secret_key2",
+		Short: []models.ShortData{
+			{
+				Short: "abcdABC2",
+				Long:  "http://example2.org",
+			},
+			{
+				Short: "abcdABC4",
+				Long:  "http://example4.org",
+			},
+		},
+	}
+	data, err := s.ReadByCookie(ctx, "cookie2")
+	require.NoError(t, err)
+	require.Equal(t, exp, data)
+}
+
+func Test_Bolt_ReadByTag(t *testing.T) {
+	s := newTestBolt(t)
+	exp := models.ShortData{
+		Short: "abcdABC2",
+		Long:  "http://example2.org",
+	}
+	data, err := s.ReadByTag(context.Background(), "abcdABC2")
+	require.NoError(t, err)
+	require.Equal(t, exp, data)
+	_, err = s.ReadByTag(context.Background(), "notexist")
+	require.ErrorIs(t, err, ErrNotFound)
+}
+
+func Test_Bolt_TagByURL(t *testing.T) {
+	s := newTestBolt(t)
+	data, err := s.TagByURL(context.Background(), "http://example2.org", "cookie2")
+	require.NoError(t, err)
+	require.Equal(t, "abcdABC2", data)
+	_, err = s.TagByURL(context.Background(), "http://example2.org", "cookie1")
+	require.ErrorIs(t, err, ErrNotFound)
+}
+
+func Test_Bolt_Delete(t *testing.T) {
+	s := newTestBolt(t)
+	ctx := context.Background()
+	s.deleteTag(models.DelWorker{Cookie: "cookie1", Tags: []string{"abcdABC2"}})
+	_, err := s.ReadByTag(ctx, "abcdABC2")
+	require.NoError(t, err)
+	s.deleteTag(models.DelWorker{Cookie: "cookie2", Tags: []string{"abcdABC2"}})
+	data, err := s.ReadByTag(ctx, "abcdABC2")
+	require.ErrorIs(t, err, ErrDeleted)
+	require.True(t, data.Deleted)
+	_, err = s.TagByURL(ctx, "http://example2.org", "cookie2")
+	require.ErrorIs(t, err, ErrNotFound)
+	err = s.Write(ctx, models.ClientData{Cookie: "cookie2", Short: []models.ShortData{{Short: "abcdABC5", Long: "http://example2.org"}}})
+	require.NoError(t, err)
+}
+
+func Test_Bolt_Expired(t *testing.T) {
+	s := newTestBolt(t)
+	checkExpired(t, s)
+}
+
+func Test_Bolt_Counts(t *testing.T) {
+	s := newTestBolt(t)
+	checkCounts(t, s)
+}
+
+func Test_Bolt_WriteBatch(t *testing.T) {
+	s := newTestBolt(t)
+	checkWriteBatch(t, s)
+}
@@ -0,0 +1,154 @@
+package storage
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/t1mon-ggg/go_shortner/app/models"
+)
+
+//click recorder defaults
+const (
+	clickBuffer   = 4096        //clickBuffer - размер очереди переходов
+	clickBatch    = 100         //clickBatch - количество переходов, записываемых за раз
+	clickInterval = time.Second //clickInterval - период записи неполной пачки переходов
+)
+
+//ClickRecorder - асинхронная буферизованная запись переходов по коротким ссылкам
+type ClickRecorder struct {
+	sink    Storage           //хранилище для записи переходов
+	timeout time.Duration     //ограничение времени записи пачки переходов
+	input   chan models.Click //очередь переходов
+	done    chan struct{}     //сигнал завершения записи
+	once    *sync.Once        //защита от повторного закрытия очереди
+	mu      *sync.RWMutex     //защита очереди от записи во время закрытия
+	closed  bool              //очередь закрыта, переходы не принимаются
+}
+
+//NewClickRecorder - создание и запуск асинхронной записи переходов в хранилище, timeout ограничивает запись пачки переходов
+func NewClickRecorder(sink Storage, timeout time.Duration) *ClickRecorder {
+	r := ClickRecorder{}
+	r.sink = sink
+	r.timeout = timeout
+	r.input = make(chan models.Click, clickBuffer)
+	r.done = make(chan struct{})
+	r.once = &sync.Once{}
+	r.mu = &sync.RWMutex{}
+	go r.run()
+	return &r
+}
+
+//Record - постановка перехода в очередь без ожидания, при переполнении или закрытии очереди переход отбрасывается
+func (r *ClickRecorder) Record(click models.Click) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.closed {
+		log.Println("Click queue is closed, click dropped for tag", click.Tag)
+		return
+	}
+	select {
+	case r.input <- click:
+	default:
+		log.Println("Click queue is full, click dropped for tag", click.Tag)
+	}
+}
+
+//Close - остановка приема переходов и запись оставшихся в очереди
+func (r *ClickRecorder) Close() {
+	r.once.Do(func() {
+		r.mu.Lock()
+		r.closed = true
+		close(r.input)
+		r.mu.Unlock()
+	})
+	<-r.done
+}
+
+//run - чтение очереди и запись переходов пачками
+func (r *ClickRecorder) run() {
+	defer close(r.done)
+	ticker := time.NewTicker(clickInterval)
+	defer ticker.Stop()
+	batch := make([]models.Click, 0, clickBatch)
+	for {
+		select {
+		case click, ok := <-r.input:
+			if !ok {
+				r.flush(batch)
+				return
+			}
+			batch = append(batch, click)
+			if len(batch) >= clickBatch {
+				r.flush(batch)
+				batch = make([]models.Click, 0, clickBatch)
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				r.flush(batch)
+				batch = make([]models.Click, 0, clickBatch)
+			}
+		}
+	}
+}
+
+//flush - запись пачки переходов в хранилище
+func (r *ClickRecorder) flush(batch []models.Click) {
+	if len(batch) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+	err := r.sink.WriteClicks(ctx, batch)
+	if err != nil {
+		log.Println("Clicks write failed:", err)
+	}
+}
+
+//clickStats - расчет статистики переходов в памяти
+func clickStats(tag string, clicks []models.Click, top int) models.Stats {
+	stats := models.Stats{Tag: tag, Clicks: len(clicks)}
+	visitors := make(map[string]struct{})
+	daily := make(map[string]int)
+	referrers := make(map[string]int)
+	countries := make(map[string]int)
+	for _, click := range clicks {
+		visitors[click.IPHash] = struct{}{}
+		daily[click.Time.UTC().Format("2006-01-02")]++
+		if click.Referrer != "" {
+			referrers[click.Referrer]++
+		}
+		if click.Country != "" {
+			countries[click.Country]++
+		}
+	}
+	stats.Unique = len(visitors)
+	stats.Daily = make([]models.DailyClicks, 0, len(daily))
+	for date, count := range daily {
+		stats.Daily = append(stats.Daily, models.DailyClicks{Date: date, Clicks: count})
+	}
+	sort.Slice(stats.Daily, func(i, j int) bool { return stats.Daily[i].Date < stats.Daily[j].Date })
+	stats.Referrers = topItems(referrers, top)
+	stats.Countries = topItems(countries, top)
+	return stats
+}
+
+//topItems - n самых частых значений, при равенстве по алфавиту
+func topItems(counts map[string]int, n int) []models.TopItem {
+	result := make([]models.TopItem, 0, len(counts))
+	for name, count := range counts {
+		result = append(result, models.TopItem{Name: name, Clicks: count})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Clicks != result[j].Clicks {
+			return result[i].Clicks > result[j].Clicks
+		}
+		return result[i].Name < result[j].Name
+	})
+	if len(result) > n {
+		result = result[:n]
+	}
+	return result
+}
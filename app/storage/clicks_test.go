@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/t1mon-ggg/go_shortner/app/models"
+)
+
+func Test_ClickRecorder(t *testing.T) {
+	db := NewRAM()
+	r := NewClickRecorder(db, DefaultTimeouts.Long)
+	for i := 0; i < 250; i++ {
+		r.Record(models.Click{Tag: fmt.Sprintf("tag%d", i%3), Time: time.Now().UTC(), Referrer: "http://referrer.org", UserAgent: "test", IPHash: "hash"})
+	}
+	r.Close()
+	r.Close()
+	require.NotPanics(t, func() {
+		r.Record(models.Click{Tag: "tag0", Time: time.Now().UTC(), IPHash: "hash"})
+	})
+	require.Len(t, db.dumpClicks(), 250)
+	require.Len(t, db.clicks["tag0"], 84)
+}
+
+//slowClicks - хранилище, запись переходов в котором завершается только по истечении ctx
+type slowClicks struct {
+	Storage
+}
+
+func (s slowClicks) WriteClicks(ctx context.Context, clicks []models.Click) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func Test_ClickRecorder_Timeout(t *testing.T) {
+	r := NewClickRecorder(slowClicks{Storage: NewRAM()}, 10*time.Millisecond)
+	r.Record(models.Click{Tag: "tag0", Time: time.Now().UTC(), IPHash: "hash"})
+	start := time.Now()
+	r.Close()
+	require.Less(t, time.Since(start), time.Second)
+}
+
+func Test_FileDB_Clicks(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "journal.txt")
+	ctx := context.Background()
+	clicks := []models.Click{
+		{Tag: "abcdABC1", Time: time.Date(2022, 1, 1, 10, 0, 0, 0, time.UTC), Referrer: "http://referrer.org", UserAgent: "test", IPHash: "hash1"},
+		{Tag: "abcdABC1", Time: time.Date(2022, 1, 2, 10, 0, 0, 0, time.UTC), UserAgent: "test", IPHash: "hash2"},
+	}
+	f := NewFile(name)
+	f.testPrepare(t)
+	require.NoError(t, f.WriteClicks(ctx, clicks))
+	require.NoError(t, f.Close())
+
+	f = NewFile(name)
+	index, err := f.load()
+	require.NoError(t, err)
+	require.Equal(t, clicks, index.dumpClicks())
+	require.NoError(t, f.compactFile())
+	require.NoError(t, f.Close())
+
+	f = NewFile(name)
+	defer f.Close()
+	index, err = f.load()
+	require.NoError(t, err)
+	require.Equal(t, clicks, index.dumpClicks())
+}
+
+func Test_SQLite_Clicks(t *testing.T) {
+	s := newTestSQLite(t)
+	ctx := context.Background()
+	clicks := []models.Click{
+		{Tag: "abcdABC1", Time: time.Now(), Referrer: "http://referrer.org", UserAgent: "test", IPHash: "hash1"},
+		{Tag: "abcdABC2", Time: time.Now(), UserAgent: "test", IPHash: "hash2"},
+	}
+	require.NoError(t, s.WriteClicks(ctx, clicks))
+	var count int
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM "clicks" WHERE "short"=$1`, "abcdABC1").Scan(&count)
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+}
+
+//checkStats - проверка статистики переходов для любого хранилища
+func checkStats(t *testing.T, s Storage) {
+	ctx := context.Background()
+	day1 := time.Date(2022, 1, 1, 10, 0, 0, 0, time.UTC)
+	day2 := time.Date(2022, 1, 2, 23, 59, 0, 0, time.UTC)
+	clicks := []models.Click{
+		{Tag: "statsTag", Time: day1, Referrer: "http://a.org", IPHash: "hash1", Country: "RU"},
+		{Tag: "statsTag", Time: day1, Referrer: "http://b.org", IPHash: "hash1", Country: "RU"},
+		{Tag: "statsTag", Time: day2, Referrer: "http://b.org", IPHash: "hash2", Country: "US"},
+		{Tag: "statsTag", Time: day2, IPHash: "hash3"},
+		{Tag: "otherTag", Time: day2, Referrer: "http://c.org", IPHash: "hash4", Country: "DE"},
+	}
+	require.NoError(t, s.WriteClicks(ctx, clicks))
+	exp := models.Stats{
+		Tag:       "statsTag",
+		Clicks:    4,
+		Unique:    3,
+		Daily:     []models.DailyClicks{{Date: "2022-01-01", Clicks: 2}, {Date: "2022-01-02", Clicks: 2}},
+		Referrers: []models.TopItem{{Name: "http://b.org", Clicks: 2}},
+		Countries: []models.TopItem{{Name: "RU", Clicks: 2}},
+	}
+	stats, err := s.Stats(ctx, "statsTag", 1)
+	require.NoError(t, err)
+	require.Equal(t, exp, stats)
+	stats, err = s.Stats(ctx, "notexist", 10)
+	require.NoError(t, err)
+	require.Equal(t, models.Stats{Tag: "notexist", Daily: []models.DailyClicks{}, Referrers: []models.TopItem{}, Countries: []models.TopItem{}}, stats)
+}
+
+func Test_MEM_Stats(t *testing.T) {
+	checkStats(t, NewRAM())
+}
+
+func Test_FileDB_Stats(t *testing.T) {
+	f := NewFile(filepath.Join(t.TempDir(), "journal.txt"))
+	defer f.Close()
+	checkStats(t, f)
+}
+
+func Test_Bolt_Stats(t *testing.T) {
+	checkStats(t, newTestBolt(t))
+}
+
+func Test_SQLite_Stats(t *testing.T) {
+	checkStats(t, newTestSQLite(t))
+}
@@ -1,286 +1,624 @@
-package storage
-
-import (
-	"context"
-	"database/sql"
-	"errors"
-	"fmt"
-	"log"
-	"time"
-
-	_ "github.com/lib/pq"
-
-	"github.com/t1mon-ggg/go_shortner/app/helpers"
-	"github.com/t1mon-ggg/go_shortner/app/models"
-)
-
-//SQL queries
-const (
-	schemaSQL = `
-	CREATE TABLE IF NOT EXISTS "ids" (
-		"cookie" VARCHAR(32) NOT NULL UNIQUE PRIMARY KEY,
-		"key" VARCHAR(64) NOT NULL
-	);
-	
-	CREATE TABLE IF NOT EXISTS "urls" (
-	  "id" int4 NOT NULL PRIMARY KEY UNIQUE GENERATED ALWAYS AS IDENTITY (
-	INCREMENT 1
-	MINVALUE  1
-	MAXVALUE 2147483647
-	START 1
-	CACHE 1
-	),	
-	  "short" varchar(8) NOT NULL UNIQUE,
-	  "long" varchar(255) NOT NULL,
-	  "cookie" varchar(32) NOT NULL,
-	  "deleted" bool NOT NULL DEFAULT false,
-	  CONSTRAINT "cookie" FOREIGN KEY ("cookie") REFERENCES "ids" ("cookie") ON DELETE NO ACTION ON UPDATE NO ACTION
-	);
-	CREATE UNIQUE INDEX IF NOT EXISTS urls_long_idx ON "urls" ("long" text_ops,"cookie" text_ops) WHERE "deleted"=false;
-`
-	cookieSelectIDs  = `SELECT "cookie", "key" FROM "ids" WHERE "cookie"='%s'`
-	cookieSelectURLs = `SELECT "short", "long", "deleted" FROM "urls" WHERE "cookie"='%s'`
-	cookieSearch     = `SELECT COUNT("cookie") FROM "ids" WHERE "cookie"='%s'`
-	tagSelect        = `SELECT "short", "long", "deleted" FROM "urls" WHERE "short"='%s'`
-	urlSelect        = `SELECT "short" FROM "urls" WHERE "long"='%s' AND "cookie"='%s'`
-	writeIDs         = `INSERT INTO "ids" ("cookie", "key") VALUES ($1,$2)`
-	writeURLs        = `INSERT INTO "urls" ("cookie", "short", "long") VALUES ($1,$2,$3)`
-	tagDelete        = `UPDATE "urls" SET "deleted"=true WHERE "cookie"=$1 AND "short"=$2`
-)
-
-//Postgres - struct for postgres implementation
-type postgres struct {
-	conn string  //строка подключения к базе данных
-	db   *sql.DB //дескриптор для работы с базой
-}
-
-//NewPostgreSQL - создание ссылки на структуру для работы с базой данных
-func NewPostgreSQL(s string) (*postgres, error) {
-	log.Println("DSN string:", s)
-	db := postgres{conn: s}
-	err := db.open()
-	if err != nil {
-		return nil, err
-	}
-	log.Println("Successfull connection to PostgreSQL")
-	err = db.create()
-	if err != nil {
-		return nil, err
-	}
-	return &db, nil
-}
-
-//open - подключение к базу данных, создание схемы БД
-func (s *postgres) open() error {
-	var err error
-	s.db, err = sql.Open("postgres", s.conn)
-	if err != nil {
-		return err
-	}
-	err = s.create()
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
-//create - создание схемы БД
-func (s *postgres) create() error {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
-	_, err := s.db.ExecContext(ctx, schemaSQL)
-	if err != nil {
-		log.Println("?????!")
-		return err
-	}
-	return nil
-}
-
-//Ping - проверка состояния соединения с базой данных
-func (s *postgres) Ping() error {
-	log.Println("Check connection to PostgreSQL")
-	ctx := context.Background()
-	connection, cancel := context.WithTimeout(ctx, 10*time.Second)
-	defer cancel()
-	err := s.db.PingContext(connection)
-	if err != nil {
-		log.Println("Connection to PostgreSQL failed")
-		return err
-	}
-	log.Println("Connection to PostgreSQL confirmed")
-	return nil
-}
-
-//Close - закрытие дексриптора базы данных
-func (s *postgres) Close() error {
-	err := s.db.Close()
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
-//ReadByCookie - чтение из базы данных
-func (s *postgres) ReadByCookie(cookie string) (models.ClientData, error) {
-	a := models.ClientData{}
-	log.Println("Select from IDs")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	query := fmt.Sprintf(cookieSelectIDs, cookie)
-	log.Printf("Executing \"%s\"\n", query)
-	var rowCookie, rowKey string
-	err := s.db.QueryRowContext(ctx, query).Scan(&rowCookie, &rowKey)
-	if err != nil {
-		return models.ClientData{}, err
-	}
-	a.Cookie = rowCookie
-	a.Key = rowKey
-	a.Short = make([]models.ShortData, 0)
-	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	query = fmt.Sprintf(cookieSelectURLs, cookie)
-	log.Printf("Executing \"%s\"\n", query)
-	rows, err := s.db.QueryContext(ctx, query)
-	if err != nil {
-		return a, err
-	}
-	if rows.Err() != nil {
-		return a, err
-	}
-	for rows.Next() {
-		var short, long string
-		var deleted bool
-		err := rows.Scan(&short, &long, &deleted)
-		if err != nil {
-			return a, err
-		}
-		a.Short = append(a.Short, models.ShortData{Short: short, Long: long, Deleted: deleted})
-	}
-	return a, nil
-}
-
-//ReadByURL - чтение из базы данных
-func (s *postgres) TagByURL(url, cookie string) (string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	query := fmt.Sprintf(urlSelect, url, cookie)
-	var short string
-	err := s.db.QueryRowContext(ctx, query).Scan(&short)
-	if err != nil {
-		log.Println("!!!!!!!!!!!!!!!!", err)
-		return "", err
-	}
-	return short, nil
-}
-
-//ReadByTag - чтение из базы данных
-func (s *postgres) ReadByTag(tag string) (models.ShortData, error) {
-	m := models.ShortData{}
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	query := fmt.Sprintf(tagSelect, tag)
-	log.Printf("Executing \"%s\"\n", query)
-	var short, long string
-	var deleted bool
-	err := s.db.QueryRowContext(ctx, query).Scan(&short, &long, &deleted)
-	if err != nil {
-		if helpers.NoRowsError(err) {
-			return models.ShortData{}, nil
-		}
-		return m, err
-	}
-	m.Short = short
-	m.Long = long
-	m.Deleted = deleted
-	return m, nil
-}
-
-//Write - запись в базы данных
-func (s *postgres) Write(data models.ClientData) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
-	defer cancel()
-	var count int
-	query := fmt.Sprintf(cookieSearch, data.Cookie)
-	err := s.db.QueryRowContext(ctx, query).Scan(&count)
-	if err != nil {
-		return err
-	}
-	tx, err := s.db.Begin()
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback()
-	if count == 0 {
-		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
-		defer cancel()
-		stmt1, err := tx.PrepareContext(ctx, writeIDs)
-		if err != nil {
-			return err
-		}
-		defer stmt1.Close()
-		_, err = stmt1.ExecContext(ctx, data.Cookie, data.Key)
-		if err != nil {
-			return err
-		}
-	}
-	ctx, cancel = context.WithTimeout(context.Background(), 1*time.Second)
-	defer cancel()
-	stmt2, err := tx.PrepareContext(ctx, writeURLs)
-	if err != nil {
-		return err
-	}
-	defer stmt2.Close()
-	for _, value := range data.Short {
-		_, err = stmt2.ExecContext(ctx, data.Cookie, value.Short, value.Long)
-		if err != nil {
-			if helpers.UniqueViolationError(err) {
-				newerr := errors.New("not unique url")
-				return newerr
-			}
-			return err
-		}
-	}
-	tx.Commit()
-	return nil
-}
-
-//Cleaner - delete task worker creator
-func (s *postgres) Cleaner(inputCh <-chan models.DelWorker, workers int) {
-	fanOutChs := helpers.FanOut(inputCh, workers)
-	for _, fanOutCh := range fanOutChs {
-		go s.newWorker(fanOutCh)
-	}
-}
-
-//deleteTag - mark tag as deleted
-func (s *postgres) deleteTag(task models.DelWorker) {
-	tx, err := s.db.Begin()
-	if err != nil {
-		log.Println("Error while create transaction:", err)
-		return
-	}
-	defer tx.Rollback()
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	stmt, err := tx.PrepareContext(ctx, tagDelete)
-	if err != nil {
-		log.Println("Error while create statement:", err)
-		return
-	}
-	defer stmt.Close()
-	for _, tag := range task.Tags {
-		_, err = stmt.ExecContext(ctx, task.Cookie, tag)
-		if err != nil {
-			log.Println("Error while execute query:", err)
-			return
-		}
-	}
-	tx.Commit()
-}
-
-//newWorker - delete task worker
-func (s *postgres) newWorker(input <-chan models.DelWorker) {
-	for task := range input {
-		s.deleteTag(task)
-	}
-}
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	_ "github.com/lib/pq"
+
+	"github.com/t1mon-ggg/go_shortner/app/helpers"
+	"github.com/t1mon-ggg/go_shortner/app/models"
+)
+
+//SQL queries
+const (
+	cookieSelectIDs  = `SELECT "cookie", "key" FROM "ids" WHERE "cookie"=$1`
+	cookieSelectURLs = `SELECT "short", "long", "deleted", "expires_at" FROM "urls" WHERE "cookie"=$1`
+	cookieSearch     = `SELECT COUNT("cookie") FROM "ids" WHERE "cookie"=$1`
+	tagSelect        = `SELECT "short", "long", "deleted", "expires_at" FROM "urls" WHERE "short"=$1`
+	tagSearch        = `SELECT COUNT("short") FROM "urls" WHERE "short"=$1`
+	urlSelect        = `SELECT "short" FROM "urls" WHERE "long"=$1 AND "cookie"=$2 AND "deleted"=false AND ("expires_at" IS NULL OR "expires_at">$3)`
+	writeIDs         = `INSERT INTO "ids" ("cookie", "key") VALUES ($1,$2)`
+	writeURLs        = `INSERT INTO "urls" ("cookie", "short", "long", "deleted", "expires_at") VALUES ($1,$2,$3,$4,$5)`
+	tagDelete        = `UPDATE "urls" SET "deleted"=true WHERE "cookie"=$1 AND "short"=$2`
+	exportSelect     = `SELECT "ids"."cookie", "ids"."key", "urls"."short", "urls"."long", "urls"."deleted", "urls"."expires_at" FROM "ids" LEFT JOIN "urls" ON "urls"."cookie"="ids"."cookie" ORDER BY "ids"."cookie", "urls"."id"`
+	expiredRelease   = `UPDATE "urls" SET "deleted"=true WHERE "long"=$1 AND "cookie"=$2 AND "deleted"=false AND "expires_at" IS NOT NULL AND "expires_at"<=$3`
+	expiredSelect    = `SELECT "cookie", "short" FROM "urls" WHERE "deleted"=false AND "expires_at" IS NOT NULL AND "expires_at"<=$1 ORDER BY "cookie"`
+	writeClick       = `INSERT INTO "clicks" ("short", "clicked_at", "referrer", "user_agent", "ip_hash", "country") VALUES ($1,$2,$3,$4,$5,$6)`
+	countURLs        = `SELECT COUNT(*) FROM "urls" WHERE "deleted"=false`
+	countUsers       = `SELECT COUNT(*) FROM "ids"`
+	statsTotal       = `SELECT COUNT(*), COUNT(DISTINCT "ip_hash") FROM "clicks" WHERE "short"=$1`
+	statsReferrers   = `SELECT "referrer", COUNT(*) AS "total" FROM "clicks" WHERE "short"=$1 AND "referrer"<>'' GROUP BY "referrer" ORDER BY "total" DESC, "referrer" LIMIT $2`
+	statsCountries   = `SELECT "country", COUNT(*) AS "total" FROM "clicks" WHERE "short"=$1 AND "country"<>'' GROUP BY "country" ORDER BY "total" DESC, "country" LIMIT $2`
+)
+
+//SQL queries which differ between dialects
+const (
+	statsDailyPostgres = `SELECT to_char("clicked_at" AT TIME ZONE 'UTC', 'YYYY-MM-DD') AS "day", COUNT(*) FROM "clicks" WHERE "short"=$1 GROUP BY "day" ORDER BY "day"`
+	statsDailySQLite   = `SELECT substr("clicked_at", 1, 10) AS "day", COUNT(*) FROM "clicks" WHERE "short"=$1 GROUP BY "day" ORDER BY "day"`
+)
+
+//Timeouts - ограничения времени выполнения запросов к базе данных
+type Timeouts struct {
+	Write   time.Duration //Write - запись данных пользователя
+	Query   time.Duration //Query - чтение и удаление ссылок, подсчет записей
+	Long    time.Duration //Long - проверка соединения, поиск просроченных ссылок, пакетная запись ссылок, запись и статистика переходов
+	Migrate time.Duration //Migrate - применение миграций и подготовка запросов
+}
+
+//DefaultTimeouts - ограничения времени выполнения запросов по умолчанию
+var DefaultTimeouts = Timeouts{
+	Write:   1 * time.Second,
+	Query:   5 * time.Second,
+	Long:    10 * time.Second,
+	Migrate: 30 * time.Second,
+}
+
+//Postgres - struct for postgres implementation
+type postgres struct {
+	conn       string   //строка подключения к базе данных
+	driver     string   //имя драйвера database/sql
+	migrations string   //каталог миграций для используемого диалекта SQL
+	daily      string   //запрос дневной статистики переходов для используемого диалекта SQL
+	timeouts   Timeouts //ограничения времени выполнения запросов
+	db         *sql.DB  //дескриптор для работы с базой
+	stmts      statements
+}
+
+//statements - подготовленные запросы к базе данных
+type statements struct {
+	cookieSelectIDs  *sql.Stmt
+	cookieSelectURLs *sql.Stmt
+	cookieSearch     *sql.Stmt
+	tagSelect        *sql.Stmt
+	tagSearch        *sql.Stmt
+	urlSelect        *sql.Stmt
+	writeIDs         *sql.Stmt
+	writeURLs        *sql.Stmt
+	tagDelete        *sql.Stmt
+	expiredRelease   *sql.Stmt
+	expiredSelect    *sql.Stmt
+	writeClick       *sql.Stmt
+	statsTotal       *sql.Stmt
+	statsDaily       *sql.Stmt
+	statsReferrers   *sql.Stmt
+	statsCountries   *sql.Stmt
+	countURLs        *sql.Stmt
+	countUsers       *sql.Stmt
+}
+
+//NewPostgreSQL - создание ссылки на структуру для работы с базой данных, применение миграций
+func NewPostgreSQL(s string, timeouts Timeouts) (*postgres, error) {
+	db, err := OpenPostgreSQL(s)
+	if err != nil {
+		return nil, err
+	}
+	db.timeouts = timeouts
+	err = db.create()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+//OpenPostgreSQL - подключение к базе данных без применения миграций
+func OpenPostgreSQL(s string) (*postgres, error) {
+	db := postgres{conn: s, driver: "postgres", migrations: "migrations/postgres", daily: statsDailyPostgres, timeouts: DefaultTimeouts}
+	err := db.open()
+	if err != nil {
+		return nil, err
+	}
+	log.Println("Successfull connection to PostgreSQL")
+	return &db, nil
+}
+
+//open - подключение к базе данных
+func (s *postgres) open() error {
+	var err error
+	s.db, err = sql.Open(s.driver, s.conn)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+//create - применение миграций схемы БД и подготовка запросов
+func (s *postgres) create() error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeouts.Migrate)
+	defer cancel()
+	err := s.Migrate(ctx)
+	if err != nil {
+		log.Println("Schema migration failed:", err)
+		return err
+	}
+	return s.prepare()
+}
+
+//prepare - подготовка запросов к базе данных
+func (s *postgres) prepare() error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeouts.Migrate)
+	defer cancel()
+	queries := []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		{&s.stmts.cookieSelectIDs, cookieSelectIDs},
+		{&s.stmts.cookieSelectURLs, cookieSelectURLs},
+		{&s.stmts.cookieSearch, cookieSearch},
+		{&s.stmts.tagSelect, tagSelect},
+		{&s.stmts.tagSearch, tagSearch},
+		{&s.stmts.urlSelect, urlSelect},
+		{&s.stmts.writeIDs, writeIDs},
+		{&s.stmts.writeURLs, writeURLs},
+		{&s.stmts.tagDelete, tagDelete},
+		{&s.stmts.expiredRelease, expiredRelease},
+		{&s.stmts.expiredSelect, expiredSelect},
+		{&s.stmts.writeClick, writeClick},
+		{&s.stmts.statsTotal, statsTotal},
+		{&s.stmts.statsDaily, s.daily},
+		{&s.stmts.statsReferrers, statsReferrers},
+		{&s.stmts.statsCountries, statsCountries},
+		{&s.stmts.countURLs, countURLs},
+		{&s.stmts.countUsers, countUsers},
+	}
+	for _, q := range queries {
+		stmt, err := s.db.PrepareContext(ctx, q.query)
+		if err != nil {
+			return err
+		}
+		*q.stmt = stmt
+	}
+	return nil
+}
+
+//Ping - проверка состояния соединения с базой данных
+func (s *postgres) Ping(ctx context.Context) error {
+	log.Println("Check connection to PostgreSQL")
+	connection, cancel := context.WithTimeout(ctx, s.timeouts.Long)
+	defer cancel()
+	err := s.db.PingContext(connection)
+	if err != nil {
+		log.Println("Connection to PostgreSQL failed")
+		return err
+	}
+	log.Println("Connection to PostgreSQL confirmed")
+	return nil
+}
+
+//Close - закрытие дексриптора базы данных
+func (s *postgres) Close() error {
+	for _, stmt := range []*sql.Stmt{s.stmts.cookieSelectIDs, s.stmts.cookieSelectURLs, s.stmts.cookieSearch, s.stmts.tagSelect, s.stmts.tagSearch, s.stmts.urlSelect, s.stmts.writeIDs, s.stmts.writeURLs, s.stmts.tagDelete, s.stmts.expiredRelease, s.stmts.expiredSelect, s.stmts.writeClick, s.stmts.statsTotal, s.stmts.statsDaily, s.stmts.statsReferrers, s.stmts.statsCountries, s.stmts.countURLs, s.stmts.countUsers} {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
+	err := s.db.Close()
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+//ReadByCookie - чтение из базы данных
+func (s *postgres) ReadByCookie(ctx context.Context, cookie string) (models.ClientData, error) {
+	a := models.ClientData{}
+	log.Println("Select from IDs")
+	idsCtx, cancel := context.WithTimeout(ctx, s.timeouts.Query)
+	defer cancel()
+	log.Printf("Executing \"%s\"\n", cookieSelectIDs)
+	var rowCookie, rowKey string
+	err := s.stmts.cookieSelectIDs.QueryRowContext(idsCtx, cookie).Scan(&rowCookie, &rowKey)
+	if err != nil {
+		if helpers.NoRowsError(err) {
+			return models.ClientData{}, ErrNotFound
+		}
+		return models.ClientData{}, err
+	}
+	a.Cookie = rowCookie
+	a.Key = rowKey
+	a.Short = make([]models.ShortData, 0)
+	urlsCtx, cancel := context.WithTimeout(ctx, s.timeouts.Query)
+	defer cancel()
+	log.Printf("Executing \"%s\"\n", cookieSelectURLs)
+	rows, err := s.stmts.cookieSelectURLs.QueryContext(urlsCtx, cookie)
+	if err != nil {
+		return a, err
+	}
+	defer rows.Close()
+	if rows.Err() != nil {
+		return a, rows.Err()
+	}
+	for rows.Next() {
+		var short, long string
+		var deleted bool
+		var expires sql.NullTime
+		err := rows.Scan(&short, &long, &deleted, &expires)
+		if err != nil {
+			return a, err
+		}
+		a.Short = append(a.Short, models.ShortData{Short: short, Long: long, Deleted: deleted, Expires: fromNullTime(expires)})
+	}
+	return a, nil
+}
+
+//ReadByURL - чтение из базы данных
+func (s *postgres) TagByURL(ctx context.Context, url, cookie string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeouts.Query)
+	defer cancel()
+	var short string
+	err := s.stmts.urlSelect.QueryRowContext(ctx, url, cookie, time.Now().UTC()).Scan(&short)
+	if err != nil {
+		if helpers.NoRowsError(err) {
+			return "", ErrNotFound
+		}
+		return "", err
+	}
+	return short, nil
+}
+
+//ReadByTag - чтение из базы данных
+func (s *postgres) ReadByTag(ctx context.Context, tag string) (models.ShortData, error) {
+	m := models.ShortData{}
+	ctx, cancel := context.WithTimeout(ctx, s.timeouts.Query)
+	defer cancel()
+	log.Printf("Executing \"%s\"\n", tagSelect)
+	var short, long string
+	var deleted bool
+	var expires sql.NullTime
+	err := s.stmts.tagSelect.QueryRowContext(ctx, tag).Scan(&short, &long, &deleted, &expires)
+	if err != nil {
+		if helpers.NoRowsError(err) {
+			return models.ShortData{}, ErrNotFound
+		}
+		return m, err
+	}
+	m.Short = short
+	m.Long = long
+	m.Deleted = deleted
+	m.Expires = fromNullTime(expires)
+	if m.Deleted {
+		return m, ErrDeleted
+	}
+	return m, nil
+}
+
+//Write - запись в базы данных
+func (s *postgres) Write(ctx context.Context, data models.ClientData) error {
+	searchCtx, cancel := context.WithTimeout(ctx, s.timeouts.Write)
+	defer cancel()
+	var count int
+	err := s.stmts.cookieSearch.QueryRowContext(searchCtx, data.Cookie).Scan(&count)
+	if err != nil {
+		return err
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if count == 0 {
+		idsCtx, cancel := context.WithTimeout(ctx, s.timeouts.Write)
+		defer cancel()
+		stmt1 := tx.StmtContext(idsCtx, s.stmts.writeIDs)
+		defer stmt1.Close()
+		_, err = stmt1.ExecContext(idsCtx, data.Cookie, data.Key)
+		if err != nil {
+			return err
+		}
+	}
+	urlsCtx, cancel := context.WithTimeout(ctx, s.timeouts.Write)
+	defer cancel()
+	stmt2 := tx.StmtContext(urlsCtx, s.stmts.writeURLs)
+	defer stmt2.Close()
+	stmt3 := tx.StmtContext(urlsCtx, s.stmts.expiredRelease)
+	defer stmt3.Close()
+	now := time.Now().UTC()
+	for _, value := range data.Short {
+		//ссылка с истекшим сроком действия не мешает повторному сокращению URL
+		_, err = stmt3.ExecContext(urlsCtx, value.Long, data.Cookie, now)
+		if err != nil {
+			return err
+		}
+		_, err = stmt2.ExecContext(urlsCtx, data.Cookie, value.Short, value.Long, value.Deleted, nullTime(value.Expires))
+		if err != nil {
+			if helpers.UniqueViolationError(err) {
+				tx.Rollback()
+				return s.conflict(ctx, data.Cookie, value)
+			}
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+//WriteBatch - запись ссылок пользователя в одной транзакции, ранее сокращенные URL не записываются
+func (s *postgres) WriteBatch(ctx context.Context, data models.ClientData) ([]WriteResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeouts.Long)
+	defer cancel()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	var count int
+	err = tx.StmtContext(ctx, s.stmts.cookieSearch).QueryRowContext(ctx, data.Cookie).Scan(&count)
+	if err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		_, err = tx.StmtContext(ctx, s.stmts.writeIDs).ExecContext(ctx, data.Cookie, data.Key)
+		if err != nil {
+			return nil, err
+		}
+	}
+	urlSelect := tx.StmtContext(ctx, s.stmts.urlSelect)
+	defer urlSelect.Close()
+	tagSearch := tx.StmtContext(ctx, s.stmts.tagSearch)
+	defer tagSearch.Close()
+	writeURLs := tx.StmtContext(ctx, s.stmts.writeURLs)
+	defer writeURLs.Close()
+	expiredRelease := tx.StmtContext(ctx, s.stmts.expiredRelease)
+	defer expiredRelease.Close()
+	result := make([]WriteResult, len(data.Short))
+	now := time.Now().UTC()
+	for i, value := range data.Short {
+		if !value.Deleted {
+			var short string
+			err = urlSelect.QueryRowContext(ctx, value.Long, data.Cookie, now).Scan(&short)
+			if err == nil {
+				result[i] = WriteResult{Tag: short, Existed: true}
+				continue
+			}
+			if !helpers.NoRowsError(err) {
+				return nil, err
+			}
+		}
+		err = tagSearch.QueryRowContext(ctx, value.Short).Scan(&count)
+		if err != nil {
+			return nil, err
+		}
+		if count != 0 {
+			return nil, &BatchError{Index: i, Err: ErrTagTaken}
+		}
+		_, err = expiredRelease.ExecContext(ctx, value.Long, data.Cookie, now)
+		if err != nil {
+			return nil, err
+		}
+		_, err = writeURLs.ExecContext(ctx, data.Cookie, value.Short, value.Long, value.Deleted, nullTime(value.Expires))
+		if err != nil {
+			if helpers.UniqueViolationError(err) {
+				//concurrent write of the same url or tag
+				return nil, &BatchError{Index: i, Err: ErrConflict}
+			}
+			return nil, err
+		}
+		result[i] = WriteResult{Tag: value.Short}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+//conflict - причина нарушения уникальности: URL уже сокращен пользователем или тег занят
+func (s *postgres) conflict(ctx context.Context, cookie string, value models.ShortData) error {
+	if !value.Deleted {
+		_, err := s.TagByURL(ctx, value.Long, cookie)
+		if err == nil {
+			return ErrConflict
+		}
+	}
+	return ErrTagTaken
+}
+
+//Cleaner - delete task worker creator, returns after inputCh is closed and all tasks are done
+func (s *postgres) Cleaner(inputCh <-chan models.DelWorker, workers int) {
+	helpers.Workers(helpers.FanOut(inputCh, workers), s.newWorker)
+}
+
+//deleteTag - mark tag as deleted
+func (s *postgres) deleteTag(task models.DelWorker) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		log.Println("Error while create transaction:", err)
+		return
+	}
+	defer tx.Rollback()
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeouts.Query)
+	defer cancel()
+	stmt := tx.StmtContext(ctx, s.stmts.tagDelete)
+	defer stmt.Close()
+	for _, tag := range task.Tags {
+		_, err = stmt.ExecContext(ctx, task.Cookie, tag)
+		if err != nil {
+			log.Println("Error while execute query:", err)
+			return
+		}
+	}
+	tx.Commit()
+}
+
+//newWorker - delete task worker
+func (s *postgres) newWorker(input <-chan models.DelWorker) {
+	for task := range input {
+		s.deleteTag(task)
+	}
+}
+
+//Export - потоковое чтение данных всех пользователей
+func (s *postgres) Export(ctx context.Context, fn func(models.ClientData) error) error {
+	rows, err := s.db.QueryContext(ctx, exportSelect)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	current := models.ClientData{}
+	for rows.Next() {
+		var cookie, key string
+		var short, long sql.NullString
+		var deleted sql.NullBool
+		var expires sql.NullTime
+		err := rows.Scan(&cookie, &key, &short, &long, &deleted, &expires)
+		if err != nil {
+			return err
+		}
+		if cookie != current.Cookie {
+			if current.Cookie != "" {
+				err = fn(current)
+				if err != nil {
+					return err
+				}
+			}
+			current = models.ClientData{Cookie: cookie, Key: key, Short: make([]models.ShortData, 0)}
+		}
+		if short.Valid {
+			current.Short = append(current.Short, models.ShortData{Short: short.String, Long: long.String, Deleted: deleted.Bool, Expires: fromNullTime(expires)})
+		}
+	}
+	if rows.Err() != nil {
+		return rows.Err()
+	}
+	if current.Cookie != "" {
+		return fn(current)
+	}
+	return nil
+}
+
+//Expired - поиск не удаленных ссылок с истекшим сроком действия
+func (s *postgres) Expired(ctx context.Context, now time.Time) ([]models.DelWorker, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeouts.Long)
+	defer cancel()
+	rows, err := s.stmts.expiredSelect.QueryContext(ctx, now.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := make([]models.DelWorker, 0)
+	for rows.Next() {
+		var cookie, short string
+		err := rows.Scan(&cookie, &short)
+		if err != nil {
+			return nil, err
+		}
+		if len(result) == 0 || result[len(result)-1].Cookie != cookie {
+			result = append(result, models.DelWorker{Cookie: cookie, Tags: make([]string, 0)})
+		}
+		result[len(result)-1].Tags = append(result[len(result)-1].Tags, short)
+	}
+	return result, rows.Err()
+}
+
+//WriteClicks - сохранение переходов по коротким ссылкам в одной транзакции
+func (s *postgres) WriteClicks(ctx context.Context, clicks []models.Click) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeouts.Long)
+	defer cancel()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt := tx.StmtContext(ctx, s.stmts.writeClick)
+	defer stmt.Close()
+	for _, click := range clicks {
+		_, err = stmt.ExecContext(ctx, click.Tag, click.Time.UTC(), click.Referrer, click.UserAgent, click.IPHash, click.Country)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+//Stats - статистика переходов по тегу агрегирующими запросами
+func (s *postgres) Stats(ctx context.Context, tag string, top int) (models.Stats, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeouts.Long)
+	defer cancel()
+	stats := models.Stats{Tag: tag}
+	err := s.stmts.statsTotal.QueryRowContext(ctx, tag).Scan(&stats.Clicks, &stats.Unique)
+	if err != nil {
+		return models.Stats{}, err
+	}
+	rows, err := s.stmts.statsDaily.QueryContext(ctx, tag)
+	if err != nil {
+		return models.Stats{}, err
+	}
+	defer rows.Close()
+	stats.Daily = make([]models.DailyClicks, 0)
+	for rows.Next() {
+		day := models.DailyClicks{}
+		err := rows.Scan(&day.Date, &day.Clicks)
+		if err != nil {
+			return models.Stats{}, err
+		}
+		stats.Daily = append(stats.Daily, day)
+	}
+	if rows.Err() != nil {
+		return models.Stats{}, rows.Err()
+	}
+	stats.Referrers, err = topQuery(ctx, s.stmts.statsReferrers, tag, top)
+	if err != nil {
+		return models.Stats{}, err
+	}
+	stats.Countries, err = topQuery(ctx, s.stmts.statsCountries, tag, top)
+	if err != nil {
+		return models.Stats{}, err
+	}
+	return stats, nil
+}
+
+//topQuery - чтение n самых частых значений
+func topQuery(ctx context.Context, stmt *sql.Stmt, tag string, n int) ([]models.TopItem, error) {
+	rows, err := stmt.QueryContext(ctx, tag, n)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := make([]models.TopItem, 0)
+	for rows.Next() {
+		item := models.TopItem{}
+		err := rows.Scan(&item.Name, &item.Clicks)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, item)
+	}
+	return result, rows.Err()
+}
+
+//CountURLs - количество не удаленных коротких ссылок
+func (s *postgres) CountURLs(ctx context.Context) (int, error) {
+	return s.count(ctx, s.stmts.countURLs)
+}
+
+//CountUsers - количество пользователей
+func (s *postgres) CountUsers(ctx context.Context) (int, error) {
+	return s.count(ctx, s.stmts.countUsers)
+}
+
+//count - выполнение запроса количества строк
+func (s *postgres) count(ctx context.Context, stmt *sql.Stmt) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeouts.Query)
+	defer cancel()
+	var count int
+	err := stmt.QueryRowContext(ctx).Scan(&count)
+	return count, err
+}
+
+//nullTime - срок действия ссылки для записи в базу, нулевое время - бессрочная ссылка
+func nullTime(t time.Time) sql.NullTime {
+	if t.IsZero() {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: t.UTC(), Valid: true}
+}
+
+//fromNullTime - срок действия ссылки, прочитанный из базы
+func fromNullTime(t sql.NullTime) time.Time {
+	if !t.Valid {
+		return time.Time{}
+	}
+	return t.Time.UTC()
+}
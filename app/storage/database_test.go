@@ -0,0 +1,34 @@
+package storage
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/t1mon-ggg/go_shortner/app/helpers"
+)
+
+//testDatabaseDSN - переменная окружения со строкой подключения к тестовой базе PostgreSQL
+const testDatabaseDSN = "TEST_DATABASE_DSN"
+
+func newTestPostgreSQL(t *testing.T) *postgres {
+	dsn := os.Getenv(testDatabaseDSN)
+	if dsn == "" {
+		t.Skip(testDatabaseDSN, "is not set")
+	}
+	s, err := NewPostgreSQL(dsn, DefaultTimeouts)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func Test_Postgres_LongURL(t *testing.T) {
+	s := newTestPostgreSQL(t)
+	checkLongURL(t, s, helpers.RandStringRunes(32))
+}
+
+func Test_Postgres_Injection(t *testing.T) {
+	s := newTestPostgreSQL(t)
+	checkInjection(t, s, "_"+helpers.RandStringRunes(8))
+}
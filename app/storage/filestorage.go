@@ -1,248 +1,527 @@
-package storage
-
-import (
-	"bufio"
-	"encoding/json"
-	"log"
-	"os"
-	"sync"
-
-	"github.com/t1mon-ggg/go_shortner/app/helpers"
-	"github.com/t1mon-ggg/go_shortner/app/models"
-)
-
-//FileStorage - структура для работы с фаловым хранилищем данных
-type fileStorage struct {
-	name string      //имя файла
-	file *os.File    //дескриптор для работы с файлом
-	rw   *sync.Mutex //блокировка для защиты от одновременной записи
-}
-
-//NewFile - функция инициализирующая структуру FileStorage
-func NewFile(name string) *fileStorage {
-	s := fileStorage{}
-	s.name = name
-	s.file = nil
-	s.rw = &sync.Mutex{}
-	return &s
-}
-
-//checkFile - функция проверки существования файла и его создания
-func checkFile(filename string) error {
-	var err error
-	var f *os.File
-	_, err = os.Stat(filename)
-	if os.IsNotExist(err) {
-		f, err = os.Create(filename)
-		if err != nil {
-			return err
-		}
-		f.Close()
-	}
-	return nil
-}
-
-//Ping - функция проверки доступности файла для работы
-func (f *fileStorage) Ping() error {
-	log.Println("Check connection to files storage")
-	var err error
-	f.file, err = os.OpenFile(f.name, os.O_RDONLY, 0777)
-	if err != nil {
-		log.Println("File storage failed on opening file for read")
-		return err
-	}
-	err = f.file.Close()
-	if err != nil {
-		log.Println("File storage failed on closing file after read")
-		return err
-	}
-	f.file = nil
-	f.file, err = os.OpenFile(f.name, os.O_WRONLY, 0777)
-	if err != nil {
-		log.Println("File storage failed on opening file for write")
-		return err
-	}
-	err = f.file.Close()
-	if err != nil {
-		log.Println("File storage failed on closing file after write")
-		return err
-	}
-	f.file = nil
-	log.Println("Connection to file storage confirmed")
-	return nil
-}
-
-//readFile - создание файлового дескриптора для чтения из файла
-func (f *fileStorage) readFile() error {
-	err := checkFile(f.name)
-	if err != nil {
-		return err
-	}
-	f.rw.Lock()
-	file, err := os.OpenFile(f.name, os.O_RDONLY, 0777)
-	if err != nil {
-		return err
-	}
-	f.file = file
-	return nil
-}
-
-//rewriteFile - создание файлового дескриптора для перезаписи файла новыми данными
-func (f *fileStorage) rewriteFile() error {
-	err := checkFile(f.name)
-	if err != nil {
-		return err
-	}
-	f.rw.Lock()
-	file, err := os.OpenFile(f.name, os.O_WRONLY|os.O_TRUNC, 0777)
-	if err != nil {
-		return err
-	}
-	f.file = file
-	return nil
-}
-
-//getScanner - создание дескритрора для потокового чтения json из файла
-func (f *fileStorage) getScanner() *bufio.Scanner {
-	return bufio.NewScanner(f.file)
-}
-
-//getCoder - создание дескритрора для потоковой записи json в файл
-func (f *fileStorage) getCoder() *json.Encoder {
-	return json.NewEncoder(f.file)
-}
-
-//Close - закрытие файлового дескриптора после операцияй чтения/записи файла
-func (f *fileStorage) Close() error {
-	return f.file.Close()
-}
-
-//Write - запись в файл
-func (f *fileStorage) Write(m models.ClientData) error {
-	data, err := f.readAllFile()
-	if err != nil {
-		return err
-	}
-	data, err = helpers.Merger(data, m)
-	if err != nil {
-		return err
-	}
-	f.rewriteFile()
-	encoder := f.getCoder()
-	err = encoder.Encode(data)
-	if err != nil {
-		return err
-	}
-	f.Close()
-	f.file = nil
-	f.rw.Unlock()
-	return nil
-}
-
-//ReadByCookie - чтение из файла
-func (f *fileStorage) readAllFile() ([]models.ClientData, error) {
-	f.readFile()
-	scanner := f.getScanner()
-	m := make([]models.ClientData, 0)
-	for scanner.Scan() {
-		err := json.Unmarshal([]byte(scanner.Text()), &m)
-		if err != nil {
-			return nil, err
-		}
-	}
-	f.Close()
-	f.rw.Unlock()
-	f.file = nil
-	return m, nil
-}
-
-//TagByURL - поиск URL
-func (f *fileStorage) TagByURL(s, cookie string) (string, error) {
-	data, err := f.readAllFile()
-	if err != nil {
-		return "", err
-	}
-	for _, value := range data {
-		for _, url := range value.Short {
-			if url.Long == s && value.Cookie == cookie {
-				return url.Short, nil
-			}
-		}
-	}
-	return "", nil
-}
-
-//ReadByCookie - чтение из файла
-func (f *fileStorage) ReadByCookie(s string) (models.ClientData, error) {
-	data, err := f.readAllFile()
-	if err != nil {
-		return models.ClientData{}, err
-	}
-	for _, value := range data {
-		if value.Cookie == s {
-			return value, nil
-		}
-	}
-	f.Close()
-	f.file = nil
-	return models.ClientData{}, nil
-}
-
-//ReadByTag - чтение из файла
-func (f *fileStorage) ReadByTag(s string) (models.ShortData, error) {
-	data, err := f.readAllFile()
-	if err != nil {
-		return models.ShortData{}, err
-	}
-	for _, cvalue := range data {
-		for _, svalue := range cvalue.Short {
-			if svalue.Short == s {
-				return svalue, nil
-			}
-		}
-	}
-	return models.ShortData{}, nil
-}
-
-//deleteTag - mark tag as deleted in file storage
-func (f *fileStorage) deleteTag(task models.DelWorker) {
-	data, err := f.readAllFile()
-	if err != nil {
-		log.Println("Error while reading file")
-		return
-	}
-	for _, tag := range task.Tags {
-		for i, user := range data {
-			for j, url := range user.Short {
-				if user.Cookie == task.Cookie && url.Short == tag {
-					data[i].Short[j].Deleted = true
-				}
-			}
-		}
-	}
-	f.rewriteFile()
-	encoder := f.getCoder()
-	err = encoder.Encode(data)
-	if err != nil {
-		log.Println("Error while rewriting file")
-		return
-	}
-	f.Close()
-	f.file = nil
-	f.rw.Unlock()
-}
-
-//Cleaner - delete task worker creator
-func (f *fileStorage) Cleaner(inputCh <-chan models.DelWorker, workers int) {
-	fanOutChs := helpers.FanOut(inputCh, workers)
-	for _, fanOutCh := range fanOutChs {
-		go f.newWorker(fanOutCh)
-	}
-}
-
-//newWorker - delete task worker
-func (f *fileStorage) newWorker(input <-chan models.DelWorker) {
-	for task := range input {
-		f.deleteTag(task)
-	}
-}
+package storage
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/t1mon-ggg/go_shortner/app/helpers"
+	"github.com/t1mon-ggg/go_shortner/app/models"
+)
+
+//compactThreshold - количество записей в журнале после последнего сжатия, запускающее новое сжатие
+const compactThreshold = 1000
+
+//journal operations
+const (
+	opWrite  = "write"  //opWrite - запись данных пользователя
+	opDelete = "delete" //opDelete - пометка тегов пользователя как удаленных
+	opClicks = "clicks" //opClicks - переходы по коротким ссылкам
+)
+
+//journalEvent - запись журнала файлового хранилища
+type journalEvent struct {
+	Op     string             `json:"op"`               //Op - тип операции
+	Data   *models.ClientData `json:"data,omitempty"`   //Data - данные для операции записи
+	Delete *models.DelWorker  `json:"delete,omitempty"` //Delete - задание для операции удаления
+	Clicks []models.Click     `json:"clicks,omitempty"` //Clicks - переходы для операции учета переходов
+}
+
+//FileStorage - структура для работы с фаловым хранилищем данных
+type fileStorage struct {
+	name    string        //имя файла
+	file    *os.File      //дескриптор журнала, открытого на дозапись
+	rw      *sync.Mutex   //блокировка для защиты от одновременной записи
+	index   *ram          //индекс в памяти, восстановленный из журнала
+	events  int           //количество записей в журнале после последнего сжатия
+	compact chan struct{} //сигнал для фонового сжатия журнала
+	done    chan struct{} //сигнал остановки фонового сжатия
+	closed  bool          //хранилище закрыто, повторная загрузка журнала запрещена
+}
+
+//NewFile - функция инициализирующая структуру FileStorage
+func NewFile(name string) *fileStorage {
+	s := fileStorage{}
+	s.name = name
+	s.file = nil
+	s.rw = &sync.Mutex{}
+	return &s
+}
+
+//checkFile - функция проверки существования файла и его создания
+func checkFile(filename string) error {
+	var err error
+	var f *os.File
+	_, err = os.Stat(filename)
+	if os.IsNotExist(err) {
+		f, err = os.Create(filename)
+		if err != nil {
+			return err
+		}
+		f.Close()
+	}
+	return nil
+}
+
+//Ping - функция проверки доступности файла для работы
+func (f *fileStorage) Ping(ctx context.Context) error {
+	log.Println("Check connection to files storage")
+	_, err := f.load()
+	if err != nil {
+		log.Println("File storage failed on loading journal")
+		return err
+	}
+	file, err := os.OpenFile(f.name, os.O_RDONLY, 0777)
+	if err != nil {
+		log.Println("File storage failed on opening file for read")
+		return err
+	}
+	err = file.Close()
+	if err != nil {
+		log.Println("File storage failed on closing file after read")
+		return err
+	}
+	file, err = os.OpenFile(f.name, os.O_WRONLY, 0777)
+	if err != nil {
+		log.Println("File storage failed on opening file for write")
+		return err
+	}
+	err = file.Close()
+	if err != nil {
+		log.Println("File storage failed on closing file after write")
+		return err
+	}
+	log.Println("Connection to file storage confirmed")
+	return nil
+}
+
+//readFile - создание файлового дескриптора для чтения журнала
+func (f *fileStorage) readFile() error {
+	err := checkFile(f.name)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(f.name, os.O_RDONLY, 0777)
+	if err != nil {
+		return err
+	}
+	f.file = file
+	return nil
+}
+
+//appendFile - создание файлового дескриптора для дозаписи журнала
+func (f *fileStorage) appendFile() error {
+	file, err := os.OpenFile(f.name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0777)
+	if err != nil {
+		return err
+	}
+	f.file = file
+	return nil
+}
+
+//load - восстановление индекса из журнала при первом обращении к хранилищу
+func (f *fileStorage) load() (*ram, error) {
+	f.rw.Lock()
+	defer f.rw.Unlock()
+	if f.closed {
+		return nil, os.ErrClosed
+	}
+	if f.index != nil {
+		return f.index, nil
+	}
+	err := f.readFile()
+	if err != nil {
+		return nil, err
+	}
+	index := NewRAM()
+	events, err := replay(f.file, index)
+	f.file.Close()
+	f.file = nil
+	var damaged *journalError
+	if errors.As(err, &damaged) {
+		//поврежденный хвост журнала отбрасывается, иначе новые записи окажутся после него и будут потеряны
+		log.Println("File storage journal is damaged, truncating it to the last valid record:", err)
+		err = f.truncate(damaged.Offset)
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = f.appendFile()
+	if err != nil {
+		return nil, err
+	}
+	f.index = index
+	f.events = events
+	f.compact = make(chan struct{}, 1)
+	f.done = make(chan struct{})
+	go f.compactor(f.compact, f.done)
+	return index, nil
+}
+
+//journalError - поврежденная запись журнала
+type journalError struct {
+	Offset int64 //Offset - конец последней целой записи
+	Err    error
+}
+
+func (e *journalError) Error() string {
+	return fmt.Sprintf("damaged record after offset %d: %v", e.Offset, e.Err)
+}
+
+func (e *journalError) Unwrap() error {
+	return e.Err
+}
+
+//replay - применение записей журнала к индексу в памяти, при повреждении журнала возвращается *journalError
+func replay(r io.Reader, index *ram) (int, error) {
+	decoder := json.NewDecoder(bufio.NewReader(r))
+	events := 0
+	for {
+		var raw json.RawMessage
+		offset := decoder.InputOffset()
+		err := decoder.Decode(&raw)
+		if errors.Is(err, io.EOF) {
+			return events, nil
+		}
+		if err != nil {
+			return events, &journalError{Offset: offset, Err: err}
+		}
+		events++
+		//legacy format: whole storage as one json array
+		if len(raw) > 0 && raw[0] == '[' {
+			data := make([]models.ClientData, 0)
+			err = json.Unmarshal(raw, &data)
+			if err != nil {
+				return events, err
+			}
+			for _, value := range data {
+				index.Write(context.Background(), value)
+			}
+			continue
+		}
+		event := journalEvent{}
+		err = json.Unmarshal(raw, &event)
+		if err != nil {
+			return events, err
+		}
+		switch {
+		case event.Op == opWrite && event.Data != nil:
+			index.Write(context.Background(), *event.Data)
+		case event.Op == opDelete && event.Delete != nil:
+			index.deleteTag(*event.Delete)
+		case event.Op == opClicks:
+			index.WriteClicks(context.Background(), event.Clicks)
+		}
+	}
+}
+
+//truncate - обрезка журнала до offset, журнал должен быть закрыт
+func (f *fileStorage) truncate(offset int64) error {
+	err := os.Truncate(f.name, offset)
+	if err != nil || offset == 0 {
+		return err
+	}
+	//последняя целая запись могла остаться без перевода строки
+	file, err := os.OpenFile(f.name, os.O_WRONLY|os.O_APPEND, 0777)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write([]byte{'\n'})
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+//appendEvent - дозапись события в журнал, вызывается под блокировкой rw до изменения индекса,
+//частично записанная строка удаляется из журнала
+func (f *fileStorage) appendEvent(event journalEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	n, err := f.file.Write(line)
+	if err != nil {
+		if info, serr := f.file.Stat(); serr == nil && n > 0 {
+			f.file.Truncate(info.Size() - int64(n))
+		}
+		return err
+	}
+	f.events++
+	if f.events >= compactThreshold && f.events >= 2*f.index.count() {
+		select {
+		case f.compact <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+//compactor - фоновое сжатие журнала
+func (f *fileStorage) compactor(compact <-chan struct{}, done <-chan struct{}) {
+	for {
+		select {
+		case <-compact:
+			err := f.compactFile()
+			if err != nil {
+				log.Println("File storage compaction failed:", err)
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+//compactFile - перезапись журнала текущим состоянием индекса
+func (f *fileStorage) compactFile() error {
+	f.rw.Lock()
+	defer f.rw.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	tmpName := f.name + ".tmp"
+	tmp, err := os.OpenFile(tmpName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0777)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(tmp)
+	encoder := json.NewEncoder(writer)
+	data := f.index.dump()
+	for i := range data {
+		err = encoder.Encode(journalEvent{Op: opWrite, Data: &data[i]})
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	clicks := f.index.dumpClicks()
+	if len(clicks) > 0 {
+		err = encoder.Encode(journalEvent{Op: opClicks, Clicks: clicks})
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	err = writer.Flush()
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Sync()
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmpName, f.name)
+	if err != nil {
+		return err
+	}
+	f.file.Close()
+	err = f.appendFile()
+	if err != nil {
+		f.file = nil
+		return err
+	}
+	f.events = len(data)
+	log.Println("File storage journal compacted")
+	return nil
+}
+
+//Close - остановка фонового сжатия и закрытие журнала
+func (f *fileStorage) Close() error {
+	f.rw.Lock()
+	defer f.rw.Unlock()
+	if f.done != nil {
+		close(f.done)
+		f.done = nil
+	}
+	f.closed = true
+	f.index = nil
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+//Write - запись в файл
+func (f *fileStorage) Write(ctx context.Context, m models.ClientData) error {
+	index, err := f.load()
+	if err != nil {
+		return err
+	}
+	f.rw.Lock()
+	defer f.rw.Unlock()
+	if f.file == nil {
+		return os.ErrClosed
+	}
+	err = index.checkWrite(m)
+	if err != nil {
+		return err
+	}
+	err = f.appendEvent(journalEvent{Op: opWrite, Data: &m})
+	if err != nil {
+		return err
+	}
+	return index.Write(ctx, m)
+}
+
+//WriteBatch - запись ссылок пользователя одним событием журнала, ранее сокращенные URL не записываются
+func (f *fileStorage) WriteBatch(ctx context.Context, m models.ClientData) ([]WriteResult, error) {
+	index, err := f.load()
+	if err != nil {
+		return nil, err
+	}
+	f.rw.Lock()
+	defer f.rw.Unlock()
+	if f.file == nil {
+		return nil, os.ErrClosed
+	}
+	result, values, err := index.checkBatch(m)
+	if err != nil {
+		return nil, err
+	}
+	written := models.ClientData{Cookie: m.Cookie, Key: m.Key, Short: values}
+	err = f.appendEvent(journalEvent{Op: opWrite, Data: &written})
+	if err != nil {
+		return nil, err
+	}
+	return result, index.Write(ctx, written)
+}
+
+//TagByURL - поиск URL
+func (f *fileStorage) TagByURL(ctx context.Context, s, cookie string) (string, error) {
+	index, err := f.load()
+	if err != nil {
+		return "", err
+	}
+	return index.TagByURL(ctx, s, cookie)
+}
+
+//ReadByCookie - чтение из файла
+func (f *fileStorage) ReadByCookie(ctx context.Context, s string) (models.ClientData, error) {
+	index, err := f.load()
+	if err != nil {
+		return models.ClientData{}, err
+	}
+	return index.ReadByCookie(ctx, s)
+}
+
+//ReadByTag - чтение из файла
+func (f *fileStorage) ReadByTag(ctx context.Context, s string) (models.ShortData, error) {
+	index, err := f.load()
+	if err != nil {
+		return models.ShortData{}, err
+	}
+	return index.ReadByTag(ctx, s)
+}
+
+//Export - потоковое чтение данных всех пользователей
+func (f *fileStorage) Export(ctx context.Context, fn func(models.ClientData) error) error {
+	index, err := f.load()
+	if err != nil {
+		return err
+	}
+	return index.Export(ctx, fn)
+}
+
+//Expired - поиск не удаленных ссылок с истекшим сроком действия
+func (f *fileStorage) Expired(ctx context.Context, now time.Time) ([]models.DelWorker, error) {
+	index, err := f.load()
+	if err != nil {
+		return nil, err
+	}
+	return index.Expired(ctx, now)
+}
+
+//WriteClicks - дозапись переходов по коротким ссылкам в журнал
+func (f *fileStorage) WriteClicks(ctx context.Context, clicks []models.Click) error {
+	index, err := f.load()
+	if err != nil {
+		return err
+	}
+	f.rw.Lock()
+	defer f.rw.Unlock()
+	if f.file == nil {
+		return os.ErrClosed
+	}
+	err = f.appendEvent(journalEvent{Op: opClicks, Clicks: clicks})
+	if err != nil {
+		return err
+	}
+	return index.WriteClicks(ctx, clicks)
+}
+
+//Stats - статистика переходов по тегу
+func (f *fileStorage) Stats(ctx context.Context, tag string, top int) (models.Stats, error) {
+	index, err := f.load()
+	if err != nil {
+		return models.Stats{}, err
+	}
+	return index.Stats(ctx, tag, top)
+}
+
+//CountURLs - количество не удаленных коротких ссылок
+func (f *fileStorage) CountURLs(ctx context.Context) (int, error) {
+	index, err := f.load()
+	if err != nil {
+		return 0, err
+	}
+	return index.CountURLs(ctx)
+}
+
+//CountUsers - количество пользователей
+func (f *fileStorage) CountUsers(ctx context.Context) (int, error) {
+	index, err := f.load()
+	if err != nil {
+		return 0, err
+	}
+	return index.CountUsers(ctx)
+}
+
+//deleteTag - mark tag as deleted in file storage
+func (f *fileStorage) deleteTag(task models.DelWorker) {
+	index, err := f.load()
+	if err != nil {
+		log.Println("Error while reading file:", err)
+		return
+	}
+	f.rw.Lock()
+	defer f.rw.Unlock()
+	if f.file == nil {
+		log.Println("Error while writing file: storage closed")
+		return
+	}
+	err = f.appendEvent(journalEvent{Op: opDelete, Delete: &task})
+	if err != nil {
+		log.Println("Error while writing file")
+		return
+	}
+	index.deleteTag(task)
+}
+
+//Cleaner - delete task worker creator, returns after inputCh is closed and all tasks are done
+func (f *fileStorage) Cleaner(inputCh <-chan models.DelWorker, workers int) {
+	helpers.Workers(helpers.FanOut(inputCh, workers), f.newWorker)
+}
+
+//newWorker - delete task worker
+func (f *fileStorage) newWorker(input <-chan models.DelWorker) {
+	for task := range input {
+		f.deleteTag(task)
+	}
+}
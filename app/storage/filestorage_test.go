@@ -1,209 +1,348 @@
-package storage
-
-import (
-	"os"
-	"sync"
-	"testing"
-	"time"
-
-	"github.com/stretchr/testify/require"
-
-	"github.com/t1mon-ggg/go_shortner/app/models"
-)
-
-//Ping() error
-func Test_File_Ping(t *testing.T) {
-	f := NewFile("createme.txt")
-	err := checkFile(f.name)
-	require.NoError(t, err)
-	err = f.Ping()
-	require.NoError(t, err)
-	err = os.Remove(f.name)
-	require.NoError(t, err)
-
-}
-func Test_openFile(t *testing.T) {
-	tests := []struct {
-		name string
-		args string
-	}{
-		{
-			name: "NotExistent file",
-			args: "createme.txt",
-		},
-		{
-			name: "Duplicated file",
-			args: "createme.txt",
-		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			f := fileStorage{}
-			f.rw = &sync.Mutex{}
-			f.name = tt.args
-			defer f.Close()
-			err := f.readFile()
-			require.NoError(t, err)
-		})
-	}
-	t.Run("Remove test file", func(t *testing.T) {
-		err := os.Remove("createme.txt")
-		require.NoError(t, err)
-	})
-}
-
-//Write(models.ClientData) error
-func Test_FileDB_Write(t *testing.T) {
-	data := []models.ClientData{
-		{
-			Cookie: "cookie1",
-			Key:    "secret_key1",
-			Short: []models.ShortData{
-				{
-					Short: "abcdABC1",
-					Long:  "http://example1.org",
-				},
-			},
-		},
-		{
-			Cookie: "cookie2",
-			Key:    "secret_key2",
-			Short: []models.ShortData{
-				{
-					Short: "abcdABC2",
-					Long:  "http://example2.org",
-				},
-			},
-		},
-		{
-			Cookie: "cookie3",
-			Key:    "secret_key3",
-			Short: []models.ShortData{
-				{
-					Short: "abcdABC3",
-					Long:  "http://example3.org",
-				},
-			},
-		},
-	}
-	f := NewFile("createme.txt")
-	for _, value := range data {
-		err := f.Write(value)
-		require.NoError(t, err)
-	}
-	err := os.Remove("createme.txt")
-	require.NoError(t, err)
-}
-
-func (f *fileStorage) testPrepare(t *testing.T) {
-	data := []models.ClientData{
-		{
-			Cookie: "cookie1",
-			Key:    "secret_key1",
-			Short: []models.ShortData{
-				{
-					Short: "abcdABC1",
-					Long:  "http://example1.org",
-				},
-			},
-		},
-		{
-			Cookie: "cookie2",
-			Key:    "secret_key2",
-			Short: []models.ShortData{
-				{
-					Short: "abcdABC2",
-					Long:  "http://example2.org",
-				},
-			},
-		},
-		{
-			Cookie: "cookie3",
-			Key:    "secret_key3",
-			Short: []models.ShortData{
-				{
-					Short: "abcdABC3",
-					Long:  "http://example3.org",
-				},
-			},
-		},
-	}
-	for _, value := range data {
-		err := f.Write(value)
-		require.NoError(t, err)
-	}
-}
-
-//ReadByCookie(string) (models.ClientData, error)
-func Test_FileDB_ReadByCookie(t *testing.T) {
-	f := NewFile("createme.txt")
-	f.testPrepare(t)
-	exp := models.ClientData{
-		Cookie: "cookie2",
-		Key:    "secret_key2",
-		Short: []models.ShortData{
-			{
-				Short: "abcdABC2",
-				Long:  "http://example2.org",
-			},
-		},
-	}
-	data, err := f.ReadByCookie("cookie2")
-	require.NoError(t, err)
-	require.Equal(t, exp, data)
-	err = os.Remove("createme.txt")
-	require.NoError(t, err)
-}
-
-//ReadByTag(string) (models.ShortData, error)
-func Test_FileDB_ReadByTag(t *testing.T) {
-	f := NewFile("createme.txt")
-	f.testPrepare(t)
-	exp := models.ShortData{
-		Short: "abcdABC2",
-		Long:  "http://example2.org",
-	}
-	data, err := f.ReadByTag("abcdABC2")
-	require.NoError(t, err)
-	require.Equal(t, exp, data)
-	err = os.Remove("createme.txt")
-	require.NoError(t, err)
-}
-
-//TagByURL(string) (string, error)
-func Test_FileDB_TagByURL(t *testing.T) {
-	f := NewFile("createme.txt")
-	f.testPrepare(t)
-	exp := "abcdABC2"
-	data, err := f.TagByURL("http://example2.org", "cookie2")
-	require.NoError(t, err)
-	require.Equal(t, exp, data)
-	err = os.Remove("createme.txt")
-	require.NoError(t, err)
-
-}
-
-//Delete([]string) error
-func Test_FileDB_Delete(t *testing.T) {
-	r := models.ClientData{
-		Cookie: "cookie2",
-		Key:    "secret_key2",
-		Short: []models.ShortData{
-			{
-				Short:   "abcdABC2",
-				Long:    "http://example2.org",
-				Deleted: true,
-			},
-		},
-	}
-	f := NewFile("createme.txt")
-	f.testPrepare(t)
-	task := models.DelWorker{Cookie: "cookie2", Tags: []string{"abcdABC2"}}
-	f.deleteTag(task)
-	time.Sleep(5 * time.Second)
-	d, err := f.ReadByCookie("cookie2")
-	require.NoError(t, err)
-	require.Equal(t, r, d)
-	err = os.Remove("createme.txt")
-	require.NoError(t, err)
-}
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/t1mon-ggg/go_shortner/app/models"
+)
+
+//Ping() error
+func Test_File_Ping(t *testing.T) {
+	f := NewFile("createme.txt")
+	err := checkFile(f.name)
+	require.NoError(t, err)
+	err = f.Ping(context.Background())
+	require.NoError(t, err)
+	err = os.Remove(f.name)
+	require.NoError(t, err)
+
+}
+func Test_openFile(t *testing.T) {
+	tests := []struct {
+		name string
+		args string
+	}{
+		{
+			name: "NotExistent file",
+			args: "createme.txt",
+		},
+		{
+			name: "Duplicated file",
+			args: "createme.txt",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := fileStorage{}
+			f.rw = &sync.Mutex{}
+			f.name = tt.args
+			defer f.Close()
+			err := f.readFile()
+			require.NoError(t, err)
+		})
+	}
+	t.Run("Remove test file", func(t *testing.T) {
+		err := os.Remove("createme.txt")
+		require.NoError(t, err)
+	})
+}
+
+//Write(models.ClientData) error
+func Test_FileDB_Write(t *testing.T) {
+	data := []models.ClientData{
+		{
+			Cookie: "cookie1",
+			Key:    "secret_key1",
+			Short: []models.ShortData{
+				{
+					Short: "abcdABC1",
+					Long:  "http://example1.org",
+				},
+			},
+		},
+		{
+			Cookie: "cookie2",
+			Key:    "secret_key2",
+			Short: []models.ShortData{
+				{
+					Short: "abcdABC2",
+					Long:  "http://example2.org",
+				},
+			},
+		},
+		{
+			Cookie: "cookie3",
+			Key:    "secret_key3",
+			Short: []models.ShortData{
+				{
+					Short: "abcdABC3",
+					Long:  "http://example3.org",
+				},
+			},
+		},
+	}
+	f := NewFile("createme.txt")
+	for _, value := range data {
+		err := f.Write(context.Background(), value)
+		require.NoError(t, err)
+	}
+	err := os.Remove("createme.txt")
+	require.NoError(t, err)
+}
+
+func (f *fileStorage) testPrepare(t *testing.T) {
+	data := []models.ClientData{
+		{
+			Cookie: "cookie1",
+			Key:    "secret_key1",
+			Short: []models.ShortData{
+				{
+					Short: "abcdABC1",
+					Long:  "http://example1.org",
+				},
+			},
+		},
+		{
+			Cookie: "cookie2",
+			Key:    "secret_key2",
+			Short: []models.ShortData{
+				{
+					Short: "abcdABC2",
+					Long:  "http://example2.org",
+				},
+			},
+		},
+		{
+			Cookie: "cookie3",
+			Key:    "secret_key3",
+			Short: []models.ShortData{
+				{
+					Short: "abcdABC3",
+					Long:  "http://example3.org",
+				},
+			},
+		},
+	}
+	for _, value := range data {
+		err := f.Write(context.Background(), value)
+		require.NoError(t, err)
+	}
+}
+
+//ReadByCookie(string) (models.ClientData, error)
+func Test_FileDB_ReadByCookie(t *testing.T) {
+	f := NewFile("createme.txt")
+	f.testPrepare(t)
+	exp := models.ClientData{
+		Cookie: "cookie2",
+		Key:    "secret_key2",
+		Short: []models.ShortData{
+			{
+				Short: "abcdABC2",
+				Long:  "http://example2.org",
+			},
+		},
+	}
+	data, err := f.ReadByCookie(context.Background(), "cookie2")
+	require.NoError(t, err)
+	require.Equal(t, exp, data)
+	err = os.Remove("createme.txt")
+	require.NoError(t, err)
+}
+
+//ReadByTag(string) (models.ShortData, error)
+func Test_FileDB_ReadByTag(t *testing.T) {
+	f := NewFile("createme.txt")
+	f.testPrepare(t)
+	exp := models.ShortData{
+		Short: "abcdABC2",
+		Long:  "http://example2.org",
+	}
+	data, err := f.ReadByTag(context.Background(), "abcdABC2")
+	require.NoError(t, err)
+	require.Equal(t, exp, data)
+	err = os.Remove("createme.txt")
+	require.NoError(t, err)
+}
+
+//TagByURL(string) (string, error)
+func Test_FileDB_TagByURL(t *testing.T) {
+	f := NewFile("createme.txt")
+	f.testPrepare(t)
+	exp := "abcdABC2"
+	data, err := f.TagByURL(context.Background(), "http://example2.org", "cookie2")
+	require.NoError(t, err)
+	require.Equal(t, exp, data)
+	err = os.Remove("createme.txt")
+	require.NoError(t, err)
+
+}
+
+//Delete([]string) error
+func Test_FileDB_Delete(t *testing.T) {
+	r := models.ClientData{
+		Cookie: "cookie2",
+		Key:    "secret_key2",
+		Short: []models.ShortData{
+			{
+				Short:   "abcdABC2",
+				Long:    "http://example2.org",
+				Deleted: true,
+			},
+		},
+	}
+	f := NewFile("createme.txt")
+	f.testPrepare(t)
+	task := models.DelWorker{Cookie: "cookie2", Tags: []string{"abcdABC2"}}
+	f.deleteTag(task)
+	time.Sleep(5 * time.Second)
+	d, err := f.ReadByCookie(context.Background(), "cookie2")
+	require.NoError(t, err)
+	require.Equal(t, r, d)
+	err = os.Remove("createme.txt")
+	require.NoError(t, err)
+}
+
+//journal replay and compaction
+func Test_FileDB_Journal(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "journal.txt")
+	ctx := context.Background()
+	f := NewFile(name)
+	f.testPrepare(t)
+	f.deleteTag(models.DelWorker{Cookie: "cookie2", Tags: []string{"abcdABC2"}})
+	err := f.Write(ctx, models.ClientData{Cookie: "cookie2", Short: []models.ShortData{{Short: "abcdABC4", Long: "http://example2.org"}}})
+	require.NoError(t, err)
+	exp, err := f.ReadByCookie(ctx, "cookie2")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	f = NewFile(name)
+	data, err := f.ReadByCookie(ctx, "cookie2")
+	require.NoError(t, err)
+	require.Equal(t, exp, data)
+	err = f.compactFile()
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	f = NewFile(name)
+	defer f.Close()
+	data, err = f.ReadByCookie(ctx, "cookie2")
+	require.NoError(t, err)
+	require.Equal(t, exp, data)
+	_, err = f.ReadByTag(ctx, "abcdABC2")
+	require.ErrorIs(t, err, ErrDeleted)
+	err = f.Write(ctx, models.ClientData{Cookie: "cookie2", Short: []models.ShortData{{Short: "abcdABC5", Long: "http://example2.org"}}})
+	require.ErrorIs(t, err, ErrConflict)
+}
+
+func Test_FileDB_Expired(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "journal.txt")
+	f := NewFile(name)
+	f.testPrepare(t)
+	checkExpired(t, f)
+	require.NoError(t, f.Close())
+	f = NewFile(name)
+	defer f.Close()
+	tag, err := f.TagByURL(context.Background(), "http://expired1.org", "cookie_exp")
+	require.NoError(t, err)
+	require.Equal(t, "renewed1", tag)
+}
+
+func Test_FileDB_WriteBatch(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "journal.txt")
+	f := NewFile(name)
+	f.testPrepare(t)
+	checkWriteBatch(t, f)
+	require.NoError(t, f.Close())
+	f = NewFile(name)
+	defer f.Close()
+	data, err := f.ReadByCookie(context.Background(), "cookie1")
+	require.NoError(t, err)
+	require.Equal(t, []models.ShortData{
+		{Short: "abcdABC1", Long: "http://example1.org"},
+		{Short: "batchTag1", Long: "http://batch1.org"},
+	}, data.Short)
+}
+
+func Test_FileDB_DamagedJournal(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "journal.txt")
+	ctx := context.Background()
+	f := NewFile(name)
+	require.NoError(t, f.Write(ctx, models.ClientData{Cookie: "cookie1", Short: []models.ShortData{{Short: "abcdABC1", Long: "http://example1.org"}}}))
+	require.NoError(t, f.Close())
+	//torn last record
+	journal, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0600)
+	require.NoError(t, err)
+	_, err = journal.WriteString(`{"op":"write","data":{"cookie":"cook`)
+	require.NoError(t, err)
+	require.NoError(t, journal.Close())
+
+	f = NewFile(name)
+	_, err = f.ReadByTag(ctx, "abcdABC1")
+	require.NoError(t, err)
+	require.NoError(t, f.Write(ctx, models.ClientData{Cookie: "cookie1", Short: []models.ShortData{{Short: "abcdABC2", Long: "http://example2.org"}}}))
+	require.NoError(t, f.Close())
+
+	f = NewFile(name)
+	defer f.Close()
+	data, err := f.ReadByCookie(ctx, "cookie1")
+	require.NoError(t, err)
+	require.Len(t, data.Short, 2)
+}
+
+func Test_FileDB_AppendFailed(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "journal.txt")
+	ctx := context.Background()
+	f := NewFile(name)
+	defer f.Close()
+	require.NoError(t, f.Write(ctx, models.ClientData{Cookie: "cookie1", Short: []models.ShortData{{Short: "abcdABC1", Long: "http://example1.org"}}}))
+	//journal becomes unwritable, index must not change
+	f.file.Close()
+	err := f.Write(ctx, models.ClientData{Cookie: "cookie1", Short: []models.ShortData{{Short: "abcdABC2", Long: "http://example2.org"}}})
+	require.Error(t, err)
+	_, err = f.ReadByTag(ctx, "abcdABC2")
+	require.ErrorIs(t, err, ErrNotFound)
+	_, err = f.WriteBatch(ctx, models.ClientData{Cookie: "cookie1", Short: []models.ShortData{{Short: "abcdABC3", Long: "http://example3.org"}}})
+	require.Error(t, err)
+	_, err = f.ReadByTag(ctx, "abcdABC3")
+	require.ErrorIs(t, err, ErrNotFound)
+	_, err = f.TagByURL(ctx, "http://example3.org", "cookie1")
+	require.ErrorIs(t, err, ErrNotFound)
+	require.Error(t, f.WriteClicks(ctx, []models.Click{{Tag: "abcdABC1"}}))
+	stats, err := f.Stats(ctx, "abcdABC1", 10)
+	require.NoError(t, err)
+	require.Equal(t, 0, stats.Clicks)
+}
+
+func Test_FileDB_Closed(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "journal.txt")
+	ctx := context.Background()
+	f := NewFile(name)
+	require.NoError(t, f.Write(ctx, models.ClientData{Cookie: "cookie1", Short: []models.ShortData{{Short: "abcdABC1", Long: "http://example1.org"}}}))
+	require.NoError(t, f.Close())
+	//closed storage must not reopen the journal and start another compactor
+	err := f.Write(ctx, models.ClientData{Cookie: "cookie1", Short: []models.ShortData{{Short: "abcdABC2", Long: "http://example2.org"}}})
+	require.ErrorIs(t, err, os.ErrClosed)
+	_, err = f.WriteBatch(ctx, models.ClientData{Cookie: "cookie1", Short: []models.ShortData{{Short: "abcdABC3", Long: "http://example3.org"}}})
+	require.ErrorIs(t, err, os.ErrClosed)
+	require.ErrorIs(t, f.WriteClicks(ctx, []models.Click{{Tag: "abcdABC1"}}), os.ErrClosed)
+	_, err = f.ReadByTag(ctx, "abcdABC1")
+	require.ErrorIs(t, err, os.ErrClosed)
+	f.deleteTag(models.DelWorker{Cookie: "cookie1", Tags: []string{"abcdABC1"}})
+	require.Nil(t, f.file)
+	require.Nil(t, f.done)
+	require.NoError(t, f.Close())
+
+	f = NewFile(name)
+	defer f.Close()
+	data, err := f.ReadByCookie(ctx, "cookie1")
+	require.NoError(t, err)
+	require.Equal(t, []models.ShortData{{Short: "abcdABC1", Long: "http://example1.org"}}, data.Short)
+}
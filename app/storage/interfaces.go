@@ -1,16 +1,56 @@
-package storage
-
-import (
-	"github.com/t1mon-ggg/go_shortner/app/models"
-)
-
-//Data - application storage interface
-type Storage interface {
-	Write(models.ClientData) error                  //write to storage
-	ReadByCookie(string) (models.ClientData, error) //read from storage by cookie
-	ReadByTag(string) (models.ShortData, error)     //read from storage by tag
-	TagByURL(string, string) (string, error)        //get tag from storage by url
-	Close() error                                   //close storage pointer
-	Ping() error                                    //get storage status
-	Cleaner(<-chan models.DelWorker, int)           //mark tag as deleted
-}
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/t1mon-ggg/go_shortner/app/models"
+)
+
+//storage errors
+var (
+	ErrNotFound = errors.New("not found")                     //ErrNotFound - requested data is absent in storage
+	ErrConflict = errors.New("not unique url")                //ErrConflict - url already shortened by this user
+	ErrDeleted  = errors.New("short url deleted")             //ErrDeleted - requested short url is marked as deleted
+	ErrTagTaken = fmt.Errorf("%w: tag is taken", ErrConflict) //ErrTagTaken - short url tag already belongs to another url
+)
+
+//Data - application storage interface
+type Storage interface {
+	Write(context.Context, models.ClientData) error                       //write to storage
+	WriteBatch(context.Context, models.ClientData) ([]WriteResult, error) //write all user urls at once or nothing
+	ReadByCookie(context.Context, string) (models.ClientData, error)      //read from storage by cookie
+	ReadByTag(context.Context, string) (models.ShortData, error)          //read from storage by tag
+	TagByURL(context.Context, string, string) (string, error)             //get tag from storage by url
+	Close() error                                                         //close storage pointer
+	Ping(context.Context) error                                           //get storage status
+	Cleaner(<-chan models.DelWorker, int)                                 //mark tag as deleted until input is closed
+	Export(context.Context, func(models.ClientData) error) error          //stream all users data from storage
+	Expired(context.Context, time.Time) ([]models.DelWorker, error)       //list not deleted tags expired at given time
+	WriteClicks(context.Context, []models.Click) error                    //save redirect click events
+	Stats(context.Context, string, int) (models.Stats, error)             //get click statistics of tag with top n referrers and countries
+	CountURLs(context.Context) (int, error)                               //count not deleted short urls
+	CountUsers(context.Context) (int, error)                              //count users
+}
+
+//WriteResult - result of batch item write
+type WriteResult struct {
+	Tag     string //Tag - short url tag, existing tag if url was already shortened by user
+	Existed bool   //Existed - url was already shortened by user and is not written again
+}
+
+//BatchError - batch item which prevents whole batch from being written
+type BatchError struct {
+	Index int   //Index - position of item in batch
+	Err   error //Err - reason of failure
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("batch item %d: %v", e.Index, e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
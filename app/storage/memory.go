@@ -1,120 +1,353 @@
-package storage
-
-import (
-	"sync"
-
-	"github.com/t1mon-ggg/go_shortner/app/helpers"
-	"github.com/t1mon-ggg/go_shortner/app/models"
-)
-
-type ram struct {
-	DB  []models.ClientData
-	Mux *sync.RWMutex
-}
-
-//Newram - new in memory storage
-func NewRAM() *ram {
-	s := ram{}
-	s.DB = make([]models.ClientData, 0)
-	s.Mux = &sync.RWMutex{}
-	return &s
-}
-
-//Write - добавление данных в память
-func (data *ram) Write(m models.ClientData) error {
-	(*data).Mux.Lock()
-	newData, err := helpers.Merger((*data).DB, m)
-	if err != nil {
-		(*data).Mux.Unlock()
-		return err
-	}
-	(*data).DB = newData
-	(*data).Mux.Unlock()
-	return nil
-}
-
-//TagByURL - чтение из памяти по cookie
-func (data *ram) TagByURL(s, cookie string) (string, error) {
-	(*data).Mux.RLock()
-	for _, value := range (*data).DB {
-		for _, url := range value.Short {
-			if url.Long == s && value.Cookie == cookie {
-				(*data).Mux.RUnlock()
-				return url.Short, nil
-			}
-		}
-	}
-	(*data).Mux.RUnlock()
-	return "", nil
-}
-
-//ReadByCookie - чтение из памяти по cookie
-func (data *ram) ReadByCookie(s string) (models.ClientData, error) {
-	(*data).Mux.RLock()
-	for _, value := range (*data).DB {
-		if value.Cookie == s {
-			(*data).Mux.RUnlock()
-			return value, nil
-		}
-	}
-	(*data).Mux.RUnlock()
-	return models.ClientData{}, nil
-}
-
-//ReadByTag - чтение из памяти по cookie
-func (data *ram) ReadByTag(s string) (models.ShortData, error) {
-	(*data).Mux.RLock()
-	for _, userValue := range (*data).DB {
-		for _, urlValue := range userValue.Short {
-			if urlValue.Short == s {
-				(*data).Mux.RUnlock()
-				return urlValue, nil
-			}
-		}
-	}
-	(*data).Mux.RUnlock()
-	return models.ShortData{}, nil
-}
-
-//Close - освобождение области данных
-func (data *ram) Close() error {
-	(*data).Mux.Lock()
-	*data = ram{}
-	return nil
-}
-
-//Ping - проверка наличия в памяти области данных
-func (data ram) Ping() error {
-	return nil
-}
-
-//Cleaner - delete task worker creator
-func (data *ram) Cleaner(inputCh <-chan models.DelWorker, workers int) {
-	fanOutChs := helpers.FanOut(inputCh, workers)
-	for _, fanOutCh := range fanOutChs {
-		go data.newWorker(fanOutCh)
-	}
-}
-
-//deleteTag - mark tag as deleted
-func (data *ram) deleteTag(task models.DelWorker) {
-	(*data).Mux.Lock()
-	for _, tag := range task.Tags {
-		for i, user := range (*data).DB {
-			for j, url := range user.Short {
-				if user.Cookie == task.Cookie && url.Short == tag {
-					(*data).DB[i].Short[j].Deleted = true
-				}
-			}
-		}
-	}
-	(*data).Mux.Unlock()
-
-}
-
-//newWorker - delete task worker
-func (data *ram) newWorker(input <-chan models.DelWorker) {
-	for task := range input {
-		data.deleteTag(task)
-	}
-}
+package storage
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/t1mon-ggg/go_shortner/app/helpers"
+	"github.com/t1mon-ggg/go_shortner/app/models"
+)
+
+//ramUser - user record of in memory storage
+type ramUser struct {
+	key  string   //cookie sign key
+	tags []string //user tags in write order
+}
+
+//ramURL - short url record of in memory storage
+type ramURL struct {
+	cookie string           //owner of short url
+	data   models.ShortData //short url data
+}
+
+//ramURLKey - key of long url index
+type ramURLKey struct {
+	cookie string //owner of url
+	long   string //original url
+}
+
+type ram struct {
+	users  map[string]*ramUser       //users by cookie
+	tags   map[string]*ramURL        //short urls by tag
+	urls   map[ramURLKey]string      //tags of not deleted urls by cookie and original url
+	clicks map[string][]models.Click //click events by tag
+	Mux    *sync.RWMutex
+}
+
+//Newram - new in memory storage
+func NewRAM() *ram {
+	s := ram{}
+	s.users = make(map[string]*ramUser)
+	s.tags = make(map[string]*ramURL)
+	s.urls = make(map[ramURLKey]string)
+	s.clicks = make(map[string][]models.Click)
+	s.Mux = &sync.RWMutex{}
+	return &s
+}
+
+//Write - добавление данных в память
+func (data *ram) Write(ctx context.Context, m models.ClientData) error {
+	(*data).Mux.Lock()
+	defer (*data).Mux.Unlock()
+	err := data.validate(m)
+	if err != nil {
+		return err
+	}
+	data.apply(m.Cookie, m.Key, m.Short)
+	return nil
+}
+
+//apply - добавление проверенных ссылок пользователя, вызывается под блокировкой
+func (data *ram) apply(cookie, key string, values []models.ShortData) {
+	user, ok := (*data).users[cookie]
+	if !ok {
+		user = &ramUser{key: key, tags: make([]string, 0, len(values))}
+		(*data).users[cookie] = user
+	}
+	for _, value := range values {
+		if _, ok := (*data).tags[value.Short]; ok {
+			continue
+		}
+		(*data).tags[value.Short] = &ramURL{cookie: cookie, data: value}
+		user.tags = append(user.tags, value.Short)
+		if !value.Deleted {
+			data.release(cookie, value.Long)
+			(*data).urls[ramURLKey{cookie: cookie, long: value.Long}] = value.Short
+		}
+	}
+}
+
+//liveTag - тег не удаленной ссылки пользователя на url с не истекшим сроком действия, вызывается под блокировкой
+func (data *ram) liveTag(cookie, long string) (string, bool) {
+	tag, ok := (*data).urls[ramURLKey{cookie: cookie, long: long}]
+	if !ok {
+		return "", false
+	}
+	if expires := (*data).tags[tag].data.Expires; !expires.IsZero() && !expires.After(time.Now()) {
+		return "", false
+	}
+	return tag, true
+}
+
+//release - пометка ссылки пользователя на url как удаленной перед повторным сокращением url, вызывается под блокировкой.
+//Запись проходит проверку validate, поэтому освобождается только ссылка с истекшим сроком действия
+func (data *ram) release(cookie, long string) {
+	key := ramURLKey{cookie: cookie, long: long}
+	if tag, ok := (*data).urls[key]; ok {
+		(*data).tags[tag].data.Deleted = true
+		delete((*data).urls, key)
+	}
+}
+
+//checkWrite - проверка возможности записи без изменения данных
+func (data *ram) checkWrite(m models.ClientData) error {
+	(*data).Mux.RLock()
+	defer (*data).Mux.RUnlock()
+	return data.validate(m)
+}
+
+//validate - проверка конфликтов записи, вызывается под блокировкой
+func (data *ram) validate(m models.ClientData) error {
+	urls := make(map[string]bool)
+	for _, value := range m.Short {
+		if value.Deleted {
+			continue
+		}
+		if _, ok := data.liveTag(m.Cookie, value.Long); ok || urls[value.Long] {
+			return ErrConflict
+		}
+		urls[value.Long] = true
+	}
+	for _, value := range m.Short {
+		if url, ok := (*data).tags[value.Short]; ok && (url.cookie != m.Cookie || url.data != value) {
+			return ErrTagTaken
+		}
+	}
+	return nil
+}
+
+//WriteBatch - добавление ссылок пользователя в память под одной блокировкой, ранее сокращенные URL не записываются
+func (data *ram) WriteBatch(ctx context.Context, m models.ClientData) ([]WriteResult, error) {
+	(*data).Mux.Lock()
+	defer (*data).Mux.Unlock()
+	result, written, err := data.planBatch(m)
+	if err != nil {
+		return nil, err
+	}
+	data.apply(m.Cookie, m.Key, written)
+	return result, nil
+}
+
+//checkBatch - результат пакетной записи и записываемые ссылки без изменения данных
+func (data *ram) checkBatch(m models.ClientData) ([]WriteResult, []models.ShortData, error) {
+	(*data).Mux.RLock()
+	defer (*data).Mux.RUnlock()
+	return data.planBatch(m)
+}
+
+//planBatch - результат пакетной записи и записываемые ссылки, вызывается под блокировкой
+func (data *ram) planBatch(m models.ClientData) ([]WriteResult, []models.ShortData, error) {
+	result := make([]WriteResult, len(m.Short))
+	written := make([]models.ShortData, 0, len(m.Short))
+	tags := make(map[string]bool)
+	urls := make(map[string]string)
+	for i, value := range m.Short {
+		if !value.Deleted {
+			if tag, ok := data.liveTag(m.Cookie, value.Long); ok {
+				result[i] = WriteResult{Tag: tag, Existed: true}
+				continue
+			}
+			if tag, ok := urls[value.Long]; ok {
+				result[i] = WriteResult{Tag: tag, Existed: true}
+				continue
+			}
+		}
+		if _, ok := (*data).tags[value.Short]; ok || tags[value.Short] {
+			return nil, nil, &BatchError{Index: i, Err: ErrTagTaken}
+		}
+		tags[value.Short] = true
+		if !value.Deleted {
+			urls[value.Long] = value.Short
+		}
+		result[i] = WriteResult{Tag: value.Short}
+		written = append(written, value)
+	}
+	return result, written, nil
+}
+
+//TagByURL - чтение из памяти по cookie
+func (data *ram) TagByURL(ctx context.Context, s, cookie string) (string, error) {
+	(*data).Mux.RLock()
+	defer (*data).Mux.RUnlock()
+	tag, ok := data.liveTag(cookie, s)
+	if !ok {
+		return "", ErrNotFound
+	}
+	return tag, nil
+}
+
+//ReadByCookie - чтение из памяти по cookie
+func (data *ram) ReadByCookie(ctx context.Context, s string) (models.ClientData, error) {
+	(*data).Mux.RLock()
+	defer (*data).Mux.RUnlock()
+	user, ok := (*data).users[s]
+	if !ok {
+		return models.ClientData{}, ErrNotFound
+	}
+	return data.clientData(s, user), nil
+}
+
+//clientData - сборка данных пользователя, вызывается под блокировкой
+func (data *ram) clientData(cookie string, user *ramUser) models.ClientData {
+	result := models.ClientData{Cookie: cookie, Key: user.key}
+	result.Short = make([]models.ShortData, 0, len(user.tags))
+	for _, tag := range user.tags {
+		result.Short = append(result.Short, (*data).tags[tag].data)
+	}
+	return result
+}
+
+//ReadByTag - чтение из памяти по cookie
+func (data *ram) ReadByTag(ctx context.Context, s string) (models.ShortData, error) {
+	(*data).Mux.RLock()
+	defer (*data).Mux.RUnlock()
+	url, ok := (*data).tags[s]
+	if !ok {
+		return models.ShortData{}, ErrNotFound
+	}
+	if url.data.Deleted {
+		return url.data, ErrDeleted
+	}
+	return url.data, nil
+}
+
+//Close - освобождение области данных
+func (data *ram) Close() error {
+	(*data).Mux.Lock()
+	*data = ram{}
+	return nil
+}
+
+//Ping - проверка наличия в памяти области данных
+func (data ram) Ping(ctx context.Context) error {
+	return nil
+}
+
+//Cleaner - delete task worker creator, returns after inputCh is closed and all tasks are done
+func (data *ram) Cleaner(inputCh <-chan models.DelWorker, workers int) {
+	helpers.Workers(helpers.FanOut(inputCh, workers), data.newWorker)
+}
+
+//deleteTag - mark tag as deleted
+func (data *ram) deleteTag(task models.DelWorker) {
+	(*data).Mux.Lock()
+	defer (*data).Mux.Unlock()
+	for _, tag := range task.Tags {
+		url, ok := (*data).tags[tag]
+		if !ok || url.cookie != task.Cookie || url.data.Deleted {
+			continue
+		}
+		url.data.Deleted = true
+		delete((*data).urls, ramURLKey{cookie: url.cookie, long: url.data.Long})
+	}
+}
+
+//newWorker - delete task worker
+func (data *ram) newWorker(input <-chan models.DelWorker) {
+	for task := range input {
+		data.deleteTag(task)
+	}
+}
+
+//Export - потоковое чтение данных всех пользователей
+func (data *ram) Export(ctx context.Context, fn func(models.ClientData) error) error {
+	for _, user := range data.dump() {
+		err := fn(user)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//Expired - поиск не удаленных ссылок с истекшим сроком действия
+func (data *ram) Expired(ctx context.Context, now time.Time) ([]models.DelWorker, error) {
+	(*data).Mux.RLock()
+	defer (*data).Mux.RUnlock()
+	byCookie := make(map[string]*models.DelWorker)
+	for tag, url := range (*data).tags {
+		if url.data.Deleted || url.data.Expires.IsZero() || url.data.Expires.After(now) {
+			continue
+		}
+		task, ok := byCookie[url.cookie]
+		if !ok {
+			task = &models.DelWorker{Cookie: url.cookie, Tags: make([]string, 0)}
+			byCookie[url.cookie] = task
+		}
+		task.Tags = append(task.Tags, tag)
+	}
+	result := make([]models.DelWorker, 0, len(byCookie))
+	for _, task := range byCookie {
+		result = append(result, *task)
+	}
+	return result, nil
+}
+
+//WriteClicks - сохранение переходов по коротким ссылкам
+func (data *ram) WriteClicks(ctx context.Context, clicks []models.Click) error {
+	(*data).Mux.Lock()
+	defer (*data).Mux.Unlock()
+	for _, click := range clicks {
+		(*data).clicks[click.Tag] = append((*data).clicks[click.Tag], click)
+	}
+	return nil
+}
+
+//Stats - статистика переходов по тегу
+func (data *ram) Stats(ctx context.Context, tag string, top int) (models.Stats, error) {
+	(*data).Mux.RLock()
+	defer (*data).Mux.RUnlock()
+	return clickStats(tag, (*data).clicks[tag], top), nil
+}
+
+//CountURLs - количество не удаленных коротких ссылок
+func (data *ram) CountURLs(ctx context.Context) (int, error) {
+	(*data).Mux.RLock()
+	defer (*data).Mux.RUnlock()
+	return len((*data).urls), nil
+}
+
+//CountUsers - количество пользователей
+func (data *ram) CountUsers(ctx context.Context) (int, error) {
+	return data.count(), nil
+}
+
+//dumpClicks - копия всех переходов по коротким ссылкам
+func (data *ram) dumpClicks() []models.Click {
+	(*data).Mux.RLock()
+	defer (*data).Mux.RUnlock()
+	result := make([]models.Click, 0)
+	for _, clicks := range (*data).clicks {
+		result = append(result, clicks...)
+	}
+	return result
+}
+
+//dump - копия всех данных хранилища
+func (data *ram) dump() []models.ClientData {
+	(*data).Mux.RLock()
+	defer (*data).Mux.RUnlock()
+	result := make([]models.ClientData, 0, len((*data).users))
+	for cookie, user := range (*data).users {
+		result = append(result, data.clientData(cookie, user))
+	}
+	return result
+}
+
+//count - количество пользователей в хранилище
+func (data *ram) count() int {
+	(*data).Mux.RLock()
+	defer (*data).Mux.RUnlock()
+	return len((*data).users)
+}
@@ -1,140 +1,397 @@
-package storage
-
-import (
-	"testing"
-	"time"
-
-	"github.com/stretchr/testify/require"
-
-	"github.com/t1mon-ggg/go_shortner/app/helpers"
-	"github.com/t1mon-ggg/go_shortner/app/models"
-)
-
-func (data *ram) testPrepare(t *testing.T) {
-	d := []models.ClientData{
-		{
-			Cookie: "cookie1",
-			Key:    "secret_key1",
-			Short: []models.ShortData{
-				{
-					Short:   "abcdABC1",
-					Long:    "http://example1.org",
-					Deleted: false,
-				},
-			},
-		},
-		{
-			Cookie: "cookie2",
-			Key:    "secret_key2",
-			Short: []models.ShortData{
-				{
-					Short:   "abcdABC2",
-					Long:    "http://example2.org",
-					Deleted: false,
-				},
-			},
-		},
-		{
-			Cookie: "cookie3",
-			Key:    "secret_key3",
-			Short: []models.ShortData{
-				{
-					Short:   "abcdABC3",
-					Long:    "http://example3.org",
-					Deleted: false,
-				},
-			},
-		},
-	}
-	for _, value := range d {
-		err := data.Write(value)
-		require.NoError(t, err)
-	}
-}
-
-func Test_MEM_Write(t *testing.T) {
-	db := NewRAM()
-	data := models.ClientData{
-		Cookie: "cookie1",
-		Key:    "secret_key",
-		Short: []models.ShortData{
-			{
-				Short: "Short1",
-				Long:  "Long1",
-			},
-		},
-	}
-	exp := NewRAM()
-	exp.DB, _ = helpers.Merger(exp.DB, data)
-	err := db.Write(data)
-	require.NoError(t, err)
-	require.Equal(t, exp, db)
-}
-
-func Test_MEM_ReadByCookie(t *testing.T) {
-	db := NewRAM()
-	db.testPrepare(t)
-	exp := models.ClientData{
-		Cookie: "cookie2",
-		Key:    "secret_key2",
-		Short: []models.ShortData{
-			{
-				Short: "abcdABC2",
-				Long:  "http://example2.org",
-			},
-		},
-	}
-	val, err := db.ReadByCookie("cookie2")
-	require.NoError(t, err)
-	require.Equal(t, exp, val)
-}
-
-func Test_MEM_ReadByTag(t *testing.T) {
-	db := NewRAM()
-	db.testPrepare(t)
-	expected := models.ShortData{Short: "abcdABC2", Long: "http://example2.org"}
-	val, err := db.ReadByTag("abcdABC2")
-	require.NoError(t, err)
-	require.Equal(t, expected, val)
-}
-
-func Test_MEM_Close(t *testing.T) {
-	db := NewRAM()
-	db.testPrepare(t)
-	err := db.Close()
-	require.NoError(t, err)
-	require.Equal(t, ram{}, *db)
-}
-
-func Test_MEM_Ping(t *testing.T) {
-	db := NewRAM()
-	db.testPrepare(t)
-	err := db.Ping()
-	require.NoError(t, err)
-}
-
-func Test_MEM_Delete(t *testing.T) {
-	r := models.ClientData{
-		Cookie: "cookie2",
-		Key:    "secret_key2",
-		Short: []models.ShortData{
-			{
-				Short:   "abcdABC2",
-				Long:    "http://example2.org",
-				Deleted: true,
-			},
-		},
-	}
-	db := NewRAM()
-	db.testPrepare(t)
-	task := models.DelWorker{
-		Cookie: "cookie2",
-		Tags:   []string{"abcdABC2"},
-	}
-	db.deleteTag(task)
-	time.Sleep(5 * time.Second)
-	d, err := db.ReadByCookie("cookie2")
-	require.NoError(t, err)
-	require.Equal(t, r, d)
-
-}
+package storage
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/t1mon-ggg/go_shortner/app/models"
+)
+
+func (data *ram) testPrepare(t *testing.T) {
+	d := []models.ClientData{
+		{
+			Cookie: "cookie1",
+			Key:    "secret_key1",
+			Short: []models.ShortData{
+				{
+					Short:   "abcdABC1",
+					Long:    "http://example1.org",
+					Deleted: false,
+				},
+			},
+		},
+		{
+			Cookie: "cookie2",
+			Key:    "secret_key2",
+			Short: []models.ShortData{
+				{
+					Short:   "abcdABC2",
+					Long:    "http://example2.org",
+					Deleted: false,
+				},
+			},
+		},
+		{
+			Cookie: "cookie3",
+			Key:    "secret_key3",
+			Short: []models.ShortData{
+				{
+					Short:   "abcdABC3",
+					Long:    "http://example3.org",
+					Deleted: false,
+				},
+			},
+		},
+	}
+	for _, value := range d {
+		err := data.Write(context.Background(), value)
+		require.NoError(t, err)
+	}
+}
+
+func Test_MEM_Write(t *testing.T) {
+	db := NewRAM()
+	data := models.ClientData{
+		Cookie: "cookie1",
+		Key:    "secret_key",
+		Short: []models.ShortData{
+			{
+				Short: "Short1",
+				Long:  "Long1",
+			},
+		},
+	}
+	err := db.Write(context.Background(), data)
+	require.NoError(t, err)
+	val, err := db.ReadByCookie(context.Background(), "cookie1")
+	require.NoError(t, err)
+	require.Equal(t, data, val)
+	tag, err := db.TagByURL(context.Background(), "Long1", "cookie1")
+	require.NoError(t, err)
+	require.Equal(t, "Short1", tag)
+}
+
+func Test_MEM_ReadByCookie(t *testing.T) {
+	db := NewRAM()
+	db.testPrepare(t)
+	exp := models.ClientData{
+		Cookie: "cookie2",
+		Key:    "secret_key2",
+		Short: []models.ShortData{
+			{
+				Short: "abcdABC2",
+				Long:  "http://example2.org",
+			},
+		},
+	}
+	val, err := db.ReadByCookie(context.Background(), "cookie2")
+	require.NoError(t, err)
+	require.Equal(t, exp, val)
+}
+
+func Test_MEM_ReadByTag(t *testing.T) {
+	db := NewRAM()
+	db.testPrepare(t)
+	expected := models.ShortData{Short: "abcdABC2", Long: "http://example2.org"}
+	val, err := db.ReadByTag(context.Background(), "abcdABC2")
+	require.NoError(t, err)
+	require.Equal(t, expected, val)
+}
+
+func Test_MEM_Close(t *testing.T) {
+	db := NewRAM()
+	db.testPrepare(t)
+	err := db.Close()
+	require.NoError(t, err)
+	require.Equal(t, ram{}, *db)
+}
+
+func Test_MEM_Ping(t *testing.T) {
+	db := NewRAM()
+	db.testPrepare(t)
+	err := db.Ping(context.Background())
+	require.NoError(t, err)
+}
+
+func Test_MEM_Delete(t *testing.T) {
+	r := models.ClientData{
+		Cookie: "cookie2",
+		Key:    "secret_key2",
+		Short: []models.ShortData{
+			{
+				Short:   "abcdABC2",
+				Long:    "http://example2.org",
+				Deleted: true,
+			},
+		},
+	}
+	db := NewRAM()
+	db.testPrepare(t)
+	task := models.DelWorker{
+		Cookie: "cookie2",
+		Tags:   []string{"abcdABC2"},
+	}
+	db.deleteTag(task)
+	time.Sleep(5 * time.Second)
+	d, err := db.ReadByCookie(context.Background(), "cookie2")
+	require.NoError(t, err)
+	require.Equal(t, r, d)
+
+}
+
+func Test_MEM_Errors(t *testing.T) {
+	db := NewRAM()
+	db.testPrepare(t)
+	ctx := context.Background()
+	_, err := db.ReadByTag(ctx, "notexist")
+	require.ErrorIs(t, err, ErrNotFound)
+	_, err = db.ReadByCookie(ctx, "notexist")
+	require.ErrorIs(t, err, ErrNotFound)
+	_, err = db.TagByURL(ctx, "http://example1.org", "cookie2")
+	require.ErrorIs(t, err, ErrNotFound)
+	err = db.Write(ctx, models.ClientData{Cookie: "cookie1", Short: []models.ShortData{{Short: "abcdABC4", Long: "http://example1.org"}}})
+	require.ErrorIs(t, err, ErrConflict)
+	require.NotErrorIs(t, err, ErrTagTaken)
+	err = db.Write(ctx, models.ClientData{Cookie: "cookie2", Short: []models.ShortData{{Short: "abcdABC1", Long: "http://example9.org"}}})
+	require.ErrorIs(t, err, ErrTagTaken)
+	require.ErrorIs(t, err, ErrConflict)
+	//same url twice in one write, like unique index of SQL storages
+	err = db.Write(ctx, models.ClientData{Cookie: "cookie3", Short: []models.ShortData{{Short: "dupTag01", Long: "http://dup.org"}, {Short: "dupTag02", Long: "http://dup.org"}}})
+	require.ErrorIs(t, err, ErrConflict)
+	_, err = db.ReadByTag(ctx, "dupTag01")
+	require.ErrorIs(t, err, ErrNotFound)
+	db.deleteTag(models.DelWorker{Cookie: "cookie1", Tags: []string{"abcdABC1"}})
+	_, err = db.ReadByTag(ctx, "abcdABC1")
+	require.ErrorIs(t, err, ErrDeleted)
+}
+
+//newBenchRAM - in memory storage filled with n links of n/10 users
+func newBenchRAM(b *testing.B, n int) (*ram, []string) {
+	db := NewRAM()
+	tags := make([]string, 0, n)
+	users := n / 10
+	for i := 0; i < users; i++ {
+		entry := models.ClientData{Cookie: fmt.Sprintf("cookie%d", i), Key: "secret_key"}
+		for j := 0; j < 10; j++ {
+			tag := fmt.Sprintf("%08d", i*10+j)
+			entry.Short = append(entry.Short, models.ShortData{Short: tag, Long: fmt.Sprintf("http://example%d.org", j)})
+			tags = append(tags, tag)
+		}
+		err := db.Write(context.Background(), entry)
+		require.NoError(b, err)
+	}
+	return db, tags
+}
+
+func Benchmark_MEM_ReadByTag(b *testing.B) {
+	for _, n := range []int{1000, 100000, 1000000} {
+		db, tags := newBenchRAM(b, n)
+		b.Run(fmt.Sprintf("links_%d", n), func(b *testing.B) {
+			ctx := context.Background()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, err := db.ReadByTag(ctx, tags[i%len(tags)])
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func Benchmark_MEM_TagByURL(b *testing.B) {
+	for _, n := range []int{1000, 100000, 1000000} {
+		db, _ := newBenchRAM(b, n)
+		users := n / 10
+		cookies := make([]string, 0, users)
+		for i := 0; i < users; i++ {
+			cookies = append(cookies, fmt.Sprintf("cookie%d", i))
+		}
+		b.Run(fmt.Sprintf("links_%d", n), func(b *testing.B) {
+			ctx := context.Background()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, err := db.TagByURL(ctx, "http://example5.org", cookies[i%users])
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func Benchmark_MEM_Write(b *testing.B) {
+	for _, n := range []int{1000, 100000, 1000000} {
+		db, _ := newBenchRAM(b, n)
+		seq := 0
+		b.Run(fmt.Sprintf("links_%d", n), func(b *testing.B) {
+			ctx := context.Background()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				seq++
+				entry := models.ClientData{Cookie: "bench", Short: []models.ShortData{{Short: fmt.Sprintf("w%07d", seq), Long: fmt.Sprintf("http://bench%d.org", seq)}}}
+				err := db.Write(ctx, entry)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+//checkExpired - проверка поиска просроченных ссылок для любого хранилища
+func checkExpired(t *testing.T, s Storage) {
+	ctx := context.Background()
+	now := time.Now().UTC()
+	data := models.ClientData{
+		Cookie: "cookie_exp",
+		Key:    "secret_key",
+		Short: []models.ShortData{
+			{Short: "expired1", Long: "http://expired1.org", Expires: now.Add(-time.Hour)},
+			{Short: "expired2", Long: "http://expired2.org", Expires: now.Add(-time.Minute), Deleted: true},
+			{Short: "expired3", Long: "http://expired3.org", Expires: now.Add(time.Hour)},
+			{Short: "expired4", Long: "http://expired4.org"},
+		},
+	}
+	err := s.Write(ctx, data)
+	require.NoError(t, err)
+	user, err := s.ReadByCookie(ctx, "cookie_exp")
+	require.NoError(t, err)
+	for i := range data.Short {
+		require.True(t, data.Short[i].Expires.Equal(user.Short[i].Expires))
+	}
+	tasks, err := s.Expired(ctx, now)
+	require.NoError(t, err)
+	require.Equal(t, []models.DelWorker{{Cookie: "cookie_exp", Tags: []string{"expired1"}}}, tasks)
+	tasks, err = s.Expired(ctx, now.Add(2*time.Hour))
+	require.NoError(t, err)
+	require.Len(t, tasks, 1)
+	require.ElementsMatch(t, []string{"expired1", "expired3"}, tasks[0].Tags)
+	//просроченная ссылка не находится по url и не мешает повторному сокращению url
+	_, err = s.TagByURL(ctx, "http://expired1.org", "cookie_exp")
+	require.ErrorIs(t, err, ErrNotFound)
+	tag, err := s.TagByURL(ctx, "http://expired3.org", "cookie_exp")
+	require.NoError(t, err)
+	require.Equal(t, "expired3", tag)
+	err = s.Write(ctx, models.ClientData{Cookie: "cookie_exp", Key: "secret_key", Short: []models.ShortData{{Short: "renewed1", Long: "http://expired1.org"}}})
+	require.NoError(t, err)
+	tag, err = s.TagByURL(ctx, "http://expired1.org", "cookie_exp")
+	require.NoError(t, err)
+	require.Equal(t, "renewed1", tag)
+	_, err = s.ReadByTag(ctx, "expired1")
+	require.ErrorIs(t, err, ErrDeleted)
+	err = s.Write(ctx, models.ClientData{Cookie: "cookie_exp", Key: "secret_key", Short: []models.ShortData{{Short: "renewed3", Long: "http://expired3.org"}}})
+	require.ErrorIs(t, err, ErrConflict)
+}
+
+func Test_MEM_Expired(t *testing.T) {
+	db := NewRAM()
+	db.testPrepare(t)
+	checkExpired(t, db)
+}
+
+//checkCounts - проверка подсчета ссылок и пользователей для любого хранилища
+func checkCounts(t *testing.T, s Storage) {
+	ctx := context.Background()
+	urls, err := s.CountURLs(ctx)
+	require.NoError(t, err)
+	users, err := s.CountUsers(ctx)
+	require.NoError(t, err)
+	err = s.Write(ctx, models.ClientData{
+		Cookie: "cookie_count",
+		Key:    "secret_key",
+		Short: []models.ShortData{
+			{Short: "counted1", Long: "http://counted1.org"},
+			{Short: "counted2", Long: "http://counted2.org", Deleted: true},
+		},
+	})
+	require.NoError(t, err)
+	count, err := s.CountURLs(ctx)
+	require.NoError(t, err)
+	require.Equal(t, urls+1, count)
+	count, err = s.CountUsers(ctx)
+	require.NoError(t, err)
+	require.Equal(t, users+1, count)
+}
+
+func Test_MEM_Counts(t *testing.T) {
+	db := NewRAM()
+	db.testPrepare(t)
+	checkCounts(t, db)
+}
+
+func checkWriteBatch(t *testing.T, s Storage) {
+	ctx := context.Background()
+	urls, err := s.CountURLs(ctx)
+	require.NoError(t, err)
+	_, err = s.WriteBatch(ctx, models.ClientData{Cookie: "cookie1", Short: []models.ShortData{
+		{Short: "batchTag1", Long: "http://batch1.org"},
+		{Short: "abcdABC2", Long: "http://batch2.org"},
+	}})
+	var batchErr *BatchError
+	require.ErrorAs(t, err, &batchErr)
+	require.Equal(t, 1, batchErr.Index)
+	require.ErrorIs(t, err, ErrTagTaken)
+	_, err = s.ReadByTag(ctx, "batchTag1")
+	require.ErrorIs(t, err, ErrNotFound)
+
+	result, err := s.WriteBatch(ctx, models.ClientData{Cookie: "cookie1", Short: []models.ShortData{
+		{Short: "batchTag1", Long: "http://batch1.org"},
+		{Short: "batchTag2", Long: "http://example1.org"},
+		{Short: "batchTag3", Long: "http://batch1.org"},
+	}})
+	require.NoError(t, err)
+	require.Equal(t, []WriteResult{
+		{Tag: "batchTag1"},
+		{Tag: "abcdABC1", Existed: true},
+		{Tag: "batchTag1", Existed: true},
+	}, result)
+	count, err := s.CountURLs(ctx)
+	require.NoError(t, err)
+	require.Equal(t, urls+1, count)
+	data, err := s.ReadByTag(ctx, "batchTag1")
+	require.NoError(t, err)
+	require.Equal(t, "http://batch1.org", data.Long)
+	_, err = s.ReadByTag(ctx, "batchTag2")
+	require.ErrorIs(t, err, ErrNotFound)
+
+	users, err := s.CountUsers(ctx)
+	require.NoError(t, err)
+	result, err = s.WriteBatch(ctx, models.ClientData{Cookie: "cookie_batch", Key: "secret_key", Short: []models.ShortData{
+		{Short: "batchTag4", Long: "http://batch1.org"},
+	}})
+	require.NoError(t, err)
+	require.Equal(t, []WriteResult{{Tag: "batchTag4"}}, result)
+	user, err := s.ReadByCookie(ctx, "cookie_batch")
+	require.NoError(t, err)
+	require.Equal(t, "secret_key", user.Key)
+	count, err = s.CountUsers(ctx)
+	require.NoError(t, err)
+	require.Equal(t, users+1, count)
+
+	//ссылка с истекшим сроком действия не считается ранее сокращенной
+	err = s.Write(ctx, models.ClientData{Cookie: "cookie_batch", Short: []models.ShortData{
+		{Short: "batchOld5", Long: "http://batch5.org", Expires: time.Now().Add(-time.Minute)},
+	}})
+	require.NoError(t, err)
+	result, err = s.WriteBatch(ctx, models.ClientData{Cookie: "cookie_batch", Short: []models.ShortData{
+		{Short: "batchTag5", Long: "http://batch5.org"},
+	}})
+	require.NoError(t, err)
+	require.Equal(t, []WriteResult{{Tag: "batchTag5"}}, result)
+	tag, err := s.TagByURL(ctx, "http://batch5.org", "cookie_batch")
+	require.NoError(t, err)
+	require.Equal(t, "batchTag5", tag)
+	_, err = s.ReadByTag(ctx, "batchOld5")
+	require.ErrorIs(t, err, ErrDeleted)
+}
+
+func Test_MEM_WriteBatch(t *testing.T) {
+	db := NewRAM()
+	db.testPrepare(t)
+	checkWriteBatch(t, db)
+}
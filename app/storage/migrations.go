@@ -0,0 +1,270 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//migrationsFS - versioned schema scripts for every SQL dialect
+//go:embed migrations
+var migrationsFS embed.FS
+
+//SQL queries for migrations bookkeeping
+const (
+	migrationsSchema = `CREATE TABLE IF NOT EXISTS "schema_migrations" (
+		"version" integer NOT NULL PRIMARY KEY,
+		"name" varchar(255) NOT NULL,
+		"applied_at" timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`
+	migrationsSelect = `SELECT "version", "applied_at" FROM "schema_migrations" ORDER BY "version"`
+	migrationsInsert = `INSERT INTO "schema_migrations" ("version", "name") VALUES ($1, $2)`
+	migrationsDelete = `DELETE FROM "schema_migrations" WHERE "version"=$1`
+	migrationsLock   = `SELECT pg_advisory_lock($1)`
+	migrationsUnlock = `SELECT pg_advisory_unlock($1)`
+)
+
+//migrationsLockKey - ключ advisory блокировки PostgreSQL, общий для всех экземпляров сервиса
+const migrationsLockKey = 7286470213
+
+//migrationFile - migration script file name: <version>_<name>.<up|down>.sql
+var migrationFile = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+//Migrator - storage with versioned database schema
+type Migrator interface {
+	Migrate(context.Context) error                              //apply all pending migrations
+	Rollback(context.Context, int) error                        //revert last applied migrations
+	MigrationStatus(context.Context) ([]MigrationStatus, error) //list known migrations with their state
+	Close() error                                               //close storage pointer
+}
+
+//MigrationStatus - state of one schema migration
+type MigrationStatus struct {
+	Version   int       //Version - migration number
+	Name      string    //Name - migration name
+	Applied   bool      //Applied - migration is applied to database
+	AppliedAt time.Time //AppliedAt - time of migration apply
+}
+
+//migration - up and down scripts of one schema version
+type migration struct {
+	version int
+	name    string
+	up      string
+	down    string
+}
+
+//loadMigrations - чтение миграций диалекта, отсортированных по версии
+func loadMigrations(fsys fs.FS, dir string) ([]migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int]*migration)
+	for _, entry := range entries {
+		parts := migrationFile.FindStringSubmatch(entry.Name())
+		if parts == nil {
+			continue
+		}
+		version, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		script, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &migration{version: version, name: parts[2]}
+			byVersion[version] = m
+		}
+		if m.name != parts[2] {
+			return nil, fmt.Errorf("migration %d has different names: %s and %s", version, m.name, parts[2])
+		}
+		if parts[3] == "up" {
+			m.up = string(script)
+		} else {
+			m.down = string(script)
+		}
+	}
+	result := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up script", m.version, m.name)
+		}
+		result = append(result, *m)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].version < result[j].version })
+	return result, nil
+}
+
+//sqliteDriver - имя драйвера SQLite
+const sqliteDriver = "sqlite3"
+
+//withMigrationsLock - выполнение fn на одном соединении под блокировкой миграций, чтобы одновременно
+//запущенные экземпляры не применяли одну версию дважды: pg_advisory_lock для PostgreSQL,
+//для SQLite все миграции выполняются в одной транзакции BEGIN IMMEDIATE
+func (s *postgres) withMigrationsLock(ctx context.Context, fn func(*sql.Conn) error) error {
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if s.driver == sqliteDriver {
+		_, err = conn.ExecContext(ctx, "BEGIN IMMEDIATE")
+		if err != nil {
+			return err
+		}
+		err = fn(conn)
+		if err != nil {
+			conn.ExecContext(context.Background(), "ROLLBACK")
+			return err
+		}
+		_, err = conn.ExecContext(ctx, "COMMIT")
+		return err
+	}
+	_, err = conn.ExecContext(ctx, migrationsLock, migrationsLockKey)
+	if err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.Background(), migrationsUnlock, migrationsLockKey)
+	return fn(conn)
+}
+
+//applied - чтение примененных миграций
+func (s *postgres) applied(ctx context.Context, conn *sql.Conn) (map[int]time.Time, error) {
+	_, err := conn.ExecContext(ctx, migrationsSchema)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := conn.QueryContext(ctx, migrationsSelect)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		err := rows.Scan(&version, &appliedAt)
+		if err != nil {
+			return nil, err
+		}
+		result[version] = appliedAt
+	}
+	return result, rows.Err()
+}
+
+//runMigration - выполнение скрипта миграции и учет версии в одной транзакции,
+//для SQLite транзакция уже открыта блокировкой миграций
+func (s *postgres) runMigration(ctx context.Context, conn *sql.Conn, script, bookkeeping string, args ...interface{}) error {
+	if s.driver == sqliteDriver {
+		_, err := conn.ExecContext(ctx, script)
+		if err != nil {
+			return err
+		}
+		_, err = conn.ExecContext(ctx, bookkeeping, args...)
+		return err
+	}
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, script)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, bookkeeping, args...)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+//Migrate - применение всех новых миграций
+func (s *postgres) Migrate(ctx context.Context) error {
+	migrations, err := loadMigrations(migrationsFS, s.migrations)
+	if err != nil {
+		return err
+	}
+	return s.withMigrationsLock(ctx, func(conn *sql.Conn) error {
+		applied, err := s.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, m := range migrations {
+			if _, ok := applied[m.version]; ok {
+				continue
+			}
+			log.Printf("Applying migration %04d_%s\n", m.version, m.name)
+			err = s.runMigration(ctx, conn, m.up, migrationsInsert, m.version, m.name)
+			if err != nil {
+				return fmt.Errorf("migration %04d_%s failed: %w", m.version, m.name, err)
+			}
+		}
+		return nil
+	})
+}
+
+//Rollback - откат последних примененных миграций
+func (s *postgres) Rollback(ctx context.Context, steps int) error {
+	migrations, err := loadMigrations(migrationsFS, s.migrations)
+	if err != nil {
+		return err
+	}
+	return s.withMigrationsLock(ctx, func(conn *sql.Conn) error {
+		applied, err := s.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+			m := migrations[i]
+			if _, ok := applied[m.version]; !ok {
+				continue
+			}
+			if m.down == "" {
+				return fmt.Errorf("migration %04d_%s has no down script", m.version, m.name)
+			}
+			log.Printf("Reverting migration %04d_%s\n", m.version, m.name)
+			err = s.runMigration(ctx, conn, m.down, migrationsDelete, m.version)
+			if err != nil {
+				return fmt.Errorf("rollback of %04d_%s failed: %w", m.version, m.name, err)
+			}
+			steps--
+		}
+		return nil
+	})
+}
+
+//MigrationStatus - состояние всех известных миграций
+func (s *postgres) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	migrations, err := loadMigrations(migrationsFS, s.migrations)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	applied, err := s.applied(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		appliedAt, ok := applied[m.version]
+		result = append(result, MigrationStatus{Version: m.version, Name: m.name, Applied: ok, AppliedAt: appliedAt})
+	}
+	return result, nil
+}
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS urls_long_idx;
+DROP TABLE IF EXISTS "urls";
+DROP TABLE IF EXISTS "ids";
@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS "ids" (
+	"cookie" VARCHAR(32) NOT NULL UNIQUE PRIMARY KEY,
+	"key" VARCHAR(64) NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS "urls" (
+	"id" int4 NOT NULL PRIMARY KEY UNIQUE GENERATED ALWAYS AS IDENTITY (
+		INCREMENT 1
+		MINVALUE 1
+		MAXVALUE 2147483647
+		START 1
+		CACHE 1
+	),
+	"short" varchar(8) NOT NULL UNIQUE,
+	"long" varchar(255) NOT NULL,
+	"cookie" varchar(32) NOT NULL,
+	"deleted" bool NOT NULL DEFAULT false,
+	CONSTRAINT "cookie" FOREIGN KEY ("cookie") REFERENCES "ids" ("cookie") ON DELETE NO ACTION ON UPDATE NO ACTION
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS urls_long_idx ON "urls" ("long" text_ops,"cookie" text_ops) WHERE "deleted"=false;
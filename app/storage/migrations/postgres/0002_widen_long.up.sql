@@ -0,0 +1 @@
+ALTER TABLE "urls" ALTER COLUMN "long" TYPE text;
@@ -0,0 +1 @@
+ALTER TABLE "urls" ALTER COLUMN "short" TYPE varchar(8);
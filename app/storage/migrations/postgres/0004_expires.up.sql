@@ -0,0 +1,3 @@
+ALTER TABLE "urls" ADD COLUMN "expires_at" timestamptz NULL;
+
+CREATE INDEX IF NOT EXISTS urls_expires_idx ON "urls" ("expires_at") WHERE "deleted"=false AND "expires_at" IS NOT NULL;
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS urls_long_hash_idx;
+
+DROP INDEX IF EXISTS urls_long_idx;
+
+CREATE UNIQUE INDEX IF NOT EXISTS urls_long_idx ON "urls" ("long" text_ops,"cookie" text_ops) WHERE "deleted"=false;
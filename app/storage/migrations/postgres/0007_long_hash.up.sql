@@ -0,0 +1,7 @@
+-- btree index entries are limited to about 2.7KB, so urls are kept unique by their md5 hash
+DROP INDEX IF EXISTS urls_long_idx;
+
+CREATE UNIQUE INDEX IF NOT EXISTS urls_long_idx ON "urls" (md5("long"),"cookie") WHERE "deleted"=false;
+
+-- hash index entries do not depend on url length, the index serves lookups by url
+CREATE INDEX IF NOT EXISTS urls_long_hash_idx ON "urls" USING hash ("long");
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS "ids" (
+	"cookie" VARCHAR(32) NOT NULL UNIQUE PRIMARY KEY,
+	"key" VARCHAR(64) NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS "urls" (
+	"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+	"short" varchar(8) NOT NULL UNIQUE,
+	"long" varchar(255) NOT NULL,
+	"cookie" varchar(32) NOT NULL,
+	"deleted" bool NOT NULL DEFAULT false,
+	CONSTRAINT "cookie" FOREIGN KEY ("cookie") REFERENCES "ids" ("cookie") ON DELETE NO ACTION ON UPDATE NO ACTION
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS urls_long_idx ON "urls" ("long","cookie") WHERE "deleted"=false;
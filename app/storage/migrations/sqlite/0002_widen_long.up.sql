@@ -0,0 +1,2 @@
+-- SQLite does not enforce varchar length, "long" already accepts urls of any size
+SELECT 1;
@@ -0,0 +1,2 @@
+-- SQLite does not enforce varchar length, nothing to revert
+SELECT 1;
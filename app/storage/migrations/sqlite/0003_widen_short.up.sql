@@ -0,0 +1,2 @@
+-- SQLite does not enforce varchar length, "short" already accepts custom aliases
+SELECT 1;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS urls_expires_idx;
+
+ALTER TABLE "urls" DROP COLUMN "expires_at";
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS clicks_short_idx;
+DROP TABLE IF EXISTS "clicks";
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS "clicks" (
+	"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+	"short" varchar(32) NOT NULL,
+	"clicked_at" timestamp NOT NULL,
+	"referrer" text NOT NULL DEFAULT '',
+	"user_agent" text NOT NULL DEFAULT '',
+	"ip_hash" varchar(64) NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS clicks_short_idx ON "clicks" ("short", "clicked_at");
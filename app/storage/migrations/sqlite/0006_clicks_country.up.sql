@@ -0,0 +1 @@
+ALTER TABLE "clicks" ADD COLUMN "country" varchar(8) NOT NULL DEFAULT '';
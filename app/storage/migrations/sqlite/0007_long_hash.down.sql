@@ -0,0 +1,2 @@
+-- SQLite index is not changed, nothing to revert
+SELECT 1;
@@ -0,0 +1,2 @@
+-- SQLite index entries are not limited in size, urls_long_idx already accepts urls of any size
+SELECT 1;
@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_loadMigrations(t *testing.T) {
+	fsys := fstest.MapFS{
+		"m/0002_second.up.sql":   {Data: []byte("up2")},
+		"m/0002_second.down.sql": {Data: []byte("down2")},
+		"m/0001_first.up.sql":    {Data: []byte("up1")},
+		"m/0001_first.down.sql":  {Data: []byte("down1")},
+		"m/README.md":            {Data: []byte("skipped")},
+	}
+	migrations, err := loadMigrations(fsys, "m")
+	require.NoError(t, err)
+	require.Equal(t, []migration{
+		{version: 1, name: "first", up: "up1", down: "down1"},
+		{version: 2, name: "second", up: "up2", down: "down2"},
+	}, migrations)
+	fsys["m/0003_broken.down.sql"] = &fstest.MapFile{Data: []byte("down3")}
+	_, err = loadMigrations(fsys, "m")
+	require.Error(t, err)
+}
+
+func Test_SQLite_Migrations(t *testing.T) {
+	ctx := context.Background()
+	s, err := OpenSQLite(filepath.Join(t.TempDir(), "migrations.sqlite"))
+	require.NoError(t, err)
+	defer s.Close()
+	known, err := loadMigrations(migrationsFS, s.migrations)
+	require.NoError(t, err)
+	status, err := s.MigrationStatus(ctx)
+	require.NoError(t, err)
+	require.Len(t, status, len(known))
+	for _, m := range status {
+		require.False(t, m.Applied)
+	}
+	require.NoError(t, s.Migrate(ctx))
+	require.NoError(t, s.Migrate(ctx))
+	status, err = s.MigrationStatus(ctx)
+	require.NoError(t, err)
+	for _, m := range status {
+		require.True(t, m.Applied)
+	}
+	require.NoError(t, s.Rollback(ctx, 1))
+	status, err = s.MigrationStatus(ctx)
+	require.NoError(t, err)
+	require.False(t, status[len(status)-1].Applied)
+	require.NoError(t, s.Rollback(ctx, len(known)))
+	_, err = s.db.ExecContext(ctx, `SELECT COUNT(*) FROM "urls"`)
+	require.Error(t, err)
+	require.NoError(t, s.Migrate(ctx))
+	require.NoError(t, s.prepare())
+	_, err = s.ReadByTag(ctx, "notexist")
+	require.ErrorIs(t, err, ErrNotFound)
+}
+
+func Test_SQLite_ConcurrentMigrations(t *testing.T) {
+	ctx := context.Background()
+	name := filepath.Join(t.TempDir(), "concurrent.sqlite")
+	wg := &sync.WaitGroup{}
+	errs := make(chan error, 4)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s, err := OpenSQLite(name)
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer s.Close()
+			errs <- s.Migrate(ctx)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+	s, err := OpenSQLite(name)
+	require.NoError(t, err)
+	defer s.Close()
+	status, err := s.MigrationStatus(ctx)
+	require.NoError(t, err)
+	for _, m := range status {
+		require.True(t, m.Applied)
+	}
+}
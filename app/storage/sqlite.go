@@ -0,0 +1,41 @@
+package storage
+
+import (
+	"log"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+//sqlite - SQLite storage, shares queries and logic with postgres
+type sqlite struct {
+	postgres
+}
+
+//NewSQLite - создание хранилища SQLite в файле, применение миграций
+func NewSQLite(name string, timeouts Timeouts) (*sqlite, error) {
+	db, err := OpenSQLite(name)
+	if err != nil {
+		return nil, err
+	}
+	db.timeouts = timeouts
+	err = db.create()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+//OpenSQLite - открытие файла SQLite без применения миграций
+func OpenSQLite(name string) (*sqlite, error) {
+	log.Println("SQLite file:", name)
+	db := sqlite{postgres{conn: name, driver: sqliteDriver, migrations: "migrations/sqlite", daily: statsDailySQLite, timeouts: DefaultTimeouts}}
+	err := db.open()
+	if err != nil {
+		return nil, err
+	}
+	//SQLite allows only one writer at a time
+	db.db.SetMaxOpenConns(1)
+	log.Println("Successfull connection to SQLite")
+	return &db, nil
+}
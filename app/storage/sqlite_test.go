@@ -0,0 +1,210 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/t1mon-ggg/go_shortner/app/models"
+)
+
+func newTestSQLite(t *testing.T) *sqlite {
+	s, err := NewSQLite(filepath.Join(t.TempDir(), "shortener.sqlite"), DefaultTimeouts)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	data := []models.ClientData{
+		{
+			Cookie: "cookie1",
+			Key:    "secret_key1",
+			Short: []models.ShortData{
+				{
+					Short: "abcdABC1",
+					Long:  "http://example1.org",
+				},
+			},
+		},
+		{
+			Cookie: "cookie2",
+			Key:    "secret_key2",
+			Short: []models.ShortData{
+				{
+					Short: "abcdABC2",
+					Long:  "http://example2.org",
+				},
+			},
+		},
+	}
+	for _, value := range data {
+		err := s.Write(context.Background(), value)
+		require.NoError(t, err)
+	}
+	return s
+}
+
+func Test_SQLite_Ping(t *testing.T) {
+	s := newTestSQLite(t)
+	err := s.Ping(context.Background())
+	require.NoError(t, err)
+}
+
+func Test_SQLite_ReadByCookie(t *testing.T) {
+	s := newTestSQLite(t)
+	exp := models.ClientData{
+		Cookie: "cookie2",
+		Key:    "secret_key2",
+		Short: []models.ShortData{
+			{
+				Short: "abcdABC2",
+				Long:  "http://example2.org",
+			},
+		},
+	}
+	data, err := s.ReadByCookie(context.Background(), "cookie2")
+	require.NoError(t, err)
+	require.Equal(t, exp, data)
+	_, err = s.ReadByCookie(context.Background(), "notexist")
+	require.ErrorIs(t, err, ErrNotFound)
+}
+
+func Test_SQLite_ReadByTag(t *testing.T) {
+	s := newTestSQLite(t)
+	exp := models.ShortData{
+		Short: "abcdABC1",
+		Long:  "http://example1.org",
+	}
+	data, err := s.ReadByTag(context.Background(), "abcdABC1")
+	require.NoError(t, err)
+	require.Equal(t, exp, data)
+	_, err = s.ReadByTag(context.Background(), "notexist")
+	require.ErrorIs(t, err, ErrNotFound)
+}
+
+func Test_SQLite_Conflict(t *testing.T) {
+	s := newTestSQLite(t)
+	ctx := context.Background()
+	err := s.Write(ctx, models.ClientData{Cookie: "cookie1", Short: []models.ShortData{{Short: "abcdABC3", Long: "http://example1.org"}}})
+	require.ErrorIs(t, err, ErrConflict)
+	require.NotErrorIs(t, err, ErrTagTaken)
+	err = s.Write(ctx, models.ClientData{Cookie: "cookie2", Short: []models.ShortData{{Short: "abcdABC1", Long: "http://example9.org"}}})
+	require.ErrorIs(t, err, ErrTagTaken)
+	tag, err := s.TagByURL(ctx, "http://example1.org", "cookie1")
+	require.NoError(t, err)
+	require.Equal(t, "abcdABC1", tag)
+	err = s.Write(ctx, models.ClientData{Cookie: "cookie2", Short: []models.ShortData{{Short: "abcdABC4", Long: "http://example1.org"}}})
+	require.NoError(t, err)
+}
+
+//checkLongURL - проверка записи и поиска ссылки наибольшей допустимой длины для SQL хранилищ
+func checkLongURL(t *testing.T, s Storage, cookie string) {
+	ctx := context.Background()
+	long := "http://example.org/" + strings.Repeat("a", 8192-len("http://example.org/"))
+	tag := cookie[:12]
+	err := s.Write(ctx, models.ClientData{Cookie: cookie, Key: "secret_key", Short: []models.ShortData{{Short: tag, Long: long}}})
+	require.NoError(t, err)
+	err = s.Write(ctx, models.ClientData{Cookie: cookie, Key: "secret_key", Short: []models.ShortData{{Short: tag + "_2", Long: long}}})
+	require.ErrorIs(t, err, ErrConflict)
+	found, err := s.TagByURL(ctx, long, cookie)
+	require.NoError(t, err)
+	require.Equal(t, tag, found)
+	result, err := s.WriteBatch(ctx, models.ClientData{Cookie: cookie, Key: "secret_key", Short: []models.ShortData{{Short: tag + "_3", Long: long}}})
+	require.NoError(t, err)
+	require.Equal(t, []WriteResult{{Tag: tag, Existed: true}}, result)
+}
+
+func Test_SQLite_LongURL(t *testing.T) {
+	s := newTestSQLite(t)
+	checkLongURL(t, s, "cookie_long_url_sqlite")
+}
+
+func Test_SQLite_Delete(t *testing.T) {
+	s := newTestSQLite(t)
+	ctx := context.Background()
+	s.deleteTag(models.DelWorker{Cookie: "cookie2", Tags: []string{"abcdABC2"}})
+	data, err := s.ReadByTag(ctx, "abcdABC2")
+	require.ErrorIs(t, err, ErrDeleted)
+	require.True(t, data.Deleted)
+	_, err = s.TagByURL(ctx, "http://example2.org", "cookie2")
+	require.ErrorIs(t, err, ErrNotFound)
+	err = s.Write(ctx, models.ClientData{Cookie: "cookie2", Short: []models.ShortData{{Short: "abcdABC5", Long: "http://example2.org"}}})
+	require.NoError(t, err)
+}
+
+//checkInjection - запись и чтение значений с кавычками и SQL, suffix делает теги и cookie уникальными для хранилища
+func checkInjection(t *testing.T, s Storage, suffix string) {
+	ctx := context.Background()
+	tests := []struct {
+		name   string
+		cookie string
+		short  string
+		long   string
+	}{
+		{
+			name:   "Single quote",
+			cookie: "cookie1" + suffix,
+			short:  "inject01" + suffix,
+			long:   "http://example.org/?q=it's",
+		},
+		{
+			name:   "Drop table",
+			cookie: "cookie1" + suffix,
+			short:  "inject02" + suffix,
+			long:   `http://example.org/'; DROP TABLE "urls"; --`,
+		},
+		{
+			name:   "Always true",
+			cookie: "cookie2" + suffix,
+			short:  "inject03" + suffix,
+			long:   `http://example.org/' OR '1'='1`,
+		},
+		{
+			name:   "Quoted cookie",
+			cookie: `cookie' OR '1'='1` + suffix,
+			short:  "inject04" + suffix,
+			long:   `http://example.org/"quoted"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.Write(ctx, models.ClientData{Cookie: tt.cookie, Key: "secret", Short: []models.ShortData{{Short: tt.short, Long: tt.long}}})
+			require.NoError(t, err)
+			tag, err := s.TagByURL(ctx, tt.long, tt.cookie)
+			require.NoError(t, err)
+			require.Equal(t, tt.short, tag)
+			data, err := s.ReadByTag(ctx, tt.short)
+			require.NoError(t, err)
+			require.Equal(t, tt.long, data.Long)
+			user, err := s.ReadByCookie(ctx, tt.cookie)
+			require.NoError(t, err)
+			require.Contains(t, user.Short, models.ShortData{Short: tt.short, Long: tt.long})
+		})
+	}
+	_, err := s.ReadByTag(ctx, tests[0].short)
+	require.NoError(t, err)
+	_, err = s.ReadByCookie(ctx, `cookie1' OR '1'='1`+suffix)
+	require.ErrorIs(t, err, ErrNotFound)
+}
+
+func Test_SQLite_Injection(t *testing.T) {
+	s := newTestSQLite(t)
+	checkInjection(t, s, "")
+	_, err := s.ReadByTag(context.Background(), "abcdABC1")
+	require.NoError(t, err)
+}
+
+func Test_SQLite_Expired(t *testing.T) {
+	s := newTestSQLite(t)
+	checkExpired(t, s)
+}
+
+func Test_SQLite_Counts(t *testing.T) {
+	s := newTestSQLite(t)
+	checkCounts(t, s)
+}
+
+func Test_SQLite_WriteBatch(t *testing.T) {
+	s := newTestSQLite(t)
+	checkWriteBatch(t, s)
+}
@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/t1mon-ggg/go_shortner/app/models"
+)
+
+//TransferOptions - параметры переноса данных между хранилищами
+type TransferOptions struct {
+	DryRun   bool //DryRun - чтение источника без записи в целевое хранилище
+	Progress int  //Progress - вывод прогресса через каждые Progress пользователей, 0 - без вывода
+}
+
+//TransferReport - итог переноса данных между хранилищами
+type TransferReport struct {
+	Users     int //Users - количество прочитанных пользователей
+	URLs      int //URLs - количество прочитанных ссылок
+	Deleted   int //Deleted - количество прочитанных удаленных ссылок
+	Written   int //Written - количество записанных ссылок
+	Conflicts int //Conflicts - количество ссылок, уже существующих в целевом хранилище
+}
+
+//Transfer - перенос всех пользователей и ссылок из src в dst с сохранением тегов, ключей и пометок удаления
+func Transfer(ctx context.Context, src, dst Storage, opts TransferOptions) (TransferReport, error) {
+	report := TransferReport{}
+	err := src.Export(ctx, func(data models.ClientData) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		report.Users++
+		report.URLs += len(data.Short)
+		for _, value := range data.Short {
+			if value.Deleted {
+				report.Deleted++
+			}
+		}
+		if !opts.DryRun {
+			err := transferUser(ctx, dst, data, &report)
+			if err != nil {
+				return err
+			}
+		}
+		if opts.Progress > 0 && report.Users%opts.Progress == 0 {
+			log.Printf("Transfer progress: %d users, %d urls, %d written, %d conflicts\n", report.Users, report.URLs, report.Written, report.Conflicts)
+		}
+		return nil
+	})
+	return report, err
+}
+
+//transferUser - запись пользователя и его ссылок по одной, чтобы конфликт одной ссылки не отменял остальные
+func transferUser(ctx context.Context, dst Storage, data models.ClientData, report *TransferReport) error {
+	err := dst.Write(ctx, models.ClientData{Cookie: data.Cookie, Key: data.Key, Short: []models.ShortData{}})
+	if err != nil {
+		return err
+	}
+	for _, value := range data.Short {
+		err := dst.Write(ctx, models.ClientData{Cookie: data.Cookie, Key: data.Key, Short: []models.ShortData{value}})
+		if errors.Is(err, ErrConflict) {
+			report.Conflicts++
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		report.Written++
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/t1mon-ggg/go_shortner/app/models"
+)
+
+func Test_Transfer(t *testing.T) {
+	ctx := context.Background()
+	src := NewRAM()
+	data := []models.ClientData{
+		{
+			Cookie: "cookie1",
+			Key:    "secret_key1",
+			Short: []models.ShortData{
+				{
+					Short: "abcdABC1",
+					Long:  "http://example1.org",
+				},
+				{
+					Short:   "abcdABC2",
+					Long:    "http://example2.org",
+					Deleted: true,
+				},
+			},
+		},
+		{
+			Cookie: "cookie2",
+			Key:    "secret_key2",
+			Short: []models.ShortData{
+				{
+					Short: "abcdABC3",
+					Long:  "http://example1.org",
+				},
+			},
+		},
+		{
+			Cookie: "cookie3",
+			Key:    "secret_key3",
+			Short:  []models.ShortData{},
+		},
+	}
+	for _, value := range data {
+		err := src.Write(ctx, value)
+		require.NoError(t, err)
+	}
+	dst, err := NewBolt(filepath.Join(t.TempDir(), "shortener.db"))
+	require.NoError(t, err)
+	defer dst.Close()
+
+	report, err := Transfer(ctx, src, dst, TransferOptions{DryRun: true})
+	require.NoError(t, err)
+	require.Equal(t, TransferReport{Users: 3, URLs: 3, Deleted: 1}, report)
+	_, err = dst.ReadByCookie(ctx, "cookie1")
+	require.ErrorIs(t, err, ErrNotFound)
+
+	report, err = Transfer(ctx, src, dst, TransferOptions{Progress: 1})
+	require.NoError(t, err)
+	require.Equal(t, TransferReport{Users: 3, URLs: 3, Deleted: 1, Written: 3}, report)
+	for _, value := range data {
+		user, err := dst.ReadByCookie(ctx, value.Cookie)
+		require.NoError(t, err)
+		require.Equal(t, value, user)
+	}
+	_, err = dst.ReadByTag(ctx, "abcdABC2")
+	require.ErrorIs(t, err, ErrDeleted)
+
+	report, err = Transfer(ctx, src, dst, TransferOptions{})
+	require.NoError(t, err)
+	require.Equal(t, TransferReport{Users: 3, URLs: 3, Deleted: 1, Conflicts: 3}, report)
+}
+
+func Test_SQLite_Export(t *testing.T) {
+	s := newTestSQLite(t)
+	ctx := context.Background()
+	err := s.Write(ctx, models.ClientData{Cookie: "cookie3", Key: "secret_key3", Short: []models.ShortData{}})
+	require.NoError(t, err)
+	err = s.Write(ctx, models.ClientData{Cookie: "cookie1", Short: []models.ShortData{{Short: "abcdABC3", Long: "http://example3.org", Deleted: true}}})
+	require.NoError(t, err)
+	exp := []models.ClientData{
+		{
+			Cookie: "cookie1",
+			Key:    "secret_key1",
+			Short: []models.ShortData{
+				{
+					Short: "abcdABC1",
+					Long:  "http://example1.org",
+				},
+				{
+					Short:   "abcdABC3",
+					Long:    "http://example3.org",
+					Deleted: true,
+				},
+			},
+		},
+		{
+			Cookie: "cookie2",
+			Key:    "secret_key2",
+			Short: []models.ShortData{
+				{
+					Short: "abcdABC2",
+					Long:  "http://example2.org",
+				},
+			},
+		},
+		{
+			Cookie: "cookie3",
+			Key:    "secret_key3",
+			Short:  []models.ShortData{},
+		},
+	}
+	result := make([]models.ClientData, 0)
+	err = s.Export(ctx, func(data models.ClientData) error {
+		result = append(result, data)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, exp, result)
+}
@@ -0,0 +1,246 @@
+package webhandlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/t1mon-ggg/go_shortner/app/service"
+)
+
+//batch content types
+const (
+	batchJSON   = "application/json"     //batchJSON - список передается JSON массивом
+	batchNDJSON = "application/x-ndjson" //batchNDJSON - список передается по одному JSON объекту в строке
+)
+
+//batchPath - путь пакетного сокращения ссылок, единственный путь с одновременным чтением запроса и записью ответа
+const batchPath = "/api/shorten/batch"
+
+//batchChunk - количество элементов списка, записываемых в хранилище за один раз
+const batchChunk = 1000
+
+//batchSpoolMemory - объем накопленных результатов в памяти, после которого они переносятся во временный файл
+const batchSpoolMemory = 1 << 20
+
+//spool - накопление результатов до отправки ответа: в памяти до limit байт, далее во временном файле
+type spool struct {
+	buf   bytes.Buffer
+	file  *os.File
+	limit int
+}
+
+//Write - накопление данных, при превышении limit накопленное переносится во временный файл
+func (s *spool) Write(p []byte) (int, error) {
+	if s.file == nil && s.buf.Len()+len(p) <= s.limit {
+		return s.buf.Write(p)
+	}
+	if s.file == nil {
+		file, err := os.CreateTemp("", "batch-*.json")
+		if err != nil {
+			return 0, err
+		}
+		s.file = file
+		_, err = s.buf.WriteTo(file)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return s.file.Write(p)
+}
+
+//WriteTo - отправка накопленных данных
+func (s *spool) WriteTo(w io.Writer) (int64, error) {
+	if s.file == nil {
+		return s.buf.WriteTo(w)
+	}
+	_, err := s.file.Seek(0, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+	return io.Copy(w, s.file)
+}
+
+//Close - удаление временного файла
+func (s *spool) Close() error {
+	s.buf.Reset()
+	if s.file == nil {
+		return nil
+	}
+	name := s.file.Name()
+	err := s.file.Close()
+	s.file = nil
+	if rerr := os.Remove(name); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+//batchDecoder - потоковое чтение элементов списка из тела запроса
+type batchDecoder struct {
+	dec   *json.Decoder
+	array bool //элементы передаются JSON массивом, иначе NDJSON
+}
+
+//newBatchDecoder - создание потокового декодера, для JSON массива читается открывающая скобка
+func newBatchDecoder(r io.Reader, array bool) (*batchDecoder, error) {
+	d := &batchDecoder{dec: json.NewDecoder(r), array: array}
+	if !array {
+		return d, nil
+	}
+	token, err := d.dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	if token != json.Delim('[') {
+		return nil, errors.New("JSON array expected")
+	}
+	return d, nil
+}
+
+//next - чтение следующего элемента списка, io.EOF по окончании списка
+func (d *batchDecoder) next(item *input) error {
+	if !d.array {
+		return d.dec.Decode(item)
+	}
+	if d.dec.More() {
+		return d.dec.Decode(item)
+	}
+	_, err := d.dec.Token()
+	if err != nil {
+		return err
+	}
+	return io.EOF
+}
+
+//chunk - чтение не более n элементов списка, io.EOF вместе с последней частью списка
+func (d *batchDecoder) chunk(n int) ([]service.BatchRequest, error) {
+	reqs := make([]service.BatchRequest, 0, n)
+	for len(reqs) < n {
+		var item input
+		err := d.next(&item)
+		if err != nil {
+			return reqs, err
+		}
+		reqs = append(reqs, service.BatchRequest{Correlation: item.Correlation, Request: service.Request{URL: item.Long, Alias: item.Alias, ExpiresAt: item.ExpiresAt, TTL: item.TTL}})
+	}
+	return reqs, nil
+}
+
+//batchEncoder - потоковая запись результатов обработки списка в ответ
+type batchEncoder struct {
+	w     http.ResponseWriter
+	dst   io.Writer //ответ или spool, если тело запроса недоступно после начала отправки ответа
+	array bool      //результаты передаются JSON массивом, иначе NDJSON
+	count int       //количество записанных результатов
+}
+
+//newBatchEncoder - установка заголовков ответа, без одновременного чтения запроса и записи ответа
+//результаты накапливаются в spool и отправляются после чтения всего списка, ресурсы освобождает release
+func newBatchEncoder(w http.ResponseWriter, array bool, code int, duplex bool) *batchEncoder {
+	e := &batchEncoder{w: w, dst: w, array: array}
+	if !duplex {
+		e.dst = &spool{limit: batchSpoolMemory}
+	}
+	if array {
+		w.Header().Set("Content-Type", batchJSON)
+	} else {
+		w.Header().Set("Content-Type", batchNDJSON)
+	}
+	w.WriteHeader(code)
+	if array {
+		e.dst.Write([]byte{'['})
+	}
+	return e
+}
+
+//batchFailure - последняя запись ответа, если обработка списка прервана ошибкой после начала отправки ответа
+type batchFailure struct {
+	Status string `json:"status" enums:"invalid"`
+	Error  string `json:"error"`
+}
+
+//write - запись результатов части списка и отправка их клиенту
+func (e *batchEncoder) write(out []output) error {
+	for _, item := range out {
+		err := e.item(item)
+		if err != nil {
+			return err
+		}
+	}
+	if f, ok := e.dst.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+//fail - запись причины прерывания обработки списка и завершение ответа
+func (e *batchEncoder) fail(reason string) error {
+	err := e.item(batchFailure{Status: service.StatusInvalid, Error: reason})
+	if err != nil {
+		return err
+	}
+	return e.close()
+}
+
+//item - запись одного элемента ответа
+func (e *batchEncoder) item(item interface{}) error {
+	line, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	switch {
+	case !e.array:
+		line = append(line, '\n')
+	case e.count > 0:
+		line = append([]byte{','}, line...)
+	}
+	_, err = e.dst.Write(line)
+	if err != nil {
+		return err
+	}
+	e.count++
+	return nil
+}
+
+//close - завершение списка результатов и отправка накопленных результатов
+func (e *batchEncoder) close() error {
+	if e.array {
+		_, err := e.dst.Write([]byte{']'})
+		if err != nil {
+			return err
+		}
+	}
+	if s, ok := e.dst.(*spool); ok {
+		_, err := s.WriteTo(e.w)
+		return err
+	}
+	return nil
+}
+
+//release - удаление накопленных результатов
+func (e *batchEncoder) release() {
+	if s, ok := e.dst.(*spool); ok {
+		s.Close()
+	}
+}
+
+//batchOutput - результаты части списка в формате ответа и код ответа для нее
+func batchOutput(results []service.BatchResult) ([]output, int) {
+	code := http.StatusOK
+	out := make([]output, 0, len(results))
+	for _, result := range results {
+		item := output{Correlation: result.Correlation, Short: result.ShortURL, Status: result.Status()}
+		if result.Err != nil {
+			item.Error = result.Err.Error()
+		}
+		if item.Status == service.StatusCreated {
+			code = http.StatusCreated
+		}
+		out = append(out, item)
+	}
+	return out, code
+}
@@ -0,0 +1,220 @@
+package webhandlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/t1mon-ggg/go_shortner/app/mymiddlewares"
+)
+
+//batchBody - JSON массив или NDJSON из n элементов списка, начиная с номера first
+func batchBody(t *testing.T, first, n int, array bool) string {
+	lines := make([]string, 0, n)
+	for i := first; i < first+n; i++ {
+		line, err := json.Marshal(input{Correlation: fmt.Sprint(i), Long: fmt.Sprintf("http://example%d.org", i)})
+		require.NoError(t, err)
+		lines = append(lines, string(line))
+	}
+	if array {
+		return "[" + strings.Join(lines, ",") + "]"
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func Test_BatchNDJSON(t *testing.T) {
+	jar, r, _ := newServer(t)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	ctype := map[string]string{"Content-Type": "application/x-ndjson"}
+	body := batchBody(t, 1, 2, false) + `{"correlation_id":"3","original_url":"http://example1.org"}` + "\n" + `{"correlation_id":"4","original_url":""}`
+	response, answer := testRequest(t, ts, jar, http.MethodPost, "/api/shorten/batch", body, ctype)
+	defer response.Body.Close()
+	require.Equal(t, http.StatusCreated, response.StatusCode)
+	require.Equal(t, "application/x-ndjson", response.Header.Get("Content-Type"))
+	out := []output{}
+	scanner := bufio.NewScanner(strings.NewReader(answer))
+	for scanner.Scan() {
+		var item output
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &item))
+		out = append(out, item)
+	}
+	require.Len(t, out, 4)
+	require.Equal(t, "created", out[0].Status)
+	require.Equal(t, "created", out[1].Status)
+	require.Equal(t, output{Correlation: "3", Short: out[0].Short, Status: "exists"}, out[2])
+	require.Equal(t, "invalid", out[3].Status)
+
+	response, _ = testRequest(t, ts, jar, http.MethodPost, "/api/shorten/batch", body, map[string]string{"Content-Type": "text/plain"})
+	defer response.Body.Close()
+	require.Equal(t, http.StatusBadRequest, response.StatusCode)
+}
+
+func Test_BatchStream(t *testing.T) {
+	jar, r, _ := newServer(t)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	n := 2*batchChunk + 10
+	tests := []struct {
+		name  string
+		ctype map[string]string
+	}{
+		{
+			name:  "Plain",
+			ctype: map[string]string{"Content-Type": "application/json; charset=utf-8"},
+		},
+		{
+			name:  "Gzip",
+			ctype: map[string]string{"Content-Type": "application/json", "Content-Encoding": "gzip"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, answer := testRequest(t, ts, jar, http.MethodPost, "/api/shorten/batch", batchBody(t, 0, n, true), tt.ctype)
+			defer response.Body.Close()
+			out := []output{}
+			require.NoError(t, json.Unmarshal([]byte(answer), &out))
+			require.Len(t, out, n)
+			for i, item := range out {
+				require.Equal(t, fmt.Sprint(i), item.Correlation)
+				require.NotEmpty(t, item.Short)
+			}
+		})
+	}
+	response, list := testRequest(t, ts, jar, http.MethodGet, "/api/user/urls", "", map[string]string{})
+	defer response.Body.Close()
+	urls := []answer{}
+	require.NoError(t, json.Unmarshal([]byte(list), &urls))
+	require.Len(t, urls, n)
+}
+
+func Test_BatchMalformed(t *testing.T) {
+	jar, r, _ := newServer(t)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	ctype := map[string]string{"Content-Type": "application/json"}
+	tests := []struct {
+		name string
+		body string
+	}{
+		{name: "Not an array", body: `{"correlation_id":"1","original_url":"http://example1.org"}`},
+		{name: "Broken item", body: `[{"correlation_id":"1","original_url":"http://example1.org"},{"correlation_id":`},
+		{name: "Wrong item", body: `[1]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, _ := testRequest(t, ts, jar, http.MethodPost, "/api/shorten/batch", tt.body, ctype)
+			defer response.Body.Close()
+			require.Equal(t, http.StatusBadRequest, response.StatusCode)
+		})
+	}
+
+	//broken item after the first chunk ends the answer with failure record
+	body := batchBody(t, 0, batchChunk+5, true)
+	body = body[:len(body)-1] + `,{"correlation_id":`
+	response, answer := testRequest(t, ts, jar, http.MethodPost, "/api/shorten/batch", body, ctype)
+	defer response.Body.Close()
+	require.Equal(t, http.StatusCreated, response.StatusCode)
+	out := []json.RawMessage{}
+	require.NoError(t, json.Unmarshal([]byte(answer), &out))
+	require.Len(t, out, batchChunk+1)
+	require.Equal(t, batchChunk, strings.Count(answer, `"status":"created"`))
+	failure := batchFailure{}
+	require.NoError(t, json.Unmarshal(out[batchChunk], &failure))
+	require.Equal(t, "invalid", failure.Status)
+	require.Contains(t, failure.Error, "Bad request")
+
+	body = batchBody(t, batchChunk+5, batchChunk+5, false) + `{"correlation_id":` + "\n"
+	response, answer = testRequest(t, ts, jar, http.MethodPost, "/api/shorten/batch", body, map[string]string{"Content-Type": "application/x-ndjson"})
+	defer response.Body.Close()
+	require.Equal(t, http.StatusCreated, response.StatusCode)
+	lines := strings.Split(strings.TrimSuffix(answer, "\n"), "\n")
+	require.Len(t, lines, batchChunk+1)
+	failure = batchFailure{}
+	require.NoError(t, json.Unmarshal([]byte(lines[batchChunk]), &failure))
+	require.Equal(t, "invalid", failure.Status)
+	require.NotEmpty(t, failure.Error)
+}
+
+func Test_BatchDuplex(t *testing.T) {
+	_, r, _ := newServer(t)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	reader, writer := io.Pipe()
+	defer writer.Close()
+	request, err := http.NewRequest(http.MethodPost, ts.URL+"/api/shorten/batch", reader)
+	require.NoError(t, err)
+	request.Header.Set("Content-Type", "application/x-ndjson")
+	request.Header.Set(mymiddlewares.FullDuplexHeader, "true")
+	go io.WriteString(writer, batchBody(t, 0, batchChunk, false))
+	response, err := ts.Client().Do(request)
+	require.NoError(t, err)
+	defer response.Body.Close()
+	require.Equal(t, http.StatusCreated, response.StatusCode)
+	//results of first chunk are received before the rest of the list is sent
+	scanner := bufio.NewScanner(response.Body)
+	for i := 0; i < batchChunk; i++ {
+		require.True(t, scanner.Scan())
+	}
+	_, err = io.WriteString(writer, batchBody(t, batchChunk, 5, false))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	count := 0
+	for scanner.Scan() {
+		count++
+	}
+	require.NoError(t, scanner.Err())
+	require.Equal(t, 5, count)
+}
+
+func Test_batchEncoder(t *testing.T) {
+	w := httptest.NewRecorder()
+	e := newBatchEncoder(w, true, http.StatusCreated, false)
+	//results are moved to temporary file after the first item
+	e.dst.(*spool).limit = 16
+	require.NoError(t, e.write([]output{{Correlation: "1", Status: "created"}}))
+	require.NoError(t, e.write([]output{{Correlation: "2", Status: "exists"}}))
+	require.NotNil(t, e.dst.(*spool).file)
+	require.Empty(t, w.Body.String())
+	require.NoError(t, e.close())
+	e.release()
+	require.Nil(t, e.dst.(*spool).file)
+	require.Equal(t, http.StatusCreated, w.Code)
+	require.Equal(t, `[{"correlation_id":"1","status":"created"},{"correlation_id":"2","status":"exists"}]`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	e = newBatchEncoder(w, false, http.StatusOK, false)
+	require.NoError(t, e.write([]output{{Correlation: "1", Status: "exists"}}))
+	require.NoError(t, e.fail("Internal Server Error"))
+	require.Equal(t, `{"correlation_id":"1","status":"exists"}`+"\n"+`{"status":"invalid","error":"Internal Server Error"}`+"\n", w.Body.String())
+}
+
+func Test_spool(t *testing.T) {
+	s := &spool{limit: 8}
+	_, err := s.Write([]byte("1234"))
+	require.NoError(t, err)
+	require.Nil(t, s.file)
+	_, err = s.Write([]byte("56789"))
+	require.NoError(t, err)
+	require.NotNil(t, s.file)
+	require.Zero(t, s.buf.Len())
+	name := s.file.Name()
+	_, err = s.Write([]byte("0"))
+	require.NoError(t, err)
+	var b bytes.Buffer
+	_, err = s.WriteTo(&b)
+	require.NoError(t, err)
+	require.Equal(t, "1234567890", b.String())
+	require.NoError(t, s.Close())
+	_, err = os.Stat(name)
+	require.True(t, os.IsNotExist(err))
+}
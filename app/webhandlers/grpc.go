@@ -0,0 +1,191 @@
+package webhandlers
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/t1mon-ggg/go_shortner/api/shortener"
+	"github.com/t1mon-ggg/go_shortner/app/helpers"
+	"github.com/t1mon-ggg/go_shortner/app/models"
+	"github.com/t1mon-ggg/go_shortner/app/service"
+)
+
+//clientIDMetadata - ключ метаданных с подписанным идентификатором пользователя
+const clientIDMetadata = "client_id"
+
+//clientIDKey - ключ контекста с идентификатором пользователя HTTP или gRPC запроса
+type clientIDKey struct{}
+
+//grpcServer - реализация gRPC сервиса сокращения ссылок поверх сервиса приложения
+type grpcServer struct {
+	shortener.UnimplementedShortenerServer
+	application *App
+}
+
+//NewGRPCServer - создание gRPC сервера, использующего хранилище и очередь удаления приложения
+//вызывается после NewWebProcessor
+func (application *App) NewGRPCServer(opts ...grpc.ServerOption) *grpc.Server {
+	opts = append(opts, grpc.UnaryInterceptor(application.grpcIdentity))
+	s := grpc.NewServer(opts...)
+	shortener.RegisterShortenerServer(s, &grpcServer{application: application})
+	return s
+}
+
+//grpcIdentity - определение пользователя по метаданным client_id, создание нового пользователя при отсутствии или неверной подписи
+func (application *App) grpcIdentity(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	id := ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(clientIDMetadata); len(values) > 0 {
+			id = values[0]
+		}
+	}
+	if !application.validID(ctx, id) {
+		value := helpers.RandStringRunes(32)
+		key := helpers.RandStringRunes(64)
+		err := application.Storage.Write(ctx, models.ClientData{Cookie: value, Key: key, Short: make([]models.ShortData, 0)})
+		if err != nil {
+			log.Println(err)
+			return nil, status.Error(codes.Internal, "Internal Server Error")
+		}
+		id = signID(value, key)
+	}
+	err := grpc.SetHeader(ctx, metadata.Pairs(clientIDMetadata, id))
+	if err != nil {
+		log.Println(err)
+	}
+	return handler(context.WithValue(ctx, clientIDKey{}, id[:32]), req)
+}
+
+//clientID - идентификатор пользователя HTTP или gRPC запроса
+func clientID(ctx context.Context) string {
+	id, _ := ctx.Value(clientIDKey{}).(string)
+	return id
+}
+
+//grpcVisit - сведения о клиенте из gRPC запроса
+func grpcVisit(ctx context.Context) service.Visit {
+	addr := ""
+	if p, ok := peer.FromContext(ctx); ok {
+		addr = p.Addr.String()
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	header := func(name string) string {
+		if values := md.Get(name); len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+	return service.Visit{Addr: addr, Referrer: header("referer"), UserAgent: header("user-agent"), Country: clientCountry(header)}
+}
+
+//grpcError - статус gRPC, соответствующий ошибке сервиса
+func grpcError(err error) error {
+	switch {
+	case errors.Is(err, service.ErrInvalid):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, service.ErrAliasTaken):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, service.ErrBlocked):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, service.ErrNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, service.ErrGone):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, service.ErrClosed):
+		return status.Error(codes.Unavailable, err.Error())
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return status.FromContextError(err).Err()
+	}
+	log.Println(err)
+	return status.Error(codes.Internal, "Storage error")
+}
+
+//request - запрос на сокращение из полей gRPC сообщения
+func request(url, alias string, ts *timestamppb.Timestamp, ttl int64) service.Request {
+	req := service.Request{URL: url, Alias: alias, TTL: ttl}
+	if ts != nil {
+		t := ts.AsTime()
+		req.ExpiresAt = &t
+	}
+	return req
+}
+
+//Shorten - сокращение ссылки
+func (s *grpcServer) Shorten(ctx context.Context, in *shortener.ShortenRequest) (*shortener.ShortenResponse, error) {
+	result, err := s.application.Service.Shorten(ctx, clientID(ctx), request(in.Url, in.CustomAlias, in.ExpiresAt, in.TtlSeconds))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &shortener.ShortenResponse{ShortUrl: result.ShortURL, Existed: result.Existed}, nil
+}
+
+//ShortenBatch - сокращение ссылок списком
+func (s *grpcServer) ShortenBatch(ctx context.Context, in *shortener.ShortenBatchRequest) (*shortener.ShortenBatchResponse, error) {
+	reqs := make([]service.BatchRequest, 0, len(in.Items))
+	for _, item := range in.Items {
+		reqs = append(reqs, service.BatchRequest{Correlation: item.CorrelationId, Request: request(item.OriginalUrl, item.CustomAlias, item.ExpiresAt, item.TtlSeconds)})
+	}
+	results, err := s.application.Service.ShortenBatch(ctx, clientID(ctx), reqs)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	out := &shortener.ShortenBatchResponse{Items: make([]*shortener.BatchResult, 0, len(results))}
+	for _, result := range results {
+		item := &shortener.BatchResult{CorrelationId: result.Correlation, ShortUrl: result.ShortURL, Status: result.Status()}
+		if result.Err != nil {
+			item.Error = result.Err.Error()
+		}
+		out.Items = append(out.Items, item)
+	}
+	return out, nil
+}
+
+//Resolve - получение оригинальной ссылки по тегу с учетом перехода
+func (s *grpcServer) Resolve(ctx context.Context, in *shortener.ResolveRequest) (*shortener.ResolveResponse, error) {
+	long, err := s.application.Service.Resolve(ctx, in.Tag, grpcVisit(ctx))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &shortener.ResolveResponse{OriginalUrl: long}, nil
+}
+
+//ListUserURLs - все сокращенные ссылки пользователя
+func (s *grpcServer) ListUserURLs(ctx context.Context, _ *emptypb.Empty) (*shortener.ListUserURLsResponse, error) {
+	urls, err := s.application.Service.List(ctx, clientID(ctx))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	out := &shortener.ListUserURLsResponse{Urls: make([]*shortener.URL, 0, len(urls))}
+	for _, content := range urls {
+		out.Urls = append(out.Urls, &shortener.URL{ShortUrl: content.ShortURL, OriginalUrl: content.OriginalURL})
+	}
+	return out, nil
+}
+
+//DeleteURLs - постановка коротких ссылок пользователя в очередь на удаление
+func (s *grpcServer) DeleteURLs(ctx context.Context, in *shortener.DeleteURLsRequest) (*emptypb.Empty, error) {
+	err := s.application.Service.Delete(ctx, clientID(ctx), in.Tags)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+//Ping - проверка доступности хранилища
+func (s *grpcServer) Ping(ctx context.Context, _ *emptypb.Empty) (*emptypb.Empty, error) {
+	err := s.application.Storage.Ping(ctx)
+	if err != nil {
+		log.Println(err)
+		return nil, status.Error(codes.Unavailable, "Storage connection failed")
+	}
+	return &emptypb.Empty{}, nil
+}
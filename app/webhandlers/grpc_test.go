@@ -0,0 +1,201 @@
+package webhandlers
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/t1mon-ggg/go_shortner/api/shortener"
+	"github.com/t1mon-ggg/go_shortner/app/service"
+)
+
+func newGRPCClient(t *testing.T) (shortener.ShortenerClient, *App) {
+	_, _, db := newServer(t)
+	listener := bufconn.Listen(1024 * 1024)
+	s := db.NewGRPCServer()
+	go s.Serve(listener)
+	t.Cleanup(s.Stop)
+	dialer := func(ctx context.Context, _ string) (net.Conn, error) {
+		return listener.DialContext(ctx)
+	}
+	conn, err := grpc.Dial("bufnet", grpc.WithContextDialer(dialer), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return shortener.NewShortenerClient(conn), db
+}
+
+//withID - контекст запроса с идентификатором пользователя
+func withID(id string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), clientIDMetadata, id)
+}
+
+func Test_GRPC_Identity(t *testing.T) {
+	client, _ := newGRPCClient(t)
+	var header metadata.MD
+	_, err := client.Shorten(context.Background(), &shortener.ShortenRequest{Url: "http://example1.org"}, grpc.Header(&header))
+	require.NoError(t, err)
+	require.Len(t, header.Get(clientIDMetadata), 1)
+	id := header.Get(clientIDMetadata)[0]
+	require.Len(t, id, 96)
+
+	var next metadata.MD
+	list, err := client.ListUserURLs(withID(id), &emptypb.Empty{}, grpc.Header(&next))
+	require.NoError(t, err)
+	require.Equal(t, id, next.Get(clientIDMetadata)[0])
+	require.Len(t, list.Urls, 1)
+	require.Equal(t, "http://example1.org", list.Urls[0].OriginalUrl)
+
+	forged := id[:32] + id[:64]
+	list, err = client.ListUserURLs(withID(forged), &emptypb.Empty{}, grpc.Header(&next))
+	require.NoError(t, err)
+	require.NotEqual(t, forged, next.Get(clientIDMetadata)[0])
+	require.Empty(t, list.Urls)
+}
+
+func Test_GRPC_Shorten(t *testing.T) {
+	client, _ := newGRPCClient(t)
+	var header metadata.MD
+	first, err := client.Shorten(context.Background(), &shortener.ShortenRequest{Url: "http://example1.org"}, grpc.Header(&header))
+	require.NoError(t, err)
+	require.False(t, first.Existed)
+	ctx := withID(header.Get(clientIDMetadata)[0])
+	tests := []struct {
+		name    string
+		request *shortener.ShortenRequest
+		code    codes.Code
+		want    string
+		existed bool
+	}{
+		{
+			name:    "Already shortened",
+			request: &shortener.ShortenRequest{Url: "http://example1.org"},
+			code:    codes.OK,
+			want:    first.ShortUrl,
+			existed: true,
+		},
+		{
+			name:    "Custom alias",
+			request: &shortener.ShortenRequest{Url: "http://example2.org", CustomAlias: "grpc_alias"},
+			code:    codes.OK,
+			want:    "http://127.0.0.1:8080/grpc_alias",
+		},
+		{
+			name:    "Alias taken",
+			request: &shortener.ShortenRequest{Url: "http://example3.org", CustomAlias: "grpc_alias"},
+			code:    codes.AlreadyExists,
+		},
+		{
+			name:    "Wrong alias",
+			request: &shortener.ShortenRequest{Url: "http://example3.org", CustomAlias: "swagger"},
+			code:    codes.InvalidArgument,
+		},
+		{
+			name:    "Empty url",
+			request: &shortener.ShortenRequest{},
+			code:    codes.InvalidArgument,
+		},
+		{
+			name:    "TTL and expiration",
+			request: &shortener.ShortenRequest{Url: "http://example3.org", TtlSeconds: 60, ExpiresAt: timestamppb.New(time.Now().Add(time.Hour))},
+			code:    codes.InvalidArgument,
+		},
+		{
+			name:    "Expiration in the past",
+			request: &shortener.ShortenRequest{Url: "http://example3.org", ExpiresAt: timestamppb.New(time.Now().Add(-time.Hour))},
+			code:    codes.InvalidArgument,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := client.Shorten(ctx, tt.request)
+			require.Equal(t, tt.code, status.Code(err))
+			if tt.code == codes.OK {
+				require.Equal(t, tt.want, response.ShortUrl)
+				require.Equal(t, tt.existed, response.Existed)
+			}
+		})
+	}
+}
+
+func Test_GRPC_ShortenBatch(t *testing.T) {
+	client, _ := newGRPCClient(t)
+	request := &shortener.ShortenBatchRequest{Items: []*shortener.BatchItem{
+		{CorrelationId: "1", OriginalUrl: "http://example1.org", CustomAlias: "batch_grpc"},
+		{CorrelationId: "2", OriginalUrl: "http://example2.org"},
+	}}
+	var header metadata.MD
+	response, err := client.ShortenBatch(context.Background(), request, grpc.Header(&header))
+	require.NoError(t, err)
+	require.Len(t, response.Items, 2)
+	require.Equal(t, "1", response.Items[0].CorrelationId)
+	require.Equal(t, "http://127.0.0.1:8080/batch_grpc", response.Items[0].ShortUrl)
+	require.Equal(t, "2", response.Items[1].CorrelationId)
+	require.Equal(t, service.StatusCreated, response.Items[1].Status)
+
+	ctx := withID(header.Get(clientIDMetadata)[0])
+	again, err := client.ShortenBatch(ctx, &shortener.ShortenBatchRequest{Items: request.Items[1:]})
+	require.NoError(t, err)
+	require.Equal(t, response.Items[1].ShortUrl, again.Items[0].ShortUrl)
+	require.Equal(t, service.StatusExists, again.Items[0].Status)
+
+	response, err = client.ShortenBatch(ctx, &shortener.ShortenBatchRequest{Items: []*shortener.BatchItem{
+		{CorrelationId: "1", OriginalUrl: "http://example3.org", CustomAlias: "Swagger"},
+		{CorrelationId: "2", OriginalUrl: "http://example3.org", CustomAlias: "batch_grpc"},
+		{CorrelationId: "3", OriginalUrl: "http://example3.org"},
+	}})
+	require.NoError(t, err)
+	require.Equal(t, service.StatusInvalid, response.Items[0].Status)
+	require.NotEmpty(t, response.Items[0].Error)
+	require.Equal(t, service.StatusInvalid, response.Items[1].Status)
+	require.Equal(t, "custom alias is taken: batch_grpc", response.Items[1].Error)
+	require.Equal(t, service.StatusCreated, response.Items[2].Status)
+}
+
+func Test_GRPC_ResolveAndDelete(t *testing.T) {
+	client, db := newGRPCClient(t)
+	var header metadata.MD
+	_, err := client.Shorten(context.Background(), &shortener.ShortenRequest{Url: "http://example1.org", CustomAlias: "resolve_me"}, grpc.Header(&header))
+	require.NoError(t, err)
+	ctx := withID(header.Get(clientIDMetadata)[0])
+
+	response, err := client.Resolve(context.Background(), &shortener.ResolveRequest{Tag: "resolve_me"})
+	require.NoError(t, err)
+	require.Equal(t, "http://example1.org", response.OriginalUrl)
+	_, err = client.Resolve(context.Background(), &shortener.ResolveRequest{Tag: "notexist"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+	_, err = client.Resolve(context.Background(), &shortener.ResolveRequest{Tag: "a/b"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	db.Clicks.Close()
+	stats, err := db.Storage.Stats(context.Background(), "resolve_me", service.StatsTop)
+	require.NoError(t, err)
+	require.Equal(t, 1, stats.Clicks)
+
+	_, err = client.DeleteURLs(withID("notvalid"), &shortener.DeleteURLsRequest{Tags: []string{"resolve_me"}})
+	require.NoError(t, err)
+	_, err = client.DeleteURLs(ctx, &shortener.DeleteURLsRequest{Tags: []string{"resolve_me", "a/b"}})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = client.DeleteURLs(ctx, &shortener.DeleteURLsRequest{Tags: []string{"resolve_me"}})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		_, err := client.Resolve(context.Background(), &shortener.ResolveRequest{Tag: "resolve_me"})
+		return status.Code(err) == codes.FailedPrecondition
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func Test_GRPC_Ping(t *testing.T) {
+	client, _ := newGRPCClient(t)
+	_, err := client.Ping(context.Background(), &emptypb.Empty{})
+	require.NoError(t, err)
+}
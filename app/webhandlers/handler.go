@@ -1,547 +1,767 @@
-package webhandlers
-
-import (
-	"crypto/hmac"
-	"crypto/sha256"
-	"encoding/hex"
-	"encoding/json"
-	"fmt"
-	"io"
-	"log"
-	"net/http"
-	"regexp"
-
-	"github.com/go-chi/chi"
-	"github.com/go-chi/chi/middleware"
-	httpSwagger "github.com/swaggo/http-swagger"
-
-	_ "github.com/t1mon-ggg/go_shortner/api"
-	"github.com/t1mon-ggg/go_shortner/app/config"
-	"github.com/t1mon-ggg/go_shortner/app/helpers"
-	"github.com/t1mon-ggg/go_shortner/app/models"
-	"github.com/t1mon-ggg/go_shortner/app/mymiddlewares"
-	"github.com/t1mon-ggg/go_shortner/app/storage"
-)
-
-// @Title URL SHORTNER API
-// @Description Сервис сокращения ссылок
-// @Version 1.0
-
-// @Contact.email t1mon.ggg@yandex.ru
-
-// @BasePath /
-// @Host 127.0.0.1:8080
-
-//App - application struct
-type App struct {
-	Storage storage.Storage
-	Config  *config.Config
-	DelBuf  chan models.DelWorker
-}
-
-type answer struct {
-	Short    string `json:"short_url"`
-	Original string `json:"original_url"`
-}
-
-type input struct {
-	Correlation string `json:"correlation_id"`
-	Long        string `json:"original_url"`
-}
-
-type output struct {
-	Correlation string `json:"correlation_id"`
-	Short       string `json:"short_url"`
-}
-
-type sURL struct {
-	ShortURL string `json:"result"`
-}
-
-type lURL struct {
-	LongURL string `json:"url"`
-}
-
-//NewApp - функция для создания новой структуры для работы приложения
-func NewApp() *App {
-	s := App{}
-	s.Config = config.New()
-	s.DelBuf = make(chan models.DelWorker)
-	return &s
-}
-
-func (application *App) NewStorage() error {
-	var err error
-	application.Storage, err = application.Config.NewStorage()
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
-//NewWebProcessor - создание новго роутера для обработки веб запросов
-//  workers int - количество потоков для удаления сокращенных ссылок
-func (application *App) NewWebProcessor(workers int) *chi.Mux {
-	go application.Storage.Cleaner(application.DelBuf, workers)
-	r := chi.NewRouter()
-	application.middlewares(r)
-	r.Route("/", application.router)
-	return r
-}
-
-//Router - creates chi router and cleaner
-func (application *App) router(r chi.Router) {
-	r.Get("/", defaultGetHandler)
-	r.Get("/ping", application.connectionTest)
-	r.Get("/{^[a-zA-Z]}", application.getHandler)
-	r.Get("/api/user/urls", application.userURLs)
-	r.Post("/", application.postHandler)
-	r.Post("/api/shorten", application.postAPIHandler)
-	r.Post("/api/shorten/batch", application.postAPIBatch)
-	r.Delete("/api/user/urls", application.deleteTags)
-	r.Get("/swagger/*", httpSwagger.Handler(httpSwagger.URL(application.Config.BaseURL+"/swagger/doc.json")))
-	r.MethodNotAllowed(otherHandler)
-	r.NotFound(otherHandler)
-}
-
-//defaultGetHandler - handler for "/"
-func defaultGetHandler(w http.ResponseWriter, r *http.Request) {
-	http.Error(w, "Empty request", http.StatusBadRequest)
-}
-
-//otherHandler - handler for unknown request and unknow paths
-func otherHandler(w http.ResponseWriter, r *http.Request) {
-	http.Error(w, "Bad request", http.StatusBadRequest)
-}
-
-// Ping godoc
-// @Tags Ping
-// @Summary Запрос состояния хранилища
-// @Accept text/plain
-// @Produce text/plain
-// @Success 200   "Проверка подключения к хранилищу успешно завершено"
-// @Failure 500   "Хранилище недоступно"
-// @Router / [get]
-// ConnectionTest - handler for "/ping"
-func (application *App) connectionTest(w http.ResponseWriter, r *http.Request) {
-	err := application.Storage.Ping()
-	if err != nil {
-		log.Println(err)
-		http.Error(w, "Storage connection failed", http.StatusInternalServerError)
-		return
-	}
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte{})
-}
-
-// Create godoc
-// @Tags ListAll
-// @Summary Запрос на получение всех сокращенных ссылок пользователя
-// @Accept text/plain
-// @Produce application/json
-// @Param Client_ID header string true "Идентификационный cookie Client_ID"
-// @Success 202   "Сокращения в базе данных отсутствуют"
-// @Success 200 {array} answer "Список сокращений пользователя"
-// @Failure 500 {string} string "Внутренняя ошибка сервера"
-// @Router /api/user/urls [get]
-// userURLs - handler for "/api/user/urls" GET Method
-func (application *App) userURLs(w http.ResponseWriter, r *http.Request) {
-	cookie := idCookieValue(w, r)
-	data, err := application.Storage.ReadByCookie(cookie)
-	if err != nil {
-		log.Println(err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
-	}
-	if len(data.Short) == 0 {
-		http.Error(w, "No Content", http.StatusNoContent)
-		return
-	}
-	a := make([]answer, 0)
-	for _, content := range data.Short {
-		a = append(a, answer{Short: fmt.Sprintf("%s/%s", application.Config.BaseURL, content.Short), Original: content.Long})
-	}
-	d, err := json.Marshal(a)
-	if err != nil {
-		log.Println(err)
-		http.Error(w, "Json Error", http.StatusInternalServerError)
-		return
-	}
-	if len(a) == 0 {
-		http.Error(w, "No Content", http.StatusNoContent)
-		return
-	}
-	w.Header().Add("Content-type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write(d)
-}
-
-// Create godoc
-// @Tags Create
-// @Summary Запрос на сокращение ссылки
-// @Accept text/plain
-// @Produce text/plain
-// @Param Input body string true "Сокращаемый URL"
-// @Param Client_ID header string false "Идентификационный cookie Client_ID"
-// @Success 201 {string} string "Создана новая сокращенная ссылка"
-// @Success 409 {string} string "Запрашиваемый URL уже существует"
-// @Failure 500   "Внутренняя ошибка сервера"
-// @Router / [post]
-// postHandler - handler for "/" POST Method
-func (application *App) postHandler(w http.ResponseWriter, r *http.Request) {
-	cookie := idCookieValue(w, r)
-	entry := models.ClientData{}
-	entry.Cookie = cookie
-	entry.Key = ""
-	entry.Short = make([]models.ShortData, 0)
-	defer r.Body.Close()
-	blongURL, err := io.ReadAll(r.Body)
-	if err != nil {
-		log.Println(err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
-	}
-	slongURL := string(blongURL)
-	log.Println("Request body:", slongURL)
-	surl := helpers.RandStringRunes(8)
-	entry.Short = append(entry.Short, models.ShortData{Short: surl, Long: slongURL})
-	err = application.Storage.Write(entry)
-	if err != nil {
-		if err.Error() == "not unique url" {
-			s, err := application.Storage.TagByURL(slongURL, cookie)
-			if err != nil {
-				log.Println(err)
-				http.Error(w, "Storage error", http.StatusInternalServerError)
-				return
-			}
-			w.WriteHeader(http.StatusConflict)
-			w.Write([]byte(fmt.Sprintf("%s/%s", application.Config.BaseURL, s)))
-			return
-		}
-		log.Println(err)
-		http.Error(w, "Storage error", http.StatusInternalServerError)
-		return
-	}
-	w.WriteHeader(http.StatusCreated)
-	w.Write([]byte(fmt.Sprintf("%s/%s", application.Config.BaseURL, surl)))
-}
-
-// APICreate godoc
-// @Tags APICreate
-// @Summary Запрос на сокращение ссылки
-// @Accept application/json
-// @Produce application/json
-// @Param Client_ID header string false "Идентификационный cookie Client_ID"
-// @Param Input body lURL true "Сокращаемый URL"
-// @Success 201 {object} sURL "Создана новая сокращенная ссылка"
-// @Success 409 {object} sURL "Запрашиваемый URL уже существует"
-// @Failure 400   "Неверный запрос"
-// @Failure 500   "Внутренняя ошибка сервера"
-// @Router /api/shorten [post]
-// postAPIHandler - handler for "/api/shorten" POST Method
-func (application *App) postAPIHandler(w http.ResponseWriter, r *http.Request) {
-	cookie := idCookieValue(w, r)
-	entry := models.ClientData{}
-	entry.Cookie = cookie
-	entry.Key = ""
-	entry.Short = make([]models.ShortData, 0)
-	defer r.Body.Close()
-	ctype := r.Header.Get("Content-Type")
-	if ctype != "application/json" {
-		http.Error(w, "Bad request", http.StatusBadRequest)
-		return
-	}
-	longURL := lURL{}
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		log.Println("Body Error", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
-	}
-	log.Println("Request body:", string(body))
-	err = json.Unmarshal(body, &longURL)
-	if err != nil {
-		log.Println("JSON Unmarshal error", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
-	}
-	short := helpers.RandStringRunes(8)
-	entry.Short = append(entry.Short, models.ShortData{Short: short, Long: longURL.LongURL})
-	err = application.Storage.Write(entry)
-	if err != nil {
-		if err.Error() == "not unique url" {
-			s, err := application.Storage.TagByURL(longURL.LongURL, cookie)
-			if err != nil {
-				log.Println(err)
-				http.Error(w, "Storage error", http.StatusInternalServerError)
-				return
-			}
-			jbody := sURL{ShortURL: fmt.Sprintf("%s/%s", (*application).Config.BaseURL, s)}
-			abody, err := json.Marshal(jbody)
-			if err != nil {
-				log.Println("JSON Marshal error", err)
-				http.Error(w, "Internal server error", http.StatusInternalServerError)
-				return
-			}
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusConflict)
-			w.Write(abody)
-			return
-		}
-		log.Println(err)
-		http.Error(w, "Storage error", http.StatusInternalServerError)
-		return
-	}
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	jbody := sURL{ShortURL: fmt.Sprintf("%s/%s", (*application).Config.BaseURL, short)}
-	abody, err := json.Marshal(jbody)
-	if err != nil {
-		log.Println("JSON Marshal error", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
-	}
-	w.Write(abody)
-}
-
-// APICreateBatch godoc
-// @Tags APICreate
-// @Summary Запрос на сокращение ссылок списком
-// @Accept application/json
-// @Produce application/json
-// @Param Client_ID header string false "Идентификационный cookie Client_ID"
-// @Param Input body input true "Список сокращаемых URLs"
-// @Success 201 {object} output "Список успешно обработан"
-// @Failure 400   "Неверный запрос"
-// @Failure 500   "Внутренняя ошибка сервера"
-// @Router /api/shorten/batch [post]
-// postAPIBatch - handler for "/api/shorten/batch" POST Method
-func (application *App) postAPIBatch(w http.ResponseWriter, r *http.Request) {
-	cookie := idCookieValue(w, r)
-	out := make([]output, 0)
-	defer r.Body.Close()
-	ctype := r.Header.Get("Content-Type")
-	if ctype != "application/json" {
-		http.Error(w, "Bad request", http.StatusBadRequest)
-		return
-	}
-	in := []input{}
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		log.Println("Body Error", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
-	}
-	err = json.Unmarshal(body, &in)
-	if err != nil {
-		log.Println("JSON Unmarshal error", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
-	}
-	for i := range in {
-		entry := models.ClientData{}
-		entry.Cookie = cookie
-		entry.Key = ""
-		entry.Short = make([]models.ShortData, 0)
-		short := helpers.RandStringRunes(8)
-		entry.Short = append(entry.Short, models.ShortData{Short: short, Long: in[i].Long})
-		err := application.Storage.Write(entry)
-		if err != nil {
-			if err.Error() == "not unique url" {
-				s, err := application.Storage.TagByURL(in[i].Long, cookie)
-				if err != nil {
-					http.Error(w, "Storage error", http.StatusInternalServerError)
-					return
-				}
-				out = append(out, output{Correlation: in[i].Correlation, Short: fmt.Sprintf("%s/%s", (*application).Config.BaseURL, s)})
-			} else {
-				log.Println(err)
-				http.Error(w, "Storage error", http.StatusInternalServerError)
-				return
-			}
-		} else {
-			out = append(out, output{Correlation: in[i].Correlation, Short: fmt.Sprintf("%s/%s", (*application).Config.BaseURL, short)})
-		}
-	}
-	batch, err := json.Marshal(out)
-	if err != nil {
-		log.Println(err)
-		http.Error(w, "Answer error", http.StatusInternalServerError)
-		return
-	}
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	w.Write(batch)
-}
-
-// getHandler - handler for "/{short_tag}" GET Method
-//cjover short url to original url
-func (application *App) getHandler(w http.ResponseWriter, r *http.Request) {
-	p := r.RequestURI
-	p = p[1:]
-	matched, err := regexp.Match(`\w{8}`, []byte(p))
-	if err != nil {
-		log.Println(err)
-		http.Error(w, "URI process error", http.StatusInternalServerError)
-		return
-	}
-	if !matched || len(p) > 8 {
-		http.Error(w, "Bad request", http.StatusBadRequest)
-		return
-	}
-	data, err := application.Storage.ReadByTag(p)
-	if err != nil {
-		log.Println(err)
-		http.Error(w, "DB read error", http.StatusInternalServerError)
-		return
-	}
-	nilShort := models.ShortData{}
-	if data == nilShort {
-		http.Error(w, "Not Found", http.StatusNotFound)
-		return
-	}
-	if data.Deleted {
-		w.WriteHeader(http.StatusGone)
-		w.Write([]byte{})
-		return
-	}
-	w.Header().Set("Location", data.Long)
-	w.WriteHeader(http.StatusTemporaryRedirect)
-	w.Write([]byte{})
-
-}
-
-// APIDeleteShort godoc
-// @Tags APIDelete
-// @Summary Запрос на удаление короткой ссылки
-// @Accept application/json
-// @Produce application/json
-// @Param Input body string true "Список удаляемых коротких идентификаторов"
-// @Param Client_ID header string true "Идентификационный cookie Client_ID"
-// @Success 202   "Запрос принят в обработку"
-// @Failure 500   "Внутренняя ошибка сервера"
-// @Router /api/user/urls [delete]
-// deleteTags - handler for "/api/user/urls" DELETE Method
-func (application *App) deleteTags(w http.ResponseWriter, r *http.Request) {
-	cookie := idCookieValue(w, r)
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusAccepted)
-	w.Write([]byte{})
-	defer r.Body.Close()
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		log.Println(err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
-	}
-	re := regexp.MustCompile(`\w+`)
-	tags := re.FindAllString(string(body), -1)
-	task := models.DelWorker{Cookie: cookie, Tags: tags}
-	application.DelBuf <- task
-}
-
-//middlewares - middleware definition
-func (application *App) middlewares(r *chi.Mux) {
-	r.Use(middleware.Compress(5))
-	r.Use(middleware.RequestID)
-	r.Use(middleware.RealIP)
-	r.Use(middleware.Logger)
-	r.Use(middleware.Recoverer)
-	r.Use(mymiddlewares.DecompressRequestAndTimeTracer)
-	r.Use(application.cookieProcessor)
-}
-
-//idCookieValue - get cookie value fron request
-func idCookieValue(w http.ResponseWriter, r *http.Request) string {
-	if len(r.Cookies()) == 0 {
-		re := regexp.MustCompile(`\w{96}`)
-		cid := re.FindString(w.Header().Get("Set-Cookie"))
-		if len(cid) != 0 {
-			if len(cid) == 96 {
-				value := cid[:32]
-				return value
-			}
-		}
-		return ""
-	} else {
-		for _, cookie := range r.Cookies() {
-			if cookie.Name == "Client_ID" {
-				if len(cookie.Value) == 96 {
-					value := cookie.Value[:32]
-					return value
-				}
-			}
-		}
-	}
-	return ""
-}
-
-//cookieProcessor - cookie processor
-func (application *App) cookieProcessor(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if len(r.Cookies()) != 0 {
-			found := false
-			for _, cookie := range r.Cookies() {
-				if cookie.Name == "Client_ID" {
-					if !application.checkCookie(cookie) {
-						value := helpers.RandStringRunes(32)
-						key := helpers.RandStringRunes(64)
-						application.addCookie(w, "Client_ID", value, key)
-					} else {
-						found = true
-					}
-				}
-			}
-			if !found {
-				value := helpers.RandStringRunes(32)
-				key := helpers.RandStringRunes(64)
-				application.addCookie(w, "Client_ID", value, key)
-			}
-		} else {
-			value := helpers.RandStringRunes(32)
-			key := helpers.RandStringRunes(64)
-			application.addCookie(w, "Client_ID", value, key)
-		}
-		next.ServeHTTP(w, r)
-	})
-}
-
-//addCookie - add cookie to response
-func (application *App) addCookie(w http.ResponseWriter, name, value string, key string) {
-	h := hmac.New(sha256.New, []byte(key))
-	h.Write([]byte(value))
-	signed := h.Sum(nil)
-	sign := hex.EncodeToString(signed)
-	cookie := http.Cookie{
-		Name:   name,
-		Value:  value + sign,
-		MaxAge: 0,
-		Path:   "/",
-	}
-	entry := models.ClientData{}
-	entry.Cookie = value
-	entry.Key = key
-	entry.Short = make([]models.ShortData, 0)
-	err := application.Storage.Write(entry)
-	if err != nil {
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
-	}
-	http.SetCookie(w, &cookie)
-}
-
-//checkCookie - cookie validation
-func (application *App) checkCookie(cookie *http.Cookie) bool {
-	data := cookie.Value[:32]
-	signstring := cookie.Value[32:]
-	sign, err := hex.DecodeString(signstring)
-	if err != nil {
-		log.Println(err)
-		return false
-	}
-	checkdata, _ := application.Storage.ReadByCookie(data)
-	h := hmac.New(sha256.New, []byte(checkdata.Key))
-	h.Write([]byte(data))
-	signed := h.Sum(nil)
-	return hmac.Equal(sign, signed)
-}
+package webhandlers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	httpSwagger "github.com/swaggo/http-swagger"
+
+	_ "github.com/t1mon-ggg/go_shortner/api"
+	"github.com/t1mon-ggg/go_shortner/app/config"
+	"github.com/t1mon-ggg/go_shortner/app/helpers"
+	"github.com/t1mon-ggg/go_shortner/app/models"
+	"github.com/t1mon-ggg/go_shortner/app/mymiddlewares"
+	"github.com/t1mon-ggg/go_shortner/app/policy"
+	"github.com/t1mon-ggg/go_shortner/app/service"
+	"github.com/t1mon-ggg/go_shortner/app/storage"
+)
+
+// @Title URL SHORTNER API
+// @Description Сервис сокращения ссылок
+// @Version 1.0
+
+// @Contact.email t1mon.ggg@yandex.ru
+
+// @BasePath /
+// @Host 127.0.0.1:8080
+
+//App - application struct
+type App struct {
+	Storage storage.Storage
+	Config  *config.Config
+	DelBuf  chan models.DelWorker
+	Clicks  *storage.ClickRecorder
+	Service *service.Service
+	Policy  *policy.Engine
+	subnet  *net.IPNet    //доверенная подсеть для внутренней статистики, nil - доступ запрещен
+	stop    chan struct{} //сигнал остановки фоновых задач
+	swept   chan struct{} //сигнал завершения поиска просроченных ссылок
+	cleaned chan struct{} //сигнал завершения обработчиков удаления
+}
+
+//delBuffer - размер очереди заданий на удаление
+const delBuffer = 100
+
+type answer struct {
+	Short    string `json:"short_url"`
+	Original string `json:"original_url"`
+}
+
+type input struct {
+	Correlation string     `json:"correlation_id"`
+	Long        string     `json:"original_url"`
+	Alias       string     `json:"custom_alias,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	TTL         int64      `json:"ttl_seconds,omitempty"`
+}
+
+type output struct {
+	Correlation string `json:"correlation_id"`
+	Short       string `json:"short_url,omitempty"`
+	Status      string `json:"status" enums:"created,exists,invalid"`
+	Error       string `json:"error,omitempty"`
+}
+
+type internalStats struct {
+	URLs  int `json:"urls"`
+	Users int `json:"users"`
+}
+
+type sURL struct {
+	ShortURL string `json:"result"`
+}
+
+type lURL struct {
+	LongURL   string     `json:"url"`
+	Alias     string     `json:"custom_alias,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	TTL       int64      `json:"ttl_seconds,omitempty"`
+}
+
+//sweepInterval - период поиска ссылок с истекшим сроком действия
+const sweepInterval = time.Minute
+
+//countryHeaders - headers with client country code set by proxy
+var countryHeaders = []string{"CF-IPCountry", "X-Country-Code"}
+
+//NewApp - функция для создания новой структуры для работы приложения
+func NewApp() *App {
+	s := App{}
+	s.Config = config.New()
+	s.DelBuf = make(chan models.DelWorker, delBuffer)
+	return &s
+}
+
+func (application *App) NewStorage() error {
+	var err error
+	application.Storage, err = application.Config.NewStorage()
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+//NewPolicy - загрузка политики ссылок из файла конфигурации, вызывается до создания роутера
+func (application *App) NewPolicy() error {
+	var err error
+	application.Policy, err = application.Config.NewPolicy()
+	return err
+}
+
+//Shutdown - остановка фоновых задач, обработка оставшихся заданий на удаление, запись переходов и закрытие хранилища.
+//Вызывается после остановки серверов, не завершившиеся к этому времени обработчики получают отказ в удалении.
+//Если обработчики удаления не завершились до истечения ctx, хранилище не закрывается и возвращается ошибка ctx
+func (application *App) Shutdown(ctx context.Context) error {
+	close(application.stop)
+	<-application.swept
+	application.Service.Close()
+	var err error
+	//завершенная очистка имеет приоритет над одновременно истекшим ctx
+	select {
+	case <-application.cleaned:
+	default:
+		select {
+		case <-application.cleaned:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+	application.Clicks.Close()
+	application.Policy.Close()
+	if err != nil {
+		log.Println("Delete queue draining interrupted, storage is left open:", err)
+		return err
+	}
+	return application.Storage.Close()
+}
+
+//NewWebProcessor - создание новго роутера для обработки веб запросов
+//  workers int - количество потоков для удаления сокращенных ссылок
+func (application *App) NewWebProcessor(workers int) *chi.Mux {
+	application.stop = make(chan struct{})
+	application.swept = make(chan struct{})
+	application.cleaned = make(chan struct{})
+	go func() {
+		application.Storage.Cleaner(application.DelBuf, workers)
+		close(application.cleaned)
+	}()
+	go application.sweeper(sweepInterval)
+	application.Clicks = storage.NewClickRecorder(application.Storage, application.Config.Timeouts().Long)
+	application.Service = service.New(application.Storage, application.Config, application.DelBuf, application.Clicks, application.Policy)
+	application.subnet = trustedSubnet(application.Config.TrustedSubnet)
+	r := chi.NewRouter()
+	application.middlewares(r)
+	r.Route("/", application.router)
+	return r
+}
+
+//Router - creates chi router and cleaner
+func (application *App) router(r chi.Router) {
+	r.Get("/", defaultGetHandler)
+	r.Get("/ping", application.connectionTest)
+	r.Get("/{tag}", application.getHandler)
+	r.Get("/api/user/urls", application.userURLs)
+	r.Get("/api/user/urls/{tag}/stats", application.userStats)
+	r.Get("/api/internal/stats", application.internalStats)
+	r.Post("/", application.postHandler)
+	r.Post("/api/shorten", application.postAPIHandler)
+	r.Post(batchPath, application.postAPIBatch)
+	r.Delete("/api/user/urls", application.deleteTags)
+	r.Get("/swagger/*", httpSwagger.Handler(httpSwagger.URL(application.Config.BaseURL+"/swagger/doc.json")))
+	r.MethodNotAllowed(otherHandler)
+	r.NotFound(otherHandler)
+}
+
+//defaultGetHandler - handler for "/"
+func defaultGetHandler(w http.ResponseWriter, r *http.Request) {
+	http.Error(w, "Empty request", http.StatusBadRequest)
+}
+
+//otherHandler - handler for unknown request and unknow paths
+func otherHandler(w http.ResponseWriter, r *http.Request) {
+	http.Error(w, "Bad request", http.StatusBadRequest)
+}
+
+// Ping godoc
+// @Tags Ping
+// @Summary Запрос состояния хранилища
+// @Accept text/plain
+// @Produce text/plain
+// @Success 200   "Проверка подключения к хранилищу успешно завершено"
+// @Failure 500   "Хранилище недоступно"
+// @Router / [get]
+// ConnectionTest - handler for "/ping"
+func (application *App) connectionTest(w http.ResponseWriter, r *http.Request) {
+	err := application.Storage.Ping(r.Context())
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Storage connection failed", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte{})
+}
+
+// Create godoc
+// @Tags ListAll
+// @Summary Запрос на получение всех сокращенных ссылок пользователя
+// @Accept text/plain
+// @Produce application/json
+// @Param Client_ID header string true "Идентификационный cookie Client_ID"
+// @Success 202   "Сокращения в базе данных отсутствуют"
+// @Success 200 {array} answer "Список сокращений пользователя"
+// @Failure 500 {string} string "Внутренняя ошибка сервера"
+// @Router /api/user/urls [get]
+// userURLs - handler for "/api/user/urls" GET Method
+func (application *App) userURLs(w http.ResponseWriter, r *http.Request) {
+	cookie := idCookieValue(r)
+	urls, err := application.Service.List(r.Context(), cookie)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if len(urls) == 0 {
+		http.Error(w, "No Content", http.StatusNoContent)
+		return
+	}
+	a := make([]answer, 0, len(urls))
+	for _, content := range urls {
+		a = append(a, answer{Short: content.ShortURL, Original: content.OriginalURL})
+	}
+	d, err := json.Marshal(a)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Json Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(d)
+}
+
+// Stats godoc
+// @Tags ListAll
+// @Summary Запрос статистики переходов по сокращенной ссылке пользователя
+// @Accept text/plain
+// @Produce application/json
+// @Param Client_ID header string true "Идентификационный cookie Client_ID"
+// @Param tag path string true "Идентификатор короткой ссылки"
+// @Success 200 {object} models.Stats "Статистика переходов"
+// @Failure 400   "Неверный идентификатор короткой ссылки"
+// @Failure 404   "Короткая ссылка не принадлежит пользователю"
+// @Failure 500 {string} string "Внутренняя ошибка сервера"
+// @Router /api/user/urls/{tag}/stats [get]
+// userStats - handler for "/api/user/urls/{tag}/stats" GET Method
+func (application *App) userStats(w http.ResponseWriter, r *http.Request) {
+	cookie := idCookieValue(r)
+	stats, err := application.Service.Stats(r.Context(), cookie, chi.URLParam(r, "tag"))
+	if err != nil {
+		serviceError(w, err)
+		return
+	}
+	d, err := json.Marshal(stats)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Json Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(d)
+}
+
+// InternalStats godoc
+// @Tags Internal
+// @Summary Запрос количества сокращенных ссылок и пользователей сервиса
+// @Accept text/plain
+// @Produce application/json
+// @Param X-Real-IP header string true "IP адрес клиента из доверенной подсети"
+// @Success 200 {object} internalStats "Статистика сервиса"
+// @Failure 403   "Клиент не входит в доверенную подсеть"
+// @Failure 500   "Внутренняя ошибка сервера"
+// @Router /api/internal/stats [get]
+// internalStats - handler for "/api/internal/stats" GET Method
+func (application *App) internalStats(w http.ResponseWriter, r *http.Request) {
+	if !application.trusted(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	var err error
+	stats := internalStats{}
+	stats.URLs, err = application.Storage.CountURLs(r.Context())
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	stats.Users, err = application.Storage.CountUsers(r.Context())
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	d, err := json.Marshal(stats)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Json Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(d)
+}
+
+//trustedSubnet - разбор доверенной подсети из конфигурации, nil если подсеть не задана или задана неверно
+func trustedSubnet(cidr string) *net.IPNet {
+	if cidr == "" {
+		return nil
+	}
+	_, subnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		log.Println("Wrong trusted subnet:", err)
+		return nil
+	}
+	return subnet
+}
+
+//trusted - проверка принадлежности X-Real-IP клиента доверенной подсети
+func (application *App) trusted(r *http.Request) bool {
+	if application.subnet == nil {
+		return false
+	}
+	ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP")))
+	return ip != nil && application.subnet.Contains(ip)
+}
+
+// Create godoc
+// @Tags Create
+// @Summary Запрос на сокращение ссылки
+// @Accept text/plain
+// @Produce text/plain
+// @Param Input body string true "Сокращаемый URL"
+// @Param Client_ID header string false "Идентификационный cookie Client_ID"
+// @Success 201 {string} string "Создана новая сокращенная ссылка"
+// @Success 409 {string} string "Запрашиваемый URL уже существует"
+// @Failure 400 {string} string "Недопустимый URL: разрешены только http и https ссылки с указанием хоста"
+// @Failure 422 {string} string "URL запрещен политикой, в ответе указан идентификатор правила"
+// @Failure 413 {string} string "Распакованное тело запроса превышает MAX_DECOMPRESSED_SIZE"
+// @Failure 415 {string} string "Неподдерживаемый Content-Encoding, поддерживаются gzip, deflate и br"
+// @Failure 500   "Внутренняя ошибка сервера"
+// @Router / [post]
+// postHandler - handler for "/" POST Method
+func (application *App) postHandler(w http.ResponseWriter, r *http.Request) {
+	cookie := idCookieValue(r)
+	defer r.Body.Close()
+	blongURL, err := io.ReadAll(r.Body)
+	if bodyError(w, err) {
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	slongURL := string(blongURL)
+	log.Println("Request body:", slongURL)
+	result, err := application.Service.Shorten(r.Context(), cookie, service.Request{URL: slongURL})
+	if err != nil {
+		serviceError(w, err)
+		return
+	}
+	if result.Existed {
+		w.WriteHeader(http.StatusConflict)
+	} else {
+		w.WriteHeader(http.StatusCreated)
+	}
+	w.Write([]byte(result.ShortURL))
+}
+
+// APICreate godoc
+// @Tags APICreate
+// @Summary Запрос на сокращение ссылки
+// @Accept application/json
+// @Produce application/json
+// @Param Client_ID header string false "Идентификационный cookie Client_ID"
+// @Param Input body lURL true "Сокращаемый URL"
+// @Success 201 {object} sURL "Создана новая сокращенная ссылка"
+// @Success 409 {object} sURL "Запрашиваемый URL уже существует или псевдоним занят"
+// @Failure 400 {string} string "Неверный запрос, недопустимый URL или псевдоним"
+// @Failure 422 {string} string "URL запрещен политикой, в ответе указан идентификатор правила"
+// @Failure 413 {string} string "Распакованное тело запроса превышает MAX_DECOMPRESSED_SIZE"
+// @Failure 415 {string} string "Неподдерживаемый Content-Encoding, поддерживаются gzip, deflate и br"
+// @Failure 500   "Внутренняя ошибка сервера"
+// @Router /api/shorten [post]
+// postAPIHandler - handler for "/api/shorten" POST Method
+func (application *App) postAPIHandler(w http.ResponseWriter, r *http.Request) {
+	cookie := idCookieValue(r)
+	defer r.Body.Close()
+	ctype := r.Header.Get("Content-Type")
+	if ctype != "application/json" {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	longURL := lURL{}
+	body, err := io.ReadAll(r.Body)
+	if bodyError(w, err) {
+		return
+	}
+	if err != nil {
+		log.Println("Body Error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	log.Println("Request body:", string(body))
+	err = json.Unmarshal(body, &longURL)
+	if err != nil {
+		log.Println("JSON Unmarshal error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	result, err := application.Service.Shorten(r.Context(), cookie, service.Request{URL: longURL.LongURL, Alias: longURL.Alias, ExpiresAt: longURL.ExpiresAt, TTL: longURL.TTL})
+	if err != nil {
+		serviceError(w, err)
+		return
+	}
+	abody, err := json.Marshal(sURL{ShortURL: result.ShortURL})
+	if err != nil {
+		log.Println("JSON Marshal error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if result.Existed {
+		w.WriteHeader(http.StatusConflict)
+	} else {
+		w.WriteHeader(http.StatusCreated)
+	}
+	w.Write(abody)
+}
+
+// APICreateBatch godoc
+// @Tags APICreate
+// @Summary Запрос на сокращение ссылок списком
+// @Description Список читается и обрабатывается потоково частями по 1000 элементов, каждая часть записывается атомарно.
+// @Description Код ответа определяется первой частью списка. Каждому элементу списка соответствует один результат.
+// @Description Если обработка прервана ошибкой после записи предыдущих частей, последней записью ответа передается
+// @Description объект без correlation_id со статусом invalid и причиной в поле error.
+// @Description С заголовком X-Full-Duplex: true результаты частей отправляются клиенту во время чтения списка,
+// @Description клиент должен читать ответ одновременно с отправкой запроса. Без заголовка ответ отправляется после чтения всего списка,
+// @Description результаты до отправки накапливаются во временном файле.
+// @Description Ссылки, запрещенные политикой, возвращаются со статусом invalid и идентификатором правила в поле error.
+// @Accept application/json
+// @Accept application/x-ndjson
+// @Produce application/json
+// @Produce application/x-ndjson
+// @Param Client_ID header string false "Идентификационный cookie Client_ID"
+// @Param X-Full-Duplex header bool false "Клиент читает ответ во время отправки списка"
+// @Param Input body []input true "Список сокращаемых URLs: JSON массив или по одному объекту в строке"
+// @Success 201 {array} output "Список обработан, хотя бы одна ссылка сокращена"
+// @Success 200 {array} output "Список обработан, новых ссылок нет"
+// @Failure 400   "Неверный запрос"
+// @Failure 413 {string} string "Распакованное тело запроса превышает MAX_DECOMPRESSED_SIZE"
+// @Failure 415 {string} string "Неподдерживаемый Content-Encoding, поддерживаются gzip, deflate и br"
+// @Failure 500   "Внутренняя ошибка сервера"
+// @Router /api/shorten/batch [post]
+// postAPIBatch - handler for "/api/shorten/batch" POST Method
+func (application *App) postAPIBatch(w http.ResponseWriter, r *http.Request) {
+	cookie := idCookieValue(r)
+	defer r.Body.Close()
+	ctype, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || (ctype != batchJSON && ctype != batchNDJSON) {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	dec, err := newBatchDecoder(r.Body, ctype == batchJSON)
+	if bodyError(w, err) {
+		return
+	}
+	if err != nil {
+		log.Println("JSON decode error", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	var enc *batchEncoder
+	for done := false; !done; {
+		reqs, err := dec.chunk(batchChunk)
+		done = errors.Is(err, io.EOF)
+		if err != nil && !done {
+			log.Println("JSON decode error", err)
+			switch {
+			case enc != nil:
+				batchFail(enc, "Bad request: "+err.Error())
+			case !bodyError(w, err):
+				http.Error(w, "Bad request", http.StatusBadRequest)
+			}
+			return
+		}
+		results, err := application.Service.ShortenBatch(r.Context(), cookie, reqs)
+		if err != nil {
+			if enc == nil {
+				serviceError(w, err)
+			} else {
+				log.Println(err)
+				batchFail(enc, "Internal Server Error")
+			}
+			return
+		}
+		out, code := batchOutput(results)
+		if enc == nil {
+			enc = newBatchEncoder(w, ctype == batchJSON, code, mymiddlewares.IsFullDuplex(r.Context()))
+			defer enc.release()
+		}
+		err = enc.write(out)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+	}
+	err = enc.close()
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+//batchFail - завершение начатого ответа записью о прерывании обработки списка
+func batchFail(enc *batchEncoder, reason string) {
+	err := enc.fail(reason)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// getHandler - handler for "/{short_tag}" GET Method
+//cjover short url to original url
+func (application *App) getHandler(w http.ResponseWriter, r *http.Request) {
+	long, err := application.Service.Resolve(r.Context(), chi.URLParam(r, "tag"), visit(r))
+	if err != nil {
+		serviceError(w, err)
+		return
+	}
+	w.Header().Set("Location", long)
+	w.WriteHeader(http.StatusTemporaryRedirect)
+	w.Write([]byte{})
+}
+
+// APIDeleteShort godoc
+// @Tags APIDelete
+// @Summary Запрос на удаление короткой ссылки
+// @Accept application/json
+// @Produce application/json
+// @Param Input body []string true "Список удаляемых коротких идентификаторов"
+// @Param Client_ID header string true "Идентификационный cookie Client_ID"
+// @Success 202   "Запрос принят в обработку"
+// @Failure 400 {string} string "Неверный JSON или идентификатор в теле запроса"
+// @Failure 413 {string} string "Распакованное тело запроса превышает MAX_DECOMPRESSED_SIZE"
+// @Failure 415 {string} string "Неподдерживаемый Content-Encoding, поддерживаются gzip, deflate и br"
+// @Failure 500   "Внутренняя ошибка сервера"
+// @Router /api/user/urls [delete]
+// deleteTags - handler for "/api/user/urls" DELETE Method
+func (application *App) deleteTags(w http.ResponseWriter, r *http.Request) {
+	cookie := idCookieValue(r)
+	defer r.Body.Close()
+	body, err := io.ReadAll(r.Body)
+	if bodyError(w, err) {
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	var tags []string
+	err = json.Unmarshal(body, &tags)
+	if err != nil {
+		log.Println("JSON decode error", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	err = application.Service.Delete(r.Context(), cookie, tags)
+	if err != nil {
+		serviceError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	w.Write([]byte{})
+}
+
+//visit - сведения о клиенте из запроса
+func visit(r *http.Request) service.Visit {
+	return service.Visit{Addr: r.RemoteAddr, Referrer: r.Referer(), UserAgent: r.UserAgent(), Country: clientCountry(r.Header.Get)}
+}
+
+//serviceError - ответ с кодом состояния, соответствующим ошибке сервиса
+func serviceError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrInvalid):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, service.ErrAliasTaken):
+		http.Error(w, "Custom alias is taken", http.StatusConflict)
+	case errors.Is(err, service.ErrBlocked):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	case errors.Is(err, service.ErrNotFound):
+		http.Error(w, "Not Found", http.StatusNotFound)
+	case errors.Is(err, service.ErrGone):
+		w.WriteHeader(http.StatusGone)
+		w.Write([]byte{})
+	case errors.Is(err, service.ErrClosed):
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+	default:
+		log.Println(err)
+		http.Error(w, "Storage error", http.StatusInternalServerError)
+	}
+}
+
+//bodyError - ответ на ошибку распаковки тела запроса, false для прочих ошибок чтения
+func bodyError(w http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, mymiddlewares.ErrBodyTooLarge):
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+	case errors.Is(err, mymiddlewares.ErrMalformedBody):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		return false
+	}
+	return true
+}
+
+//clientCountry - код страны клиента из заголовков прокси
+func clientCountry(header func(string) string) string {
+	for _, name := range countryHeaders {
+		country := strings.ToUpper(strings.TrimSpace(header(name)))
+		if country != "" && country != "XX" {
+			return country
+		}
+	}
+	return ""
+}
+
+//sweeper - периодическая пометка ссылок с истекшим сроком действия как удаленных
+func (application *App) sweeper(interval time.Duration) {
+	defer close(application.swept)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			application.sweep(context.Background())
+		case <-application.stop:
+			return
+		}
+	}
+}
+
+//sweep - передача ссылок с истекшим сроком действия обработчикам удаления
+func (application *App) sweep(ctx context.Context) {
+	tasks, err := application.Storage.Expired(ctx, time.Now())
+	if err != nil {
+		log.Println("Expired urls search failed:", err)
+		return
+	}
+	for _, task := range tasks {
+		select {
+		case application.DelBuf <- task:
+		case <-application.stop:
+			return
+		}
+	}
+}
+
+//middlewares - middleware definition
+func (application *App) middlewares(r *chi.Mux) {
+	r.Use(mymiddlewares.FullDuplex(batchPath))
+	r.Use(middleware.Compress(5))
+	r.Use(middleware.RequestID)
+	r.Use(middleware.RealIP)
+	r.Use(middleware.Logger)
+	r.Use(middleware.Recoverer)
+	r.Use(mymiddlewares.DecompressRequestAndTimeTracer(application.Config.MaxDecompressedSize))
+	r.Use(application.cookieProcessor)
+}
+
+//idCookieValue - идентификатор пользователя, проверенный или выданный cookieProcessor
+func idCookieValue(r *http.Request) string {
+	return clientID(r.Context())
+}
+
+//cookieProcessor - проверка подписи Client_ID, при отсутствии или неверной подписи создается новый пользователь.
+//Идентификатор пользователя передается обработчикам в контексте запроса
+func (application *App) cookieProcessor(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := ""
+		for _, cookie := range r.Cookies() {
+			if cookie.Name == "Client_ID" && application.checkCookie(r.Context(), cookie) {
+				id = cookie.Value[:32]
+				break
+			}
+		}
+		if id == "" {
+			id = helpers.RandStringRunes(32)
+			key := helpers.RandStringRunes(64)
+			if !application.addCookie(r.Context(), w, "Client_ID", id, key) {
+				return
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientIDKey{}, id)))
+	})
+}
+
+//signID - идентификатор пользователя с подписью ключом пользователя
+func signID(value, key string) string {
+	h := hmac.New(sha256.New, []byte(key))
+	h.Write([]byte(value))
+	return value + hex.EncodeToString(h.Sum(nil))
+}
+
+//validID - проверка подписи идентификатора пользователя
+func (application *App) validID(ctx context.Context, id string) bool {
+	if len(id) != 96 {
+		return false
+	}
+	data, err := application.Storage.ReadByCookie(ctx, id[:32])
+	if err != nil {
+		return false
+	}
+	return hmac.Equal([]byte(id), []byte(signID(data.Cookie, data.Key)))
+}
+
+//addCookie - add cookie to response, false if user is not saved and error is answered
+func (application *App) addCookie(ctx context.Context, w http.ResponseWriter, name, value string, key string) bool {
+	cookie := http.Cookie{
+		Name:   name,
+		Value:  signID(value, key),
+		MaxAge: 0,
+		Path:   "/",
+	}
+	entry := models.ClientData{}
+	entry.Cookie = value
+	entry.Key = key
+	entry.Short = make([]models.ShortData, 0)
+	err := application.Storage.Write(ctx, entry)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return false
+	}
+	http.SetCookie(w, &cookie)
+	return true
+}
+
+//checkCookie - cookie validation
+func (application *App) checkCookie(ctx context.Context, cookie *http.Cookie) bool {
+	return application.validID(ctx, cookie.Value)
+}
@@ -1,765 +1,1297 @@
-package webhandlers
-
-import (
-	"bytes"
-	"compress/gzip"
-	"encoding/json"
-	"fmt"
-	"io"
-	"io/ioutil"
-	"net/http"
-	"net/http/cookiejar"
-	"net/http/httptest"
-	"regexp"
-	"strings"
-	"testing"
-	"time"
-
-	"github.com/go-chi/chi"
-	"github.com/stretchr/testify/require"
-
-	"github.com/t1mon-ggg/go_shortner/app/helpers"
-	"github.com/t1mon-ggg/go_shortner/app/models"
-)
-
-func newServer(t *testing.T) (*cookiejar.Jar, *chi.Mux, *App) {
-	jar, err := cookiejar.New(nil)
-	require.NoError(t, err)
-	db := NewApp()
-	db.Storage, err = db.Config.NewStorage()
-	require.NoError(t, err)
-	require.NoError(t, err)
-	require.NoError(t, err)
-	r := db.NewWebProcessor(10)
-	return jar, r, db
-}
-
-func gziped(ctype map[string]string) bool {
-	for i := range ctype {
-		if i == "Content-Encoding" && ctype[i] == "gzip" {
-			return true
-		}
-	}
-	return false
-}
-
-func compress(data []byte) ([]byte, error) {
-	var b bytes.Buffer
-	gz := gzip.NewWriter(&b)
-	if _, err := gz.Write([]byte(data)); err != nil {
-		return nil, err
-	}
-	if err := gz.Close(); err != nil {
-		return nil, err
-	}
-	return b.Bytes(), nil
-}
-
-func decompress(data []byte) ([]byte, error) {
-	reader := bytes.NewReader(data)
-	gz, err := gzip.NewReader(reader)
-	if err != nil {
-		return nil, err
-	}
-	unzipped, err := io.ReadAll(gz)
-	if err != nil {
-		return nil, err
-	}
-	return unzipped, nil
-}
-func testRequest(t *testing.T, ts *httptest.Server, jar *cookiejar.Jar, method, path, body string, ctype map[string]string) (*http.Response, string) {
-
-	client := &http.Client{
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			return http.ErrUseLastResponse
-		},
-		Jar: jar,
-	}
-	var bodyreq *strings.Reader
-	if gziped(ctype) {
-		c, _ := compress([]byte(body))
-		bodyreq = strings.NewReader(string(c))
-	} else {
-		bodyreq = strings.NewReader(body)
-	}
-	req, err := http.NewRequest(method, ts.URL+path, bodyreq)
-	require.NoError(t, err)
-	for i := range ctype {
-		req.Header.Set(i, ctype[i])
-	}
-
-	resp, err := client.Do(req)
-	require.NoError(t, err)
-
-	respBody, err := ioutil.ReadAll(resp.Body)
-	require.NoError(t, err)
-	defer resp.Body.Close()
-
-	return resp, string(respBody)
-}
-
-// Test_defaultGetHandler - тестирование корневого хендлера
-func Test_defaultGetHandler(t *testing.T) {
-	jar, r, _ := newServer(t)
-	ts := httptest.NewServer(r)
-	defer ts.Close()
-	t.Run("Test default Get handler", func(t *testing.T) {
-		ctype := map[string]string{
-			"Content-Type": "text/plain; charset=utf-8",
-		}
-		response, _ := testRequest(t, ts, jar, http.MethodGet, "/", "", ctype)
-		defer response.Body.Close()
-		require.Equal(t, http.StatusBadRequest, response.StatusCode)
-	})
-}
-
-//Test_otherHandler - тестирование методов отличных от используемых
-func Test_otherHandler(t *testing.T) {
-	jar, r, _ := newServer(t)
-	ts := httptest.NewServer(r)
-	defer ts.Close()
-	t.Run("Test other method handler", func(t *testing.T) {
-		ctype := map[string]string{
-			"Content-Type": "text/plain; charset=utf-8",
-		}
-		response, _ := testRequest(t, ts, jar, http.MethodPut, "/", "", ctype)
-		defer response.Body.Close()
-		require.Equal(t, http.StatusBadRequest, response.StatusCode)
-	})
-}
-
-//Test_CreateShortURL - тестирование создания короткой ссылки
-func Test_CreateShortURL(t *testing.T) {
-	jar, r, _ := newServer(t)
-	ts := httptest.NewServer(r)
-	defer ts.Close()
-	t.Run("Test default Get handler", func(t *testing.T) {
-		ctype := map[string]string{
-			"Content-Type": "text/plain; charset=utf-8",
-		}
-		response, body := testRequest(t, ts, jar, http.MethodPost, "/", "http://example.org/", ctype)
-		defer response.Body.Close()
-		require.Equal(t, http.StatusCreated, response.StatusCode)
-
-		matched, err := regexp.Match(`\w{8}`, []byte(body))
-		if err != nil {
-			t.Fatal("Regexp error")
-		}
-		require.Equal(t, true, matched)
-	})
-}
-
-//Test_UnshortStatic - теститрование обратного преобразования короткой ссылки в исходную
-func Test_UnshortStatic(t *testing.T) {
-	type wanted struct {
-		statusCode int
-		body       string
-	}
-	type arg struct {
-		method string
-		query  string
-		body   string
-		ctype  map[string]string
-		want   wanted
-	}
-
-	tests := []struct {
-		name string
-		args arg
-	}{
-		{
-			name: "NotExistent",
-			args: arg{
-				method: http.MethodGet,
-				query:  "/jdpijvHG",
-				body:   "",
-				ctype: map[string]string{
-					"Content-Type": "text/plain; charset=utf-8",
-				},
-
-				want: wanted{
-					statusCode: 404,
-					body:       "",
-				},
-			},
-		},
-		{
-			name: "WrongShort",
-			args: arg{
-				method: http.MethodGet,
-				query:  "/jdpi",
-				body:   "",
-				ctype: map[string]string{
-					"Content-Type": "text/plain; charset=utf-8",
-				},
-
-				want: wanted{
-					statusCode: 400,
-					body:       "",
-				},
-			},
-		},
-		{
-			name: "WrongLong",
-			args: arg{
-				method: http.MethodGet,
-				query:  "/jdpiaaaaaaaaaaaaaaaaaaaaaaaaaaa",
-				body:   "",
-				ctype: map[string]string{
-					"Content-Type": "text/plain; charset=utf-8",
-				},
-
-				want: wanted{
-					statusCode: 400,
-					body:       "",
-				},
-			},
-		},
-		{
-			name: "Static",
-			args: arg{
-				method: http.MethodGet,
-				query:  "/abcdABCD",
-				body:   "",
-				ctype: map[string]string{
-					"Content-Type": "text/plain; charset=utf-8",
-				},
-
-				want: wanted{
-					statusCode: 307,
-					body:       "http://example.org",
-				},
-			},
-		},
-	}
-	jar, r, db := newServer(t)
-	db.Storage.Write(models.ClientData{Cookie: "cookie1", Key: "secret_key", Short: []models.ShortData{{Short: "abcdABCD", Long: "http://example.org"}}})
-	ts := httptest.NewServer(r)
-	defer ts.Close()
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			response, _ := testRequest(t, ts, jar, http.MethodGet, tt.args.query, "", tt.args.ctype)
-			defer response.Body.Close()
-			require.Equal(t, tt.args.want.statusCode, response.StatusCode)
-			if tt.name == "Static" {
-				require.Equal(t, response.Header.Get("Location"), tt.args.want.body)
-			}
-		})
-	}
-}
-
-//Test_2WayTest - теститрование обратного преобразования короткой ссылки в исходную
-func Test_2WayTest(t *testing.T) {
-	jar, r, _ := newServer(t)
-	ts := httptest.NewServer(r)
-	defer ts.Close()
-	t.Run("Test 2way test", func(t *testing.T) {
-		ctype := map[string]string{
-			"Content-Type": "text/plain; charset=utf-8",
-		}
-		expected := "http://example.org"
-		response1, body := testRequest(t, ts, jar, http.MethodPost, "/", expected, ctype)
-		defer response1.Body.Close()
-		require.Equal(t, http.StatusCreated, response1.StatusCode)
-		short := strings.Split(body, "/")
-		query := "/" + short[len(short)-1]
-		response2, _ := testRequest(t, ts, jar, http.MethodGet, query, "", ctype)
-		defer response2.Body.Close()
-		require.Equal(t, http.StatusTemporaryRedirect, response2.StatusCode)
-		require.Equal(t, expected, response2.Header.Get("Location"))
-	})
-}
-
-//Test_APIShort - тестирование сокращателя через API
-func Test_APIShort(t *testing.T) {
-	jar, r, _ := newServer(t)
-	ts := httptest.NewServer(r)
-	defer ts.Close()
-	t.Run("Test default Get handler", func(t *testing.T) {
-		ctype := map[string]string{
-			"Content-Type": "application/json",
-		}
-		type req struct {
-			URL string `json:"url"` //{"url":"<some_url>"}
-		}
-		s := req{URL: "http://example.org"}
-		b, err := json.Marshal(s)
-		require.NoError(t, err)
-		response, body := testRequest(t, ts, jar, http.MethodPost, "/api/shorten", string(b), ctype)
-		defer response.Body.Close()
-		matched, err := regexp.Match(`{"result":\"http:\/\/\w+\.\w+\.\w\.\w:\d+\/\w{8}\"}`, []byte(body))
-		require.NoError(t, err)
-		require.True(t, matched)
-	})
-}
-
-//Test_API2Way - тестирование двухстороннего обмена через API
-func Test_API2Way(t *testing.T) {
-	jar, r, _ := newServer(t)
-	ts := httptest.NewServer(r)
-	defer ts.Close()
-	t.Run("2Way API", func(t *testing.T) {
-		ctype := map[string]string{
-			"Content-Type": "application/json",
-		}
-		type req struct {
-			URL string `json:"url"` //{"url":"<some_url>"}
-		}
-		type answer struct {
-			A string `json:"result"` //{"result":"<short_url>"}
-		}
-		s := req{URL: "http://example.org"}
-		b, err := json.Marshal(s)
-		require.NoError(t, err)
-		response, body := testRequest(t, ts, jar, http.MethodPost, "/api/shorten", string(b), ctype)
-		defer response.Body.Close()
-		require.Equal(t, "application/json", response.Header.Get("Content-Type"))
-		require.Equal(t, http.StatusCreated, response.StatusCode)
-		ss := answer{}
-		err = json.Unmarshal([]byte(body), &ss)
-		require.NoError(t, err)
-		url := ss.A
-		query := strings.Split(url, "/")
-		q := fmt.Sprintf("/%s", query[len(query)-1])
-		response, _ = testRequest(t, ts, jar, http.MethodGet, q, "", map[string]string{"Content-Type": "text/plain; charset=utf-8"})
-		defer response.Body.Close()
-		require.Equal(t, http.StatusTemporaryRedirect, response.StatusCode)
-		require.Equal(t, s.URL, response.Header.Get("Location"))
-	})
-}
-
-//Test_ZippedRequest - теститрование сжатого запроса
-func Test_ZippedRequest(t *testing.T) {
-	jar, r, _ := newServer(t)
-	ts := httptest.NewServer(r)
-	defer ts.Close()
-	t.Run("Test default Get handler", func(t *testing.T) {
-		ctype := map[string]string{
-			"Content-Type":     "application/json",
-			"Content-Encoding": "gzip",
-		}
-		type req struct {
-			URL string `json:"url"` //{"url":"<some_url>"}
-		}
-		s := req{URL: "http://example.org"}
-		b, err := json.Marshal(s)
-		require.NoError(t, err)
-		response, body := testRequest(t, ts, jar, http.MethodPost, "/api/shorten", string(b), ctype)
-		defer response.Body.Close()
-		matched, err := regexp.Match(`{"result":\"http:\/\/\w+\.\w+\.\w\.\w:\d+\/\w{8}\"}`, []byte(body))
-		require.NoError(t, err)
-		require.True(t, matched)
-	})
-}
-
-//Test_ZippedAnswer - тестирование сжатия ответа с сервера
-func Test_ZippedAnswer(t *testing.T) {
-	jar, r, _ := newServer(t)
-	ts := httptest.NewServer(r)
-	defer ts.Close()
-	t.Run("Test default Get handler", func(t *testing.T) {
-		ctype := map[string]string{
-			"Content-Type":    "application/json",
-			"Accept-Encoding": "gzip",
-		}
-		type req struct {
-			URL string `json:"url"` //{"url":"<some_url>"}
-		}
-		s := req{URL: "http://example.org"}
-		b, err := json.Marshal(s)
-		require.NoError(t, err)
-		response, body := testRequest(t, ts, jar, http.MethodPost, "/api/shorten", string(b), ctype)
-		defer response.Body.Close()
-		require.Equal(t, "gzip", response.Header.Get("Content-Encoding"))
-		a, err := decompress([]byte(body))
-		require.NoError(t, err)
-		matched, err := regexp.Match(`{"result":\"http:\/\/\w+\.\w+\.\w\.\w:\d+\/\w{8}\"}`, a)
-		require.NoError(t, err)
-		require.True(t, matched)
-	})
-}
-
-//Test_2WayZip - тестирование работы со сжатием данных в обе стороны
-func Test_2WayZip(t *testing.T) {
-	jar, r, _ := newServer(t)
-	ts := httptest.NewServer(r)
-	defer ts.Close()
-	t.Run("Test default Get handler", func(t *testing.T) {
-		ctype := map[string]string{
-			"Content-Type":     "application/json",
-			"Accept-Encoding":  "gzip",
-			"Content-Encoding": "gzip",
-		}
-		type req struct {
-			URL string `json:"url"` //{"url":"<some_url>"}
-		}
-		s := req{URL: "http://example.org"}
-		b, err := json.Marshal(s)
-		require.NoError(t, err)
-		response, body := testRequest(t, ts, jar, http.MethodPost, "/api/shorten", string(b), ctype)
-		defer response.Body.Close()
-		require.Equal(t, "gzip", response.Header.Get("Content-Encoding"))
-		a, err := decompress([]byte(body))
-		require.NoError(t, err)
-		matched, err := regexp.Match(`{"result":\"http:\/\/\w+\.\w+\.\w\.\w:\d+\/\w{8}\"}`, a)
-		require.NoError(t, err)
-		require.True(t, matched)
-	})
-}
-
-//Test_UserURLs - тестиирование получения всех ссылок пользователя
-func Test_UserURLs(t *testing.T) {
-	type wanted struct {
-		statusCode int
-		body       string
-	}
-	type arg struct {
-		method string
-		query  string
-		body   string
-		ctype  map[string]string
-		want   wanted
-	}
-	tests := []struct {
-		name string
-		args arg
-	}{
-		{
-			name: "Not existent cookie",
-			args: arg{
-				method: http.MethodGet,
-				query:  "/api/user/urls",
-				body:   "",
-				ctype: map[string]string{
-					"Content-Type": "text/plain; charset=utf-8",
-				},
-				want: wanted{
-					statusCode: http.StatusNoContent,
-					body:       "",
-				},
-			},
-		},
-		{
-			name: "Existent cookie",
-			args: arg{
-				method: http.MethodGet,
-				query:  "/api/user/urls",
-				body:   "",
-				ctype: map[string]string{
-					"Content-Type": "text/plain; charset=utf-8",
-				},
-				want: wanted{
-					statusCode: http.StatusNoContent,
-					body:       "",
-				},
-			},
-		},
-		{
-			name: "Wrong cookie",
-			args: arg{
-				method: http.MethodGet,
-				query:  "/",
-				body:   "",
-				ctype: map[string]string{
-					"Content-Type": "text/plain; charset=utf-8",
-				},
-				want: wanted{
-					statusCode: 0,
-					body:       "",
-				},
-			},
-		},
-	}
-	jar, r, _ := newServer(t)
-	ts := httptest.NewServer(r)
-	defer ts.Close()
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			jar, _ = cookiejar.New(nil)
-			switch tt.name {
-			case "Not existent cookie":
-				response, _ := testRequest(t, ts, jar, http.MethodGet, tt.args.query, "", tt.args.ctype)
-				defer response.Body.Close()
-				require.Equal(t, http.StatusNoContent, response.StatusCode)
-			case "Existent cookie":
-				response, _ := testRequest(t, ts, jar, http.MethodPost, "/", "http://example.org", map[string]string{
-					"Content-Type": "text/plain; charset=utf-8"})
-				defer response.Body.Close()
-				require.Equal(t, http.StatusCreated, response.StatusCode)
-				response, _ = testRequest(t, ts, jar, http.MethodPost, "/", "http://example2.org", map[string]string{
-					"Content-Type": "text/plain; charset=utf-8"})
-				defer response.Body.Close()
-				require.Equal(t, http.StatusCreated, response.StatusCode)
-				response, body := testRequest(t, ts, jar, http.MethodGet, tt.args.query, tt.args.body, tt.args.ctype)
-				defer response.Body.Close()
-				require.Equal(t, http.StatusOK, response.StatusCode)
-				require.Equal(t, "application/json", response.Header.Get("Content-Type"))
-				type answer struct {
-					Short    string `json:"short_url"`
-					Original string `json:"original_url"`
-				}
-				d := make([]answer, 0)
-				err := json.Unmarshal([]byte(body), &d)
-				require.NoError(t, err)
-			case "Wrong cookie":
-				response, _ := testRequest(t, ts, jar, http.MethodPost, tt.args.query, "http://example3.org", tt.args.ctype)
-				defer response.Body.Close()
-				cookies := jar.Cookies(response.Request.URL)
-				var cvalues []string
-				cvalues = make([]string, 0)
-				for _, c := range cookies {
-					cvalues = append(cvalues, c.Value)
-					c.Value = helpers.RandStringRunes(96)
-				}
-				response, _ = testRequest(t, ts, jar, http.MethodGet, tt.args.query, "", tt.args.ctype)
-				defer response.Body.Close()
-				cookies = jar.Cookies(response.Request.URL)
-				var mvalues []string
-				mvalues = make([]string, 0)
-				for _, m := range cookies {
-					mvalues = append(cvalues, m.Value)
-				}
-				require.NotEqual(t, cvalues, mvalues)
-
-			}
-		})
-	}
-}
-
-//Test_Ping - тестирование хранилища
-func Test_Ping(t *testing.T) {
-	jar, r, _ := newServer(t)
-	ts := httptest.NewServer(r)
-	defer ts.Close()
-	t.Run("Test Ping handler", func(t *testing.T) {
-		ctype := map[string]string{
-			"Content-Type": "text/plain; charset=utf-8",
-		}
-		response, _ := testRequest(t, ts, jar, http.MethodGet, "/ping", "", ctype)
-		defer response.Body.Close()
-		require.Equal(t, http.StatusOK, response.StatusCode)
-	})
-}
-
-//Test_Delete - удаления тегов
-func Test_Delete(t *testing.T) {
-	jar, r, _ := newServer(t)
-	ts := httptest.NewServer(r)
-	defer ts.Close()
-	type input struct {
-		Correlation string `json:"correlation_id"`
-		Long        string `json:"original_url"`
-	}
-	type output struct {
-		Correlation string `json:"correlation_id"`
-		Short       string `json:"short_url"`
-	}
-	response, _ := testRequest(t, ts, jar, http.MethodPost, "/", "", map[string]string{"Content-Type": "text/plain; charset=utf-8"})
-	defer response.Body.Close()
-	in := []input{
-		{Correlation: "12345",
-			Long: "http://example1.org"},
-		{Correlation: "12345",
-			Long: "http://example2.org"},
-		{Correlation: "12345",
-			Long: "http://example3.org"},
-		{Correlation: "12345",
-			Long: "http://example4.org"},
-		{Correlation: "12345",
-			Long: "http://example5.org"},
-		{Correlation: "12345",
-			Long: "http://example6.org"},
-		{Correlation: "12345",
-			Long: "http://example7.org"},
-		{Correlation: "12345",
-			Long: "http://example8.org"},
-		{Correlation: "12345",
-			Long: "http://example9.org"},
-		{Correlation: "12345",
-			Long: "http://example10.org"},
-		{Correlation: "12345",
-			Long: "http://example11.org"},
-		{Correlation: "12345",
-			Long: "http://example12.org"},
-		{Correlation: "12345",
-			Long: "http://example13.org"},
-		{Correlation: "12345",
-			Long: "http://example14.org"},
-		{Correlation: "12345",
-			Long: "http://example15.org"},
-		{Correlation: "12345",
-			Long: "http://example16.org"},
-		{Correlation: "12345",
-			Long: "http://example17.org"},
-		{Correlation: "12345",
-			Long: "http://example18.org"},
-		{Correlation: "12345",
-			Long: "http://example19.org"},
-		{Correlation: "12345",
-			Long: "http://example20.org"},
-	}
-	ctype := map[string]string{
-		"Content-Type": "application/json",
-	}
-	body, err := json.Marshal(in)
-	require.NoError(t, err)
-	response, astring := testRequest(t, ts, jar, http.MethodPost, "/api/shorten/batch", string(body), ctype)
-	defer response.Body.Close()
-	require.Equal(t, http.StatusCreated, response.StatusCode)
-	var answer []output
-	require.NoError(t, err)
-	err = json.Unmarshal([]byte(astring), &answer)
-	require.NoError(t, err)
-	require.Equal(t, len(in), len(answer))
-	del := make([]string, 0)
-	for i := 5; i < 12; i++ {
-		re := regexp.MustCompile(`\w{8}`)
-		match := re.FindString(answer[i].Short)
-		del = append(del, match)
-	}
-	for i := range del {
-		del[i] = fmt.Sprintf("\"%s\"", del[i])
-	}
-	s := strings.Join(del, ",")
-	s = "[" + s + "]"
-	response, _ = testRequest(t, ts, jar, http.MethodDelete, "/api/user/urls", s, ctype)
-	defer response.Body.Close()
-	require.Equal(t, http.StatusAccepted, response.StatusCode)
-	time.Sleep(25 * time.Second)
-	ctype = map[string]string{
-		"Content-Type": "text/plain; charset=utf-8",
-	}
-	for i := 5; i < 12; i++ {
-		re := regexp.MustCompile(`\w{8}`)
-		match := re.FindString(answer[i].Short)
-		response, _ = testRequest(t, ts, jar, http.MethodGet, fmt.Sprintf("/%s", match), s, ctype)
-		defer response.Body.Close()
-		require.Equal(t, http.StatusGone, response.StatusCode)
-	}
-}
-
-//Test_BatchAPI - массовое заполнение базы
-func Test_BatchAPI(t *testing.T) {
-	jar, r, _ := newServer(t)
-	ts := httptest.NewServer(r)
-	defer ts.Close()
-	type input struct {
-		Correlation string `json:"correlation_id"`
-		Long        string `json:"original_url"`
-	}
-	type output struct {
-		Correlation string `json:"correlation_id"`
-		Short       string `json:"short_url"`
-	}
-
-	in := []input{
-		{Correlation: "12345",
-			Long: "http://example1.org"},
-		{Correlation: "12345",
-			Long: "http://example2.org"},
-		{Correlation: "12345",
-			Long: "http://example3.org"},
-		{Correlation: "12345",
-			Long: "http://example4.org"},
-		{Correlation: "12345",
-			Long: "http://example5.org"},
-		{Correlation: "12345",
-			Long: "http://example6.org"},
-		{Correlation: "12345",
-			Long: "http://example7.org"},
-		{Correlation: "12345",
-			Long: "http://example8.org"},
-		{Correlation: "12345",
-			Long: "http://example9.org"},
-		{Correlation: "12345",
-			Long: "http://example10.org"},
-		{Correlation: "12345",
-			Long: "http://example11.org"},
-		{Correlation: "12345",
-			Long: "http://example12.org"},
-		{Correlation: "12345",
-			Long: "http://example13.org"},
-		{Correlation: "12345",
-			Long: "http://example14.org"},
-		{Correlation: "12345",
-			Long: "http://example15.org"},
-		{Correlation: "12345",
-			Long: "http://example16.org"},
-		{Correlation: "12345",
-			Long: "http://example17.org"},
-		{Correlation: "12345",
-			Long: "http://example18.org"},
-		{Correlation: "12345",
-			Long: "http://example19.org"},
-		{Correlation: "12345",
-			Long: "http://example20.org"},
-	}
-	ctype := map[string]string{
-		"Content-Type": "application/json",
-	}
-	body, err := json.Marshal(in)
-	require.NoError(t, err)
-	response, astring := testRequest(t, ts, jar, http.MethodPost, "/api/shorten/batch", string(body), ctype)
-	defer response.Body.Close()
-	require.Equal(t, http.StatusCreated, response.StatusCode)
-	var answer []output
-	require.NoError(t, err)
-	err = json.Unmarshal([]byte(astring), &answer)
-	require.NoError(t, err)
-	require.Equal(t, len(in), len(answer))
-}
-
-func Test_Conflict(t *testing.T) {
-	jar, r, _ := newServer(t)
-	ts := httptest.NewServer(r)
-	defer ts.Close()
-	t.Run("Test default Get handler", func(t *testing.T) {
-		response, _ := testRequest(t, ts, jar, http.MethodGet, "/", "", map[string]string{"Content-Type": "text/plain; charset=utf-8"})
-		defer response.Body.Close()
-		ctype := map[string]string{
-			"Content-Type": "text/plain; charset=utf-8",
-		}
-		s := "http://kiuerhv9unvr.org"
-		response, body1 := testRequest(t, ts, jar, http.MethodPost, "/", s, ctype)
-		defer response.Body.Close()
-		matched, err := regexp.Match(`http:\/\/\w+\.\w+\.\w\.\w:\d+\/\w{8}`, []byte(body1))
-		require.NoError(t, err)
-		require.True(t, matched)
-		time.Sleep(3 * time.Second)
-		response, body2 := testRequest(t, ts, jar, http.MethodPost, "/", s, ctype)
-		defer response.Body.Close()
-		require.Equal(t, http.StatusConflict, response.StatusCode)
-		require.Equal(t, body1, body2)
-
-	})
-}
-
-func Test_APIConflict(t *testing.T) {
-	jar, r, _ := newServer(t)
-	ts := httptest.NewServer(r)
-	defer ts.Close()
-	t.Run("Test default Get handler", func(t *testing.T) {
-		response, _ := testRequest(t, ts, jar, http.MethodGet, "/", "", map[string]string{"Content-Type": "text/plain; charset=utf-8"})
-		defer response.Body.Close()
-		ctype := map[string]string{
-			"Content-Type": "application/json",
-		}
-		type req struct {
-			URL string `json:"url"` //{"url":"<some_url>"}
-		}
-		s := req{URL: "http://kiuerhv9unvr.org"}
-		b, err := json.Marshal(s)
-		require.NoError(t, err)
-		response, body1 := testRequest(t, ts, jar, http.MethodPost, "/api/shorten", string(b), ctype)
-		defer response.Body.Close()
-		matched, err := regexp.Match(`{"result":\"http:\/\/\w+\.\w+\.\w\.\w:\d+\/\w{8}\"}`, []byte(body1))
-		require.NoError(t, err)
-		require.True(t, matched)
-		time.Sleep(3 * time.Second)
-		response, body2 := testRequest(t, ts, jar, http.MethodPost, "/api/shorten", string(b), ctype)
-		defer response.Body.Close()
-		require.Equal(t, http.StatusConflict, response.StatusCode)
-		require.Equal(t, body1, body2)
-
-	})
-}
+package webhandlers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/go-chi/chi"
+	"github.com/stretchr/testify/require"
+
+	"github.com/t1mon-ggg/go_shortner/app/helpers"
+	"github.com/t1mon-ggg/go_shortner/app/models"
+	"github.com/t1mon-ggg/go_shortner/app/service"
+	"github.com/t1mon-ggg/go_shortner/app/storage"
+)
+
+func newServer(t *testing.T) (*cookiejar.Jar, *chi.Mux, *App) {
+	jar, err := cookiejar.New(nil)
+	require.NoError(t, err)
+	db := NewApp()
+	db.Storage, err = db.Config.NewStorage()
+	require.NoError(t, err)
+	require.NoError(t, err)
+	require.NoError(t, err)
+	r := db.NewWebProcessor(10)
+	return jar, r, db
+}
+
+func gziped(ctype map[string]string) bool {
+	for i := range ctype {
+		if i == "Content-Encoding" && ctype[i] == "gzip" {
+			return true
+		}
+	}
+	return false
+}
+
+func compress(data []byte) ([]byte, error) {
+	var b bytes.Buffer
+	gz := gzip.NewWriter(&b)
+	if _, err := gz.Write([]byte(data)); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func decompress(data []byte) ([]byte, error) {
+	reader := bytes.NewReader(data)
+	gz, err := gzip.NewReader(reader)
+	if err != nil {
+		return nil, err
+	}
+	unzipped, err := io.ReadAll(gz)
+	if err != nil {
+		return nil, err
+	}
+	return unzipped, nil
+}
+func testRequest(t *testing.T, ts *httptest.Server, jar *cookiejar.Jar, method, path, body string, ctype map[string]string) (*http.Response, string) {
+
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+		Jar: jar,
+	}
+	var bodyreq *strings.Reader
+	if gziped(ctype) {
+		c, _ := compress([]byte(body))
+		bodyreq = strings.NewReader(string(c))
+	} else {
+		bodyreq = strings.NewReader(body)
+	}
+	req, err := http.NewRequest(method, ts.URL+path, bodyreq)
+	require.NoError(t, err)
+	for i := range ctype {
+		req.Header.Set(i, ctype[i])
+	}
+
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	return resp, string(respBody)
+}
+
+// Test_defaultGetHandler - тестирование корневого хендлера
+func Test_defaultGetHandler(t *testing.T) {
+	jar, r, _ := newServer(t)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	t.Run("Test default Get handler", func(t *testing.T) {
+		ctype := map[string]string{
+			"Content-Type": "text/plain; charset=utf-8",
+		}
+		response, _ := testRequest(t, ts, jar, http.MethodGet, "/", "", ctype)
+		defer response.Body.Close()
+		require.Equal(t, http.StatusBadRequest, response.StatusCode)
+	})
+}
+
+//Test_otherHandler - тестирование методов отличных от используемых
+func Test_otherHandler(t *testing.T) {
+	jar, r, _ := newServer(t)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	t.Run("Test other method handler", func(t *testing.T) {
+		ctype := map[string]string{
+			"Content-Type": "text/plain; charset=utf-8",
+		}
+		response, _ := testRequest(t, ts, jar, http.MethodPut, "/", "", ctype)
+		defer response.Body.Close()
+		require.Equal(t, http.StatusBadRequest, response.StatusCode)
+	})
+}
+
+//Test_CreateShortURL - тестирование создания короткой ссылки
+func Test_CreateShortURL(t *testing.T) {
+	jar, r, _ := newServer(t)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	t.Run("Test default Get handler", func(t *testing.T) {
+		ctype := map[string]string{
+			"Content-Type": "text/plain; charset=utf-8",
+		}
+		response, body := testRequest(t, ts, jar, http.MethodPost, "/", "http://example.org/", ctype)
+		defer response.Body.Close()
+		require.Equal(t, http.StatusCreated, response.StatusCode)
+
+		matched, err := regexp.Match(`\w{8}`, []byte(body))
+		if err != nil {
+			t.Fatal("Regexp error")
+		}
+		require.Equal(t, true, matched)
+	})
+}
+
+//Test_UnshortStatic - теститрование обратного преобразования короткой ссылки в исходную
+func Test_UnshortStatic(t *testing.T) {
+	type wanted struct {
+		statusCode int
+		body       string
+	}
+	type arg struct {
+		method string
+		query  string
+		body   string
+		ctype  map[string]string
+		want   wanted
+	}
+
+	tests := []struct {
+		name string
+		args arg
+	}{
+		{
+			name: "NotExistent",
+			args: arg{
+				method: http.MethodGet,
+				query:  "/jdpijvHG",
+				body:   "",
+				ctype: map[string]string{
+					"Content-Type": "text/plain; charset=utf-8",
+				},
+
+				want: wanted{
+					statusCode: 404,
+					body:       "",
+				},
+			},
+		},
+		{
+			name: "WrongShort",
+			args: arg{
+				method: http.MethodGet,
+				query:  "/jdpi",
+				body:   "",
+				ctype: map[string]string{
+					"Content-Type": "text/plain; charset=utf-8",
+				},
+
+				want: wanted{
+					statusCode: 400,
+					body:       "",
+				},
+			},
+		},
+		{
+			name: "WrongLong",
+			args: arg{
+				method: http.MethodGet,
+				query:  "/jdpiaaaaaaaaaaaaaaaaaaaaaaaaaaa",
+				body:   "",
+				ctype: map[string]string{
+					"Content-Type": "text/plain; charset=utf-8",
+				},
+
+				want: wanted{
+					statusCode: 400,
+					body:       "",
+				},
+			},
+		},
+		{
+			name: "Static",
+			args: arg{
+				method: http.MethodGet,
+				query:  "/abcdABCD",
+				body:   "",
+				ctype: map[string]string{
+					"Content-Type": "text/plain; charset=utf-8",
+				},
+
+				want: wanted{
+					statusCode: 307,
+					body:       "http://example.org",
+				},
+			},
+		},
+	}
+	jar, r, db := newServer(t)
+	db.Storage.Write(context.Background(), models.ClientData{Cookie: "cookie1", Key: "secret_key", Short: []models.ShortData{{Short: "abcdABCD", Long: "http://example.org"}}})
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, _ := testRequest(t, ts, jar, http.MethodGet, tt.args.query, "", tt.args.ctype)
+			defer response.Body.Close()
+			require.Equal(t, tt.args.want.statusCode, response.StatusCode)
+			if tt.name == "Static" {
+				require.Equal(t, response.Header.Get("Location"), tt.args.want.body)
+			}
+		})
+	}
+}
+
+//Test_2WayTest - теститрование обратного преобразования короткой ссылки в исходную
+func Test_2WayTest(t *testing.T) {
+	jar, r, _ := newServer(t)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	t.Run("Test 2way test", func(t *testing.T) {
+		ctype := map[string]string{
+			"Content-Type": "text/plain; charset=utf-8",
+		}
+		expected := "http://example.org"
+		response1, body := testRequest(t, ts, jar, http.MethodPost, "/", expected, ctype)
+		defer response1.Body.Close()
+		require.Equal(t, http.StatusCreated, response1.StatusCode)
+		short := strings.Split(body, "/")
+		query := "/" + short[len(short)-1]
+		response2, _ := testRequest(t, ts, jar, http.MethodGet, query, "", ctype)
+		defer response2.Body.Close()
+		require.Equal(t, http.StatusTemporaryRedirect, response2.StatusCode)
+		require.Equal(t, expected, response2.Header.Get("Location"))
+	})
+}
+
+//Test_APIShort - тестирование сокращателя через API
+func Test_APIShort(t *testing.T) {
+	jar, r, _ := newServer(t)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	t.Run("Test default Get handler", func(t *testing.T) {
+		ctype := map[string]string{
+			"Content-Type": "application/json",
+		}
+		type req struct {
+			URL string `json:"url"` //{"url":"<some_url>"}
+		}
+		s := req{URL: "http://example.org"}
+		b, err := json.Marshal(s)
+		require.NoError(t, err)
+		response, body := testRequest(t, ts, jar, http.MethodPost, "/api/shorten", string(b), ctype)
+		defer response.Body.Close()
+		matched, err := regexp.Match(`{"result":\"http:\/\/\w+\.\w+\.\w\.\w:\d+\/\w{8}\"}`, []byte(body))
+		require.NoError(t, err)
+		require.True(t, matched)
+	})
+}
+
+//Test_API2Way - тестирование двухстороннего обмена через API
+func Test_API2Way(t *testing.T) {
+	jar, r, _ := newServer(t)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	t.Run("2Way API", func(t *testing.T) {
+		ctype := map[string]string{
+			"Content-Type": "application/json",
+		}
+		type req struct {
+			URL string `json:"url"` //{"url":"<some_url>"}
+		}
+		type answer struct {
+			A string `json:"result"` //{"result":"<short_url>"}
+		}
+		s := req{URL: "http://example.org"}
+		b, err := json.Marshal(s)
+		require.NoError(t, err)
+		response, body := testRequest(t, ts, jar, http.MethodPost, "/api/shorten", string(b), ctype)
+		defer response.Body.Close()
+		require.Equal(t, "application/json", response.Header.Get("Content-Type"))
+		require.Equal(t, http.StatusCreated, response.StatusCode)
+		ss := answer{}
+		err = json.Unmarshal([]byte(body), &ss)
+		require.NoError(t, err)
+		url := ss.A
+		query := strings.Split(url, "/")
+		q := fmt.Sprintf("/%s", query[len(query)-1])
+		response, _ = testRequest(t, ts, jar, http.MethodGet, q, "", map[string]string{"Content-Type": "text/plain; charset=utf-8"})
+		defer response.Body.Close()
+		require.Equal(t, http.StatusTemporaryRedirect, response.StatusCode)
+		require.Equal(t, s.URL, response.Header.Get("Location"))
+	})
+}
+
+//Test_ZippedRequest - теститрование сжатого запроса
+func Test_ZippedRequest(t *testing.T) {
+	jar, r, _ := newServer(t)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	t.Run("Test default Get handler", func(t *testing.T) {
+		ctype := map[string]string{
+			"Content-Type":     "application/json",
+			"Content-Encoding": "gzip",
+		}
+		type req struct {
+			URL string `json:"url"` //{"url":"<some_url>"}
+		}
+		s := req{URL: "http://example.org"}
+		b, err := json.Marshal(s)
+		require.NoError(t, err)
+		response, body := testRequest(t, ts, jar, http.MethodPost, "/api/shorten", string(b), ctype)
+		defer response.Body.Close()
+		matched, err := regexp.Match(`{"result":\"http:\/\/\w+\.\w+\.\w\.\w:\d+\/\w{8}\"}`, []byte(body))
+		require.NoError(t, err)
+		require.True(t, matched)
+	})
+}
+
+//Test_ZippedAnswer - тестирование сжатия ответа с сервера
+func Test_ZippedAnswer(t *testing.T) {
+	jar, r, _ := newServer(t)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	t.Run("Test default Get handler", func(t *testing.T) {
+		ctype := map[string]string{
+			"Content-Type":    "application/json",
+			"Accept-Encoding": "gzip",
+		}
+		type req struct {
+			URL string `json:"url"` //{"url":"<some_url>"}
+		}
+		s := req{URL: "http://example.org"}
+		b, err := json.Marshal(s)
+		require.NoError(t, err)
+		response, body := testRequest(t, ts, jar, http.MethodPost, "/api/shorten", string(b), ctype)
+		defer response.Body.Close()
+		require.Equal(t, "gzip", response.Header.Get("Content-Encoding"))
+		a, err := decompress([]byte(body))
+		require.NoError(t, err)
+		matched, err := regexp.Match(`{"result":\"http:\/\/\w+\.\w+\.\w\.\w:\d+\/\w{8}\"}`, a)
+		require.NoError(t, err)
+		require.True(t, matched)
+	})
+}
+
+//Test_2WayZip - тестирование работы со сжатием данных в обе стороны
+func Test_2WayZip(t *testing.T) {
+	jar, r, _ := newServer(t)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	t.Run("Test default Get handler", func(t *testing.T) {
+		ctype := map[string]string{
+			"Content-Type":     "application/json",
+			"Accept-Encoding":  "gzip",
+			"Content-Encoding": "gzip",
+		}
+		type req struct {
+			URL string `json:"url"` //{"url":"<some_url>"}
+		}
+		s := req{URL: "http://example.org"}
+		b, err := json.Marshal(s)
+		require.NoError(t, err)
+		response, body := testRequest(t, ts, jar, http.MethodPost, "/api/shorten", string(b), ctype)
+		defer response.Body.Close()
+		require.Equal(t, "gzip", response.Header.Get("Content-Encoding"))
+		a, err := decompress([]byte(body))
+		require.NoError(t, err)
+		matched, err := regexp.Match(`{"result":\"http:\/\/\w+\.\w+\.\w\.\w:\d+\/\w{8}\"}`, a)
+		require.NoError(t, err)
+		require.True(t, matched)
+	})
+}
+
+//Test_UserURLs - тестиирование получения всех ссылок пользователя
+func Test_UserURLs(t *testing.T) {
+	type wanted struct {
+		statusCode int
+		body       string
+	}
+	type arg struct {
+		method string
+		query  string
+		body   string
+		ctype  map[string]string
+		want   wanted
+	}
+	tests := []struct {
+		name string
+		args arg
+	}{
+		{
+			name: "Not existent cookie",
+			args: arg{
+				method: http.MethodGet,
+				query:  "/api/user/urls",
+				body:   "",
+				ctype: map[string]string{
+					"Content-Type": "text/plain; charset=utf-8",
+				},
+				want: wanted{
+					statusCode: http.StatusNoContent,
+					body:       "",
+				},
+			},
+		},
+		{
+			name: "Existent cookie",
+			args: arg{
+				method: http.MethodGet,
+				query:  "/api/user/urls",
+				body:   "",
+				ctype: map[string]string{
+					"Content-Type": "text/plain; charset=utf-8",
+				},
+				want: wanted{
+					statusCode: http.StatusNoContent,
+					body:       "",
+				},
+			},
+		},
+		{
+			name: "Wrong cookie",
+			args: arg{
+				method: http.MethodGet,
+				query:  "/",
+				body:   "",
+				ctype: map[string]string{
+					"Content-Type": "text/plain; charset=utf-8",
+				},
+				want: wanted{
+					statusCode: 0,
+					body:       "",
+				},
+			},
+		},
+	}
+	jar, r, _ := newServer(t)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jar, _ = cookiejar.New(nil)
+			switch tt.name {
+			case "Not existent cookie":
+				response, _ := testRequest(t, ts, jar, http.MethodGet, tt.args.query, "", tt.args.ctype)
+				defer response.Body.Close()
+				require.Equal(t, http.StatusNoContent, response.StatusCode)
+			case "Existent cookie":
+				response, _ := testRequest(t, ts, jar, http.MethodPost, "/", "http://example.org", map[string]string{
+					"Content-Type": "text/plain; charset=utf-8"})
+				defer response.Body.Close()
+				require.Equal(t, http.StatusCreated, response.StatusCode)
+				response, _ = testRequest(t, ts, jar, http.MethodPost, "/", "http://example2.org", map[string]string{
+					"Content-Type": "text/plain; charset=utf-8"})
+				defer response.Body.Close()
+				require.Equal(t, http.StatusCreated, response.StatusCode)
+				response, body := testRequest(t, ts, jar, http.MethodGet, tt.args.query, tt.args.body, tt.args.ctype)
+				defer response.Body.Close()
+				require.Equal(t, http.StatusOK, response.StatusCode)
+				require.Equal(t, "application/json", response.Header.Get("Content-Type"))
+				type answer struct {
+					Short    string `json:"short_url"`
+					Original string `json:"original_url"`
+				}
+				d := make([]answer, 0)
+				err := json.Unmarshal([]byte(body), &d)
+				require.NoError(t, err)
+			case "Wrong cookie":
+				response, _ := testRequest(t, ts, jar, http.MethodPost, tt.args.query, "http://example3.org", tt.args.ctype)
+				defer response.Body.Close()
+				cookies := jar.Cookies(response.Request.URL)
+				var cvalues []string
+				cvalues = make([]string, 0)
+				for _, c := range cookies {
+					cvalues = append(cvalues, c.Value)
+					c.Value = helpers.RandStringRunes(96)
+				}
+				response, _ = testRequest(t, ts, jar, http.MethodGet, tt.args.query, "", tt.args.ctype)
+				defer response.Body.Close()
+				cookies = jar.Cookies(response.Request.URL)
+				var mvalues []string
+				mvalues = make([]string, 0)
+				for _, m := range cookies {
+					mvalues = append(cvalues, m.Value)
+				}
+				require.NotEqual(t, cvalues, mvalues)
+
+			}
+		})
+	}
+}
+
+//Test_Ping - тестирование хранилища
+func Test_Ping(t *testing.T) {
+	jar, r, _ := newServer(t)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	t.Run("Test Ping handler", func(t *testing.T) {
+		ctype := map[string]string{
+			"Content-Type": "text/plain; charset=utf-8",
+		}
+		response, _ := testRequest(t, ts, jar, http.MethodGet, "/ping", "", ctype)
+		defer response.Body.Close()
+		require.Equal(t, http.StatusOK, response.StatusCode)
+	})
+}
+
+//Test_Delete - удаления тегов
+func Test_Delete(t *testing.T) {
+	jar, r, _ := newServer(t)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	type input struct {
+		Correlation string `json:"correlation_id"`
+		Long        string `json:"original_url"`
+	}
+	type output struct {
+		Correlation string `json:"correlation_id"`
+		Short       string `json:"short_url"`
+	}
+	response, _ := testRequest(t, ts, jar, http.MethodPost, "/", "", map[string]string{"Content-Type": "text/plain; charset=utf-8"})
+	defer response.Body.Close()
+	in := []input{
+		{Correlation: "12345",
+			Long: "http://example1.org"},
+		{Correlation: "12345",
+			Long: "http://example2.org"},
+		{Correlation: "12345",
+			Long: "http://example3.org"},
+		{Correlation: "12345",
+			Long: "http://example4.org"},
+		{Correlation: "12345",
+			Long: "http://example5.org"},
+		{Correlation: "12345",
+			Long: "http://example6.org"},
+		{Correlation: "12345",
+			Long: "http://example7.org"},
+		{Correlation: "12345",
+			Long: "http://example8.org"},
+		{Correlation: "12345",
+			Long: "http://example9.org"},
+		{Correlation: "12345",
+			Long: "http://example10.org"},
+		{Correlation: "12345",
+			Long: "http://example11.org"},
+		{Correlation: "12345",
+			Long: "http://example12.org"},
+		{Correlation: "12345",
+			Long: "http://example13.org"},
+		{Correlation: "12345",
+			Long: "http://example14.org"},
+		{Correlation: "12345",
+			Long: "http://example15.org"},
+		{Correlation: "12345",
+			Long: "http://example16.org"},
+		{Correlation: "12345",
+			Long: "http://example17.org"},
+		{Correlation: "12345",
+			Long: "http://example18.org"},
+		{Correlation: "12345",
+			Long: "http://example19.org"},
+		{Correlation: "12345",
+			Long: "http://example20.org"},
+	}
+	ctype := map[string]string{
+		"Content-Type": "application/json",
+	}
+	body, err := json.Marshal(in)
+	require.NoError(t, err)
+	response, astring := testRequest(t, ts, jar, http.MethodPost, "/api/shorten/batch", string(body), ctype)
+	defer response.Body.Close()
+	require.Equal(t, http.StatusCreated, response.StatusCode)
+	var answer []output
+	require.NoError(t, err)
+	err = json.Unmarshal([]byte(astring), &answer)
+	require.NoError(t, err)
+	require.Equal(t, len(in), len(answer))
+	del := make([]string, 0)
+	for i := 5; i < 12; i++ {
+		re := regexp.MustCompile(`\w{8}`)
+		match := re.FindString(answer[i].Short)
+		del = append(del, match)
+	}
+	for i := range del {
+		del[i] = fmt.Sprintf("\"%s\"", del[i])
+	}
+	s := strings.Join(del, ",")
+	s = "[" + s + "]"
+	response, _ = testRequest(t, ts, jar, http.MethodDelete, "/api/user/urls", s, ctype)
+	defer response.Body.Close()
+	require.Equal(t, http.StatusAccepted, response.StatusCode)
+	time.Sleep(25 * time.Second)
+	ctype = map[string]string{
+		"Content-Type": "text/plain; charset=utf-8",
+	}
+	for i := 5; i < 12; i++ {
+		re := regexp.MustCompile(`\w{8}`)
+		match := re.FindString(answer[i].Short)
+		response, _ = testRequest(t, ts, jar, http.MethodGet, fmt.Sprintf("/%s", match), s, ctype)
+		defer response.Body.Close()
+		require.Equal(t, http.StatusGone, response.StatusCode)
+	}
+}
+
+//Test_DeleteValidation - проверка тела запроса на удаление до его принятия
+func Test_DeleteValidation(t *testing.T) {
+	jar, r, db := newServer(t)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	bomb, err := compress(make([]byte, db.Config.MaxDecompressedSize+1))
+	require.NoError(t, err)
+	tests := []struct {
+		name  string
+		body  string
+		ctype map[string]string
+		code  int
+	}{
+		{name: "Valid", body: `["abcdefgh"]`, code: http.StatusAccepted},
+		{name: "Empty list", body: `[]`, code: http.StatusAccepted},
+		{name: "Malformed JSON", body: `["abcdefgh"`, code: http.StatusBadRequest},
+		{name: "Not a list", body: `{"tag":"abcdefgh"}`, code: http.StatusBadRequest},
+		{name: "Wrong tag", body: `["abc/defgh"]`, code: http.StatusBadRequest},
+		{name: "Too large", body: string(bomb), ctype: map[string]string{"Content-Encoding": "gzip, identity"}, code: http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctype := map[string]string{"Content-Type": "application/json"}
+			for k, v := range tt.ctype {
+				ctype[k] = v
+			}
+			response, body := testRequest(t, ts, jar, http.MethodDelete, "/api/user/urls", tt.body, ctype)
+			defer response.Body.Close()
+			require.Equal(t, tt.code, response.StatusCode, body)
+		})
+	}
+}
+
+//Test_BatchAPI - массовое заполнение базы
+func Test_BatchAPI(t *testing.T) {
+	jar, r, _ := newServer(t)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	type input struct {
+		Correlation string `json:"correlation_id"`
+		Long        string `json:"original_url"`
+	}
+	type output struct {
+		Correlation string `json:"correlation_id"`
+		Short       string `json:"short_url"`
+	}
+
+	in := []input{
+		{Correlation: "12345",
+			Long: "http://example1.org"},
+		{Correlation: "12345",
+			Long: "http://example2.org"},
+		{Correlation: "12345",
+			Long: "http://example3.org"},
+		{Correlation: "12345",
+			Long: "http://example4.org"},
+		{Correlation: "12345",
+			Long: "http://example5.org"},
+		{Correlation: "12345",
+			Long: "http://example6.org"},
+		{Correlation: "12345",
+			Long: "http://example7.org"},
+		{Correlation: "12345",
+			Long: "http://example8.org"},
+		{Correlation: "12345",
+			Long: "http://example9.org"},
+		{Correlation: "12345",
+			Long: "http://example10.org"},
+		{Correlation: "12345",
+			Long: "http://example11.org"},
+		{Correlation: "12345",
+			Long: "http://example12.org"},
+		{Correlation: "12345",
+			Long: "http://example13.org"},
+		{Correlation: "12345",
+			Long: "http://example14.org"},
+		{Correlation: "12345",
+			Long: "http://example15.org"},
+		{Correlation: "12345",
+			Long: "http://example16.org"},
+		{Correlation: "12345",
+			Long: "http://example17.org"},
+		{Correlation: "12345",
+			Long: "http://example18.org"},
+		{Correlation: "12345",
+			Long: "http://example19.org"},
+		{Correlation: "12345",
+			Long: "http://example20.org"},
+	}
+	ctype := map[string]string{
+		"Content-Type": "application/json",
+	}
+	body, err := json.Marshal(in)
+	require.NoError(t, err)
+	response, astring := testRequest(t, ts, jar, http.MethodPost, "/api/shorten/batch", string(body), ctype)
+	defer response.Body.Close()
+	require.Equal(t, http.StatusCreated, response.StatusCode)
+	var answer []output
+	require.NoError(t, err)
+	err = json.Unmarshal([]byte(astring), &answer)
+	require.NoError(t, err)
+	require.Equal(t, len(in), len(answer))
+}
+
+func Test_Conflict(t *testing.T) {
+	jar, r, _ := newServer(t)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	t.Run("Test default Get handler", func(t *testing.T) {
+		response, _ := testRequest(t, ts, jar, http.MethodGet, "/", "", map[string]string{"Content-Type": "text/plain; charset=utf-8"})
+		defer response.Body.Close()
+		ctype := map[string]string{
+			"Content-Type": "text/plain; charset=utf-8",
+		}
+		s := "http://kiuerhv9unvr.org"
+		response, body1 := testRequest(t, ts, jar, http.MethodPost, "/", s, ctype)
+		defer response.Body.Close()
+		matched, err := regexp.Match(`http:\/\/\w+\.\w+\.\w\.\w:\d+\/\w{8}`, []byte(body1))
+		require.NoError(t, err)
+		require.True(t, matched)
+		time.Sleep(3 * time.Second)
+		response, body2 := testRequest(t, ts, jar, http.MethodPost, "/", s, ctype)
+		defer response.Body.Close()
+		require.Equal(t, http.StatusConflict, response.StatusCode)
+		require.Equal(t, body1, body2)
+
+	})
+}
+
+func Test_APIConflict(t *testing.T) {
+	jar, r, _ := newServer(t)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	t.Run("Test default Get handler", func(t *testing.T) {
+		response, _ := testRequest(t, ts, jar, http.MethodGet, "/", "", map[string]string{"Content-Type": "text/plain; charset=utf-8"})
+		defer response.Body.Close()
+		ctype := map[string]string{
+			"Content-Type": "application/json",
+		}
+		type req struct {
+			URL string `json:"url"` //{"url":"<some_url>"}
+		}
+		s := req{URL: "http://kiuerhv9unvr.org"}
+		b, err := json.Marshal(s)
+		require.NoError(t, err)
+		response, body1 := testRequest(t, ts, jar, http.MethodPost, "/api/shorten", string(b), ctype)
+		defer response.Body.Close()
+		matched, err := regexp.Match(`{"result":\"http:\/\/\w+\.\w+\.\w\.\w:\d+\/\w{8}\"}`, []byte(body1))
+		require.NoError(t, err)
+		require.True(t, matched)
+		time.Sleep(3 * time.Second)
+		response, body2 := testRequest(t, ts, jar, http.MethodPost, "/api/shorten", string(b), ctype)
+		defer response.Body.Close()
+		require.Equal(t, http.StatusConflict, response.StatusCode)
+		require.Equal(t, body1, body2)
+
+	})
+}
+
+func Test_CustomAlias(t *testing.T) {
+	jar, r, _ := newServer(t)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	ctype := map[string]string{
+		"Content-Type": "application/json",
+	}
+	tests := []struct {
+		name       string
+		url        string
+		alias      string
+		statusCode int
+	}{
+		{
+			name:       "Created",
+			url:        "http://example1.org",
+			alias:      "my-alias_1",
+			statusCode: http.StatusCreated,
+		},
+		{
+			name:       "Taken",
+			url:        "http://example2.org",
+			alias:      "my-alias_1",
+			statusCode: http.StatusConflict,
+		},
+		{
+			name:       "TooShort",
+			url:        "http://example3.org",
+			alias:      "abc",
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "TooLong",
+			url:        "http://example3.org",
+			alias:      "abcdefghijklmnopqrstuvwxyz",
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "WrongAlphabet",
+			url:        "http://example3.org",
+			alias:      "my/alias",
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "Reserved",
+			url:        "http://example3.org",
+			alias:      "Swagger",
+			statusCode: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := json.Marshal(lURL{LongURL: tt.url, Alias: tt.alias})
+			require.NoError(t, err)
+			response, body := testRequest(t, ts, jar, http.MethodPost, "/api/shorten", string(b), ctype)
+			defer response.Body.Close()
+			require.Equal(t, tt.statusCode, response.StatusCode)
+			if tt.statusCode == http.StatusCreated {
+				require.Equal(t, fmt.Sprintf("{\"result\":\"%s/%s\"}", "http://127.0.0.1:8080", tt.alias), body)
+			}
+		})
+	}
+	response, _ := testRequest(t, ts, jar, http.MethodGet, "/my-alias_1", "", map[string]string{})
+	defer response.Body.Close()
+	require.Equal(t, http.StatusTemporaryRedirect, response.StatusCode)
+	require.Equal(t, "http://example1.org", response.Header.Get("Location"))
+
+	batch := []input{
+		{Correlation: "1", Long: "http://example4.org", Alias: "batch_alias"},
+		{Correlation: "2", Long: "http://example5.org"},
+	}
+	b, err := json.Marshal(batch)
+	require.NoError(t, err)
+	response, body := testRequest(t, ts, jar, http.MethodPost, "/api/shorten/batch", string(b), ctype)
+	defer response.Body.Close()
+	require.Equal(t, http.StatusCreated, response.StatusCode)
+	out := []output{}
+	require.NoError(t, json.Unmarshal([]byte(body), &out))
+	require.Equal(t, "http://127.0.0.1:8080/batch_alias", out[0].Short)
+	response, body = testRequest(t, ts, jar, http.MethodPost, "/api/shorten/batch", `[{"correlation_id":"1","original_url":"http://example6.org","custom_alias":"swagger"},{"correlation_id":"2","original_url":"http://example6.org","custom_alias":"batch_alias"}]`, ctype)
+	defer response.Body.Close()
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	out = []output{}
+	require.NoError(t, json.Unmarshal([]byte(body), &out))
+	require.Equal(t, []output{
+		{Correlation: "1", Status: "invalid", Error: "invalid request: wrong custom alias: swagger"},
+		{Correlation: "2", Status: "invalid", Error: "custom alias is taken: batch_alias"},
+	}, out)
+}
+
+func Test_BatchStatus(t *testing.T) {
+	jar, r, _ := newServer(t)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	ctype := map[string]string{"Content-Type": "application/json"}
+	response, body := testRequest(t, ts, jar, http.MethodPost, "/api/shorten/batch", `[{"correlation_id":"1","original_url":"http://example1.org","custom_alias":"status_1"}]`, ctype)
+	defer response.Body.Close()
+	require.Equal(t, http.StatusCreated, response.StatusCode)
+	batch := []input{
+		{Correlation: "1", Long: "http://example1.org"},
+		{Correlation: "2", Long: ""},
+		{Correlation: "3", Long: "http://example2.org", Alias: "status_2"},
+		{Correlation: "4", Long: "http://example3.org", TTL: -1},
+	}
+	b, err := json.Marshal(batch)
+	require.NoError(t, err)
+	response, body = testRequest(t, ts, jar, http.MethodPost, "/api/shorten/batch", string(b), ctype)
+	defer response.Body.Close()
+	require.Equal(t, http.StatusCreated, response.StatusCode)
+	out := []output{}
+	require.NoError(t, json.Unmarshal([]byte(body), &out))
+	require.Equal(t, []output{
+		{Correlation: "1", Short: "http://127.0.0.1:8080/status_1", Status: "exists"},
+		{Correlation: "2", Status: "invalid", Error: "invalid request: empty url"},
+		{Correlation: "3", Short: "http://127.0.0.1:8080/status_2", Status: "created"},
+		{Correlation: "4", Status: "invalid", Error: "invalid request: ttl_seconds must be positive"},
+	}, out)
+}
+
+func Test_Expiration(t *testing.T) {
+	jar, r, db := newServer(t)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	ctype := map[string]string{
+		"Content-Type": "application/json",
+	}
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+	tests := []struct {
+		name       string
+		body       lURL
+		statusCode int
+	}{
+		{
+			name:       "TTL",
+			body:       lURL{LongURL: "http://example1.org", Alias: "ttl_link", TTL: 1},
+			statusCode: http.StatusCreated,
+		},
+		{
+			name:       "ExpiresAt",
+			body:       lURL{LongURL: "http://example2.org", Alias: "expires_link", ExpiresAt: &future},
+			statusCode: http.StatusCreated,
+		},
+		{
+			name:       "Past",
+			body:       lURL{LongURL: "http://example3.org", ExpiresAt: &past},
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "Both",
+			body:       lURL{LongURL: "http://example3.org", ExpiresAt: &future, TTL: 10},
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "NegativeTTL",
+			body:       lURL{LongURL: "http://example3.org", TTL: -10},
+			statusCode: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := json.Marshal(tt.body)
+			require.NoError(t, err)
+			response, _ := testRequest(t, ts, jar, http.MethodPost, "/api/shorten", string(b), ctype)
+			defer response.Body.Close()
+			require.Equal(t, tt.statusCode, response.StatusCode)
+		})
+	}
+	response, _ := testRequest(t, ts, jar, http.MethodGet, "/ttl_link", "", map[string]string{})
+	defer response.Body.Close()
+	require.Equal(t, http.StatusTemporaryRedirect, response.StatusCode)
+	time.Sleep(1100 * time.Millisecond)
+	response, _ = testRequest(t, ts, jar, http.MethodGet, "/ttl_link", "", map[string]string{})
+	defer response.Body.Close()
+	require.Equal(t, http.StatusGone, response.StatusCode)
+	response, _ = testRequest(t, ts, jar, http.MethodGet, "/expires_link", "", map[string]string{})
+	defer response.Body.Close()
+	require.Equal(t, http.StatusTemporaryRedirect, response.StatusCode)
+
+	db.sweep(context.Background())
+	require.Eventually(t, func() bool {
+		data, err := db.Storage.ReadByTag(context.Background(), "ttl_link")
+		return errors.Is(err, storage.ErrDeleted) && data.Deleted
+	}, time.Second, 10*time.Millisecond)
+	_, err := db.Storage.ReadByTag(context.Background(), "expires_link")
+	require.NoError(t, err)
+}
+
+func Test_Stats(t *testing.T) {
+	jar, r, db := newServer(t)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	ctype := map[string]string{
+		"Content-Type": "application/json",
+	}
+	b, err := json.Marshal(lURL{LongURL: "http://example1.org", Alias: "stats_link"})
+	require.NoError(t, err)
+	response, _ := testRequest(t, ts, jar, http.MethodPost, "/api/shorten", string(b), ctype)
+	defer response.Body.Close()
+	require.Equal(t, http.StatusCreated, response.StatusCode)
+	headers := []map[string]string{
+		{"Referer": "http://referrer.org", "CF-IPCountry": "ru"},
+		{"Referer": "http://referrer.org", "X-Real-IP": "10.0.0.1", "X-Country-Code": "US"},
+		{"X-Real-IP": "10.0.0.1"},
+	}
+	for _, header := range headers {
+		response, _ := testRequest(t, ts, jar, http.MethodGet, "/stats_link", "", header)
+		defer response.Body.Close()
+		require.Equal(t, http.StatusTemporaryRedirect, response.StatusCode)
+	}
+	db.Clicks.Close()
+
+	response, body := testRequest(t, ts, jar, http.MethodGet, "/api/user/urls/stats_link/stats", "", map[string]string{})
+	defer response.Body.Close()
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	stats := models.Stats{}
+	require.NoError(t, json.Unmarshal([]byte(body), &stats))
+	require.Equal(t, 3, stats.Clicks)
+	require.Equal(t, 2, stats.Unique)
+	require.Len(t, stats.Daily, 1)
+	require.Equal(t, 3, stats.Daily[0].Clicks)
+	require.Equal(t, []models.TopItem{{Name: "http://referrer.org", Clicks: 2}}, stats.Referrers)
+	require.Equal(t, []models.TopItem{{Name: "RU", Clicks: 1}, {Name: "US", Clicks: 1}}, stats.Countries)
+
+	other, err := cookiejar.New(nil)
+	require.NoError(t, err)
+	response, _ = testRequest(t, ts, other, http.MethodGet, "/api/user/urls/stats_link/stats", "", map[string]string{})
+	defer response.Body.Close()
+	require.Equal(t, http.StatusNotFound, response.StatusCode)
+	response, _ = testRequest(t, ts, jar, http.MethodGet, "/api/user/urls/bad/stats", "", map[string]string{})
+	defer response.Body.Close()
+	require.Equal(t, http.StatusBadRequest, response.StatusCode)
+
+	//cookie with owner id and wrong signature is replaced by new user
+	u, err := url.Parse(ts.URL)
+	require.NoError(t, err)
+	cookies := jar.Cookies(u)
+	require.Len(t, cookies, 1)
+	forged := cookies[0].Value[:32] + strings.Repeat("0", 64)
+	other, err = cookiejar.New(nil)
+	require.NoError(t, err)
+	response, _ = testRequest(t, ts, other, http.MethodGet, "/api/user/urls/stats_link/stats", "", map[string]string{"Cookie": "Client_ID=" + forged})
+	defer response.Body.Close()
+	require.Equal(t, http.StatusNotFound, response.StatusCode)
+	issued := other.Cookies(u)
+	require.Len(t, issued, 1)
+	require.NotEqual(t, forged[:32], issued[0].Value[:32])
+	other, err = cookiejar.New(nil)
+	require.NoError(t, err)
+	response, _ = testRequest(t, ts, other, http.MethodGet, "/api/user/urls", "", map[string]string{"Cookie": "Client_ID=" + forged})
+	defer response.Body.Close()
+	require.Equal(t, http.StatusNoContent, response.StatusCode)
+}
+
+func Test_InternalStats(t *testing.T) {
+	jar, r, db := newServer(t)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	db.Storage.Write(context.Background(), models.ClientData{Cookie: "cookie1", Key: "secret_key", Short: []models.ShortData{{Short: "abcdABCD", Long: "http://example.org"}}})
+	tests := []struct {
+		name       string
+		subnet     string
+		ip         string
+		statusCode int
+	}{
+		{
+			name:       "NoSubnet",
+			subnet:     "",
+			ip:         "192.168.1.10",
+			statusCode: http.StatusForbidden,
+		},
+		{
+			name:       "WrongSubnet",
+			subnet:     "192.168.1.10",
+			ip:         "192.168.1.10",
+			statusCode: http.StatusForbidden,
+		},
+		{
+			name:       "NoHeader",
+			subnet:     "192.168.1.0/24",
+			ip:         "",
+			statusCode: http.StatusForbidden,
+		},
+		{
+			name:       "Untrusted",
+			subnet:     "192.168.1.0/24",
+			ip:         "192.168.2.10",
+			statusCode: http.StatusForbidden,
+		},
+		{
+			name:       "Trusted",
+			subnet:     "192.168.1.0/24",
+			ip:         "192.168.1.10",
+			statusCode: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db.subnet = trustedSubnet(tt.subnet)
+			header := map[string]string{}
+			if tt.ip != "" {
+				header["X-Real-IP"] = tt.ip
+			}
+			response, body := testRequest(t, ts, jar, http.MethodGet, "/api/internal/stats", "", header)
+			defer response.Body.Close()
+			require.Equal(t, tt.statusCode, response.StatusCode)
+			if tt.statusCode == http.StatusOK {
+				stats := internalStats{}
+				require.NoError(t, json.Unmarshal([]byte(body), &stats))
+				require.Equal(t, 1, stats.URLs)
+				require.Equal(t, 2, stats.Users)
+			}
+		})
+	}
+}
+
+func Test_Shutdown(t *testing.T) {
+	ctx := context.Background()
+	db := NewApp()
+	db.Config.FileStoragePath = filepath.Join(t.TempDir(), "journal.txt")
+	require.NoError(t, db.NewStorage())
+	db.NewWebProcessor(3)
+	tags := make([]string, 0)
+	for i := 0; i < 50; i++ {
+		tag := fmt.Sprintf("shutdown%02d", i)
+		tags = append(tags, tag)
+		err := db.Storage.Write(ctx, models.ClientData{Cookie: "cookie1", Key: "secret_key", Short: []models.ShortData{{Short: tag, Long: fmt.Sprintf("http://example%d.org", i)}}})
+		require.NoError(t, err)
+	}
+	for _, tag := range tags {
+		db.DelBuf <- models.DelWorker{Cookie: "cookie1", Tags: []string{tag}}
+	}
+	shutdownCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	require.NoError(t, db.Shutdown(shutdownCtx))
+
+	s, err := db.Config.NewStorage()
+	require.NoError(t, err)
+	defer s.Close()
+	for _, tag := range tags {
+		_, err := s.ReadByTag(ctx, tag)
+		require.ErrorIs(t, err, storage.ErrDeleted)
+	}
+}
+
+//blockedStorage - хранилище с обработчиками удаления, ожидающими сигнала release, и учетом закрытия
+type blockedStorage struct {
+	storage.Storage
+	release chan struct{}
+	closed  bool
+}
+
+func (s *blockedStorage) Cleaner(inputCh <-chan models.DelWorker, workers int) {
+	<-s.release
+	s.Storage.Cleaner(inputCh, workers)
+}
+
+func (s *blockedStorage) Close() error {
+	s.closed = true
+	return s.Storage.Close()
+}
+
+func Test_Shutdown_Timeout(t *testing.T) {
+	db := NewApp()
+	s := &blockedStorage{Storage: storage.NewRAM(), release: make(chan struct{})}
+	db.Storage = s
+	db.NewWebProcessor(1)
+	db.DelBuf <- models.DelWorker{Cookie: "cookie1", Tags: []string{"shutdown01"}}
+	db.Clicks.Record(models.Click{Tag: "shutdown01"})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, db.Shutdown(ctx), context.DeadlineExceeded)
+	require.False(t, s.closed)
+	//clicks are written even if delete queue is not drained
+	stats, err := s.Stats(context.Background(), "shutdown01", 10)
+	require.NoError(t, err)
+	require.Equal(t, 1, stats.Clicks)
+	//handlers still running after shutdown must not send to closed queue
+	require.ErrorIs(t, db.Service.Delete(context.Background(), "cookie1", []string{"shutdown02"}), service.ErrClosed)
+	close(s.release)
+}
+
+func Test_URLValidation(t *testing.T) {
+	jar, r, _ := newServer(t)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	ctype := map[string]string{"Content-Type": "text/plain; charset=utf-8"}
+	response, first := testRequest(t, ts, jar, http.MethodPost, "/", "http://example1.org/path", ctype)
+	defer response.Body.Close()
+	require.Equal(t, http.StatusCreated, response.StatusCode)
+	tests := []struct {
+		name       string
+		body       string
+		statusCode int
+		want       string
+	}{
+		{name: "Normalized duplicate", body: "HTTP://EXAMPLE1.org:80/path", statusCode: http.StatusConflict, want: first},
+		{name: "Empty", body: "", statusCode: http.StatusBadRequest, want: "invalid request: empty url\n"},
+		{name: "Garbage", body: "garbage", statusCode: http.StatusBadRequest, want: "invalid request: unsupported url scheme \"\", only http and https are allowed\n"},
+		{name: "No host", body: "https://", statusCode: http.StatusBadRequest, want: "invalid request: url host is required\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, body := testRequest(t, ts, jar, http.MethodPost, "/", tt.body, ctype)
+			defer response.Body.Close()
+			require.Equal(t, tt.statusCode, response.StatusCode)
+			require.Equal(t, tt.want, body)
+		})
+	}
+	response, body := testRequest(t, ts, jar, http.MethodPost, "/api/shorten", `{"url":"http://пример.рф:80"}`, map[string]string{"Content-Type": "application/json"})
+	defer response.Body.Close()
+	require.Equal(t, http.StatusCreated, response.StatusCode)
+	result := sURL{}
+	require.NoError(t, json.Unmarshal([]byte(body), &result))
+	response, _ = testRequest(t, ts, jar, http.MethodGet, strings.TrimPrefix(result.ShortURL, "http://127.0.0.1:8080"), "", map[string]string{})
+	defer response.Body.Close()
+	require.Equal(t, "http://xn--e1afmkfd.xn--p1ai", response.Header.Get("Location"))
+}
+
+func Test_Policy(t *testing.T) {
+	jar, err := cookiejar.New(nil)
+	require.NoError(t, err)
+	db := NewApp()
+	db.Config.PolicyFile = filepath.Join(t.TempDir(), "policy.json")
+	require.NoError(t, os.WriteFile(db.Config.PolicyFile, []byte(`{"rules": [{"id": "no-evil", "action": "block", "hosts": ["*.evil.example"]}]}`), 0600))
+	require.NoError(t, db.NewPolicy())
+	defer db.Policy.Close()
+	db.Storage, err = db.Config.NewStorage()
+	require.NoError(t, err)
+	ts := httptest.NewServer(db.NewWebProcessor(10))
+	defer ts.Close()
+	tests := []struct {
+		name  string
+		path  string
+		body  string
+		ctype string
+	}{
+		{name: "Text", path: "/", body: "http://www.evil.example/page", ctype: "text/plain"},
+		{name: "JSON", path: "/api/shorten", body: `{"url":"https://a.evil.example"}`, ctype: "application/json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, body := testRequest(t, ts, jar, http.MethodPost, tt.path, tt.body, map[string]string{"Content-Type": tt.ctype})
+			defer response.Body.Close()
+			require.Equal(t, http.StatusUnprocessableEntity, response.StatusCode)
+			require.Equal(t, "url is blocked by policy rule \"no-evil\"\n", body)
+		})
+	}
+	body := `[{"correlation_id":"1","original_url":"http://www.evil.example"},{"correlation_id":"2","original_url":"http://example1.org"}]`
+	response, answer := testRequest(t, ts, jar, http.MethodPost, "/api/shorten/batch", body, map[string]string{"Content-Type": "application/json"})
+	defer response.Body.Close()
+	require.Equal(t, http.StatusCreated, response.StatusCode)
+	out := []output{}
+	require.NoError(t, json.Unmarshal([]byte(answer), &out))
+	require.Equal(t, output{Correlation: "1", Status: "invalid", Error: "url is blocked by policy rule \"no-evil\""}, out[0])
+	require.Equal(t, "created", out[1].Status)
+}
+
+func Test_CompressedBody(t *testing.T) {
+	jar, r, db := newServer(t)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	var br bytes.Buffer
+	w := brotli.NewWriter(&br)
+	_, err := w.Write([]byte("http://example1.org"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	bomb, err := compress(make([]byte, db.Config.MaxDecompressedSize+1))
+	require.NoError(t, err)
+	tests := []struct {
+		name     string
+		path     string
+		body     string
+		encoding string
+		code     int
+	}{
+		{name: "Brotli", path: "/", body: br.String(), encoding: "br", code: http.StatusCreated},
+		{name: "Too large", path: "/", body: string(bomb), encoding: "gzip, identity", code: http.StatusRequestEntityTooLarge},
+		{name: "Malformed", path: "/api/shorten/batch", body: "[]", encoding: "deflate", code: http.StatusBadRequest},
+		{name: "Unsupported", path: "/api/shorten", body: "{}", encoding: "zstd", code: http.StatusUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctype := map[string]string{"Content-Type": "application/json", "Content-Encoding": tt.encoding}
+			response, _ := testRequest(t, ts, jar, http.MethodPost, tt.path, tt.body, ctype)
+			defer response.Body.Close()
+			require.Equal(t, tt.code, response.StatusCode)
+		})
+	}
+}
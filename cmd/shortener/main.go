@@ -1,18 +1,110 @@
-package main
-
-import (
-	"log"
-	"net/http"
-
-	"github.com/t1mon-ggg/go_shortner/app/webhandlers"
-)
-
-func main() {
-	application := webhandlers.NewApp()
-	err := application.NewStorage()
-	if err != nil {
-		log.Fatalln("Coud not set storage", err)
-	}
-	r := application.NewWebProcessor(10)
-	http.ListenAndServe(application.Config.ServerAddress, r)
-}
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/t1mon-ggg/go_shortner/app/webhandlers"
+)
+
+//shutdownTimeout - время на завершение обработки запросов и заданий на удаление
+const shutdownTimeout = 30 * time.Second
+
+func main() {
+	application := webhandlers.NewApp()
+	if flag.NArg() > 0 {
+		err := runCommand(application.Config, flag.Args())
+		if err != nil {
+			log.Fatalln("Command failed:", err)
+		}
+		return
+	}
+	err := application.NewStorage()
+	if err != nil {
+		log.Fatalln("Coud not set storage", err)
+	}
+	err = application.NewPolicy()
+	if err != nil {
+		log.Fatalln("Could not load url policy", err)
+	}
+	r := application.NewWebProcessor(application.Config.DeleteWorkers)
+	server := &http.Server{Addr: application.Config.ServerAddress, Handler: r}
+	if application.Config.EnableHTTPS {
+		server.TLSConfig, err = application.Config.TLSConfig()
+		if err != nil {
+			log.Fatalln("Could not set TLS", err)
+		}
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT)
+	defer stop()
+	go func() {
+		var err error
+		if server.TLSConfig != nil {
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalln("Server failed:", err)
+		}
+	}()
+	var grpcServer *grpc.Server
+	if application.Config.GRPCAddress != "" {
+		opts := make([]grpc.ServerOption, 0)
+		if server.TLSConfig != nil {
+			opts = append(opts, grpc.Creds(credentials.NewTLS(server.TLSConfig)))
+		}
+		grpcServer = application.NewGRPCServer(opts...)
+		listener, err := net.Listen("tcp", application.Config.GRPCAddress)
+		if err != nil {
+			log.Fatalln("Could not listen gRPC address", err)
+		}
+		go func() {
+			err := grpcServer.Serve(listener)
+			if err != nil {
+				log.Fatalln("gRPC server failed:", err)
+			}
+		}()
+	}
+	<-ctx.Done()
+	log.Println("Shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	err = server.Shutdown(shutdownCtx)
+	if err != nil {
+		log.Println("Server shutdown failed:", err)
+	}
+	if grpcServer != nil {
+		stopGRPC(shutdownCtx, grpcServer)
+	}
+	err = application.Shutdown(shutdownCtx)
+	if err != nil {
+		log.Println("Application shutdown failed:", err)
+	}
+	log.Println("Shutdown complete")
+}
+
+//stopGRPC - остановка gRPC сервера с ожиданием завершения обработки запросов не дольше ctx
+func stopGRPC(ctx context.Context, s *grpc.Server) {
+	done := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.Stop()
+	}
+}
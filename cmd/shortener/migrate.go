@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/t1mon-ggg/go_shortner/app/config"
+)
+
+//usage - help for schema migration subcommands
+const usage = `Usage: shortener [flags] <command>
+
+Commands:
+  migrate           apply all pending schema migrations
+  rollback [steps]  revert last applied migrations, 1 by default
+  status            show schema migrations state`
+
+//runCommand - execute schema migration subcommand, command is checked before storage is opened
+func runCommand(cfg *config.Config, args []string) error {
+	steps := 1
+	switch args[0] {
+	case "migrate", "status":
+	case "rollback":
+		if len(args) > 1 {
+			var err error
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("wrong rollback steps count: %s", args[1])
+			}
+		}
+	default:
+		log.Println(usage)
+		return fmt.Errorf("unknown command: %s", args[0])
+	}
+	m, err := cfg.NewMigrator()
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeouts().Migrate)
+	defer cancel()
+	switch args[0] {
+	case "migrate":
+		return m.Migrate(ctx)
+	case "rollback":
+		return m.Rollback(ctx, steps)
+	}
+	status, err := m.MigrationStatus(ctx)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+	for _, s := range status {
+		state, appliedAt := "pending", ""
+		if s.Applied {
+			state, appliedAt = "applied", s.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%04d\t%s\t%s\t%s\n", s.Version, s.Name, state, appliedAt)
+	}
+	return w.Flush()
+}
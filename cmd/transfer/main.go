@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/t1mon-ggg/go_shortner/app/config"
+	"github.com/t1mon-ggg/go_shortner/app/storage"
+)
+
+//command line flags
+var (
+	fromDSN    = flag.String("from-dsn", "", "source DATABASE_DSN")
+	fromSQLite = flag.String("from-sqlite", "", "source SQLITE_PATH")
+	fromKV     = flag.String("from-kv", "", "source KV_STORAGE_PATH")
+	fromFile   = flag.String("from-file", "", "source FILE_STORAGE_PATH")
+	toDSN      = flag.String("to-dsn", "", "target DATABASE_DSN")
+	toSQLite   = flag.String("to-sqlite", "", "target SQLITE_PATH")
+	toKV       = flag.String("to-kv", "", "target KV_STORAGE_PATH")
+	toFile     = flag.String("to-file", "", "target FILE_STORAGE_PATH")
+	dryRun     = flag.Bool("dry-run", false, "read source and report without writing to target")
+	progress   = flag.Int("progress", 1000, "report progress every `n` users, 0 to disable")
+)
+
+//backend - конфигурация хранилища с единственным заданным бэкендом
+func backend(dsn, sqlite, kv, file string) (*config.Config, error) {
+	cfg := &config.Config{Database: dsn, SQLitePath: sqlite, KVStoragePath: kv, FileStoragePath: file}
+	count := 0
+	for _, value := range []string{dsn, sqlite, kv, file} {
+		if value != "" {
+			count++
+		}
+	}
+	if count != 1 {
+		return nil, errors.New("exactly one storage must be set")
+	}
+	return cfg, nil
+}
+
+//run - перенос данных между хранилищами, заданными флагами
+func run() error {
+	srcCfg, err := backend(*fromDSN, *fromSQLite, *fromKV, *fromFile)
+	if err != nil {
+		return fmt.Errorf("wrong source: %w", err)
+	}
+	src, err := srcCfg.NewStorage()
+	if err != nil {
+		return fmt.Errorf("could not open source storage: %w", err)
+	}
+	defer src.Close()
+	var dst storage.Storage
+	if !*dryRun {
+		dstCfg, err := backend(*toDSN, *toSQLite, *toKV, *toFile)
+		if err != nil {
+			return fmt.Errorf("wrong target: %w", err)
+		}
+		dst, err = dstCfg.NewStorage()
+		if err != nil {
+			return fmt.Errorf("could not open target storage: %w", err)
+		}
+		defer dst.Close()
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	report, err := storage.Transfer(ctx, src, dst, storage.TransferOptions{DryRun: *dryRun, Progress: *progress})
+	log.Printf("Transfer report: %d users, %d urls (%d deleted), %d written, %d conflicts\n", report.Users, report.URLs, report.Deleted, report.Written, report.Conflicts)
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		log.Println("Dry run, nothing was written")
+	}
+	return nil
+}
+
+func main() {
+	flag.Parse()
+	err := run()
+	if err != nil {
+		log.Fatalln("Transfer failed:", err)
+	}
+}